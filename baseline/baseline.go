@@ -0,0 +1,224 @@
+package baseline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"whiskers/gem"
+	"whiskers/semgrep"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DateFormat is the format of suppression expiry dates
+const DateFormat = "2006-01-02"
+
+// File is a set of accepted findings, stored as YAML or JSON
+type File struct {
+	Suppressions []Suppression `yaml:"suppressions" json:"suppressions"`
+}
+
+// Suppression accepts the findings of a rule in a gem, optionally limited
+// to a range of versions and a single finding
+type Suppression struct {
+	Gem string `yaml:"gem" json:"gem"`
+	// Versions is a RubyGems requirement, e.g. ">= 1.2, < 2.0". Empty matches every version.
+	Versions string `yaml:"versions,omitempty" json:"versions,omitempty"`
+	Rule     string `yaml:"rule" json:"rule"`
+	// Fingerprint limits the suppression to one finding. Empty matches every finding of the rule.
+	Fingerprint string `yaml:"fingerprint,omitempty" json:"fingerprint,omitempty"`
+	// Path and Lines describe the finding for reviewers and are not used for matching
+	Path          string `yaml:"path,omitempty" json:"path,omitempty"`
+	Lines         string `yaml:"lines,omitempty" json:"lines,omitempty"`
+	Justification string `yaml:"justification" json:"justification"`
+	// Expires is the last day, as YYYY-MM-DD, the suppression applies
+	Expires string `yaml:"expires,omitempty" json:"expires,omitempty"`
+}
+
+// Outcome is the result of applying suppressions to the findings of a gem change
+type Outcome struct {
+	Findings   []*semgrep.Finding
+	Suppressed []*semgrep.Finding
+	// Expired are the suppressions that would have hidden a finding but have expired
+	Expired []Suppression
+}
+
+// Load reads a suppression file. Files ending in .json are parsed as JSON,
+// anything else as YAML.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read suppression file: %w", err)
+	}
+
+	var file File
+	if isJSON(path) {
+		err = json.Unmarshal(data, &file)
+	} else {
+		err = yaml.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse suppression file %s: %w", path, err)
+	}
+
+	for i, s := range file.Suppressions {
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("invalid suppression %d in %s: %w", i+1, path, err)
+		}
+	}
+	return &file, nil
+}
+
+// Save writes the suppression file as JSON or YAML depending on its extension
+func (f *File) Save(path string) error {
+	var data []byte
+	var err error
+	if isJSON(path) {
+		// Keep requirements such as "~> 1.2" readable
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(f)
+		data = buf.Bytes()
+	} else {
+		data, err = yaml.Marshal(f)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode suppression file: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write suppression file: %w", err)
+	}
+	return nil
+}
+
+// Add appends a suppression unless an equivalent one already exists
+func (f *File) Add(s Suppression) bool {
+	for _, existing := range f.Suppressions {
+		if existing.Gem == s.Gem && existing.Versions == s.Versions && existing.Rule == s.Rule && existing.Fingerprint == s.Fingerprint {
+			return false
+		}
+	}
+	f.Suppressions = append(f.Suppressions, s)
+	return true
+}
+
+// Sort orders suppressions by gem, rule and path so generated files diff cleanly
+func (f *File) Sort() {
+	sort.SliceStable(f.Suppressions, func(i, j int) bool {
+		a, b := f.Suppressions[i], f.Suppressions[j]
+		if a.Gem != b.Gem {
+			return a.Gem < b.Gem
+		}
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		return a.Path < b.Path
+	})
+}
+
+// Apply splits the findings of a gem change into those still reported and
+// those suppressed. Findings only matched by expired suppressions resurface.
+func (f *File) Apply(change gem.VersionChange, findings []*semgrep.Finding, now time.Time) *Outcome {
+	outcome := &Outcome{Findings: make([]*semgrep.Finding, 0, len(findings))}
+	expired := make(map[int]bool)
+
+	for _, finding := range findings {
+		suppressed := false
+		for i, s := range f.Suppressions {
+			if !s.Matches(change, finding) {
+				continue
+			}
+			if s.ExpiredAt(now) {
+				expired[i] = true
+				continue
+			}
+			suppressed = true
+			break
+		}
+
+		if suppressed {
+			outcome.Suppressed = append(outcome.Suppressed, finding)
+		} else {
+			outcome.Findings = append(outcome.Findings, finding)
+		}
+	}
+
+	for i, s := range f.Suppressions {
+		if expired[i] {
+			outcome.Expired = append(outcome.Expired, s)
+		}
+	}
+	return outcome
+}
+
+// Matches returns true if the suppression covers the finding in the after version of change
+func (s Suppression) Matches(change gem.VersionChange, finding *semgrep.Finding) bool {
+	if s.Gem != change.Name || s.Rule != finding.RuleID {
+		return false
+	}
+	if s.Fingerprint != "" && s.Fingerprint != finding.Fingerprint {
+		return false
+	}
+	ok, err := gem.MatchesRequirement(change.After.Version, s.Versions)
+	return err == nil && ok
+}
+
+// ExpiredAt returns true if the suppression's expiry date is before now
+func (s Suppression) ExpiredAt(now time.Time) bool {
+	if s.Expires == "" {
+		return false
+	}
+	expires, err := time.ParseInLocation(DateFormat, s.Expires, now.Location())
+	if err != nil {
+		return true
+	}
+	return !now.Before(expires.AddDate(0, 0, 1))
+}
+
+// FromFinding creates a suppression for a single finding in the after version of change
+func FromFinding(change gem.VersionChange, finding *semgrep.Finding, justification, expires string) Suppression {
+	return Suppression{
+		Gem:           change.Name,
+		Rule:          finding.RuleID,
+		Fingerprint:   finding.Fingerprint,
+		Path:          finding.Path,
+		Lines:         strings.Join(strings.Fields(finding.Lines), " "),
+		Justification: justification,
+		Expires:       expires,
+	}
+}
+
+// validate checks that the required fields are present and well formed
+func (s Suppression) validate() error {
+	if s.Gem == "" {
+		return fmt.Errorf("gem is required")
+	}
+	if s.Rule == "" {
+		return fmt.Errorf("rule is required")
+	}
+	if strings.TrimSpace(s.Justification) == "" {
+		return fmt.Errorf("justification is required")
+	}
+	if _, err := gem.MatchesRequirement("0", s.Versions); err != nil {
+		return err
+	}
+	if s.Expires != "" {
+		if _, err := time.Parse(DateFormat, s.Expires); err != nil {
+			return fmt.Errorf("invalid expiry date %q, expected YYYY-MM-DD", s.Expires)
+		}
+	}
+	return nil
+}
+
+// isJSON returns true if the path has a .json extension
+func isJSON(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"whiskers/baseline"
+	"whiskers/gem"
+	"whiskers/scan"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	baselineOutputPath    string
+	baselineScannerFlags  scannerFlags
+	baselineRuleFlags     ruleFlags
+	baselineJustification string
+	baselineExpires       string
+)
+
+var baselineCmd = &cobra.Command{
+	Use:   "baseline",
+	Short: "Manage suppressions of reviewed findings",
+}
+
+var baselineCreateCmd = &cobra.Command{
+	Use:   "create [diff.json]",
+	Short: "Snapshot the current findings of a Gemfile diff as suppressions",
+	Long: `Scan the changed gems of a Gemfile diff and write a suppression for every finding.
+Existing suppressions in the output file are kept.
+For example:
+  whiskers baseline create diff.json
+  whiskers baseline create diff.json --output suppressions.json --expires 2025-12-31`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if baselineExpires != "" {
+			if _, err := time.Parse(baseline.DateFormat, baselineExpires); err != nil {
+				return fmt.Errorf("invalid expiry date %q, expected YYYY-MM-DD", baselineExpires)
+			}
+		}
+		justification := baselineJustification
+		if justification == "" {
+			justification = fmt.Sprintf("Accepted in baseline created on %s", time.Now().Format(baseline.DateFormat))
+		}
+
+		// Load the diff from JSON
+		diff, err := gem.LoadFromJSON(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to load diff from JSON: %w", err)
+		}
+
+		// Keep the suppressions already in the file
+		file := &baseline.File{}
+		if _, err := os.Stat(baselineOutputPath); err == nil {
+			if file, err = baseline.Load(baselineOutputPath); err != nil {
+				return err
+			}
+		}
+
+		changes := diff.GetVersionChanges()
+		sortChanges(changes)
+
+		ruleSet, err := baselineRuleFlags.load()
+		if err != nil {
+			return err
+		}
+		defer ruleSet.Close()
+
+		scanner, err := scan.NewScanner(scan.Options{
+			BaseDir:      cfg.CacheDir,
+			IgnoreFiles:  cfg.Ignore,
+			Rules:        ruleSet,
+			Scanners:     baselineScannerFlags.names,
+			SARIFCommand: baselineScannerFlags.sarifCommand,
+			YARARules:    baselineScannerFlags.yaraRules,
+		})
+		if err != nil {
+			return err
+		}
+		defer scanner.Close()
+
+		fmt.Printf("Scanning %d gems...\n", len(changes))
+		added := 0
+		for _, result := range scanner.ScanChanges(changes) {
+			if result.Err != nil {
+				fmt.Printf("Warning: %s: %v\n", result.Change.Name, result.Err)
+				continue
+			}
+			for _, f := range result.Findings {
+				if file.Add(baseline.FromFinding(result.Change, f, justification, baselineExpires)) {
+					added++
+				}
+			}
+		}
+
+		file.Sort()
+		if err := file.Save(baselineOutputPath); err != nil {
+			return err
+		}
+
+		fmt.Printf("Added %d suppressions to %s\n", added, baselineOutputPath)
+		return nil
+	},
+}
+
+// loadSuppressions reads the suppression file at path, if one was given
+func loadSuppressions(path string) (*baseline.File, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return baseline.Load(path)
+}
+
+// printSuppressions reports the findings hidden by suppressions and the
+// suppressions that have expired, indented by prefix
+func printSuppressions(out io.Writer, result *scan.Result, prefix string, showSuppressed bool) {
+	for _, s := range result.ExpiredSuppressions {
+		fmt.Fprintf(out, "%sWarning: suppression of %s expired on %s: %s\n", prefix, s.Rule, s.Expires, s.Justification)
+	}
+
+	if len(result.Suppressed) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "%s%d findings suppressed\n", prefix, len(result.Suppressed))
+	if showSuppressed {
+		for _, f := range result.Suppressed {
+			fmt.Fprintln(out, prefix+f.Display())
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(baselineCmd)
+	baselineCmd.AddCommand(baselineCreateCmd)
+	baselineCreateCmd.Flags().StringVarP(&baselineOutputPath, "output", "o", ".whiskers-suppressions.yaml", "suppression file to write (.yaml or .json)")
+	baselineRuleFlags.register(baselineCreateCmd)
+	baselineScannerFlags.register(baselineCreateCmd)
+	baselineCreateCmd.Flags().StringVar(&baselineJustification, "justification", "", "justification recorded for each suppression")
+	baselineCreateCmd.Flags().StringVar(&baselineExpires, "expires", "", "expiry date of the suppressions (YYYY-MM-DD)")
+}
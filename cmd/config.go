@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"whiskers/config"
+	"whiskers/gem"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// configPath is the config file given with --config
+	configPath string
+	// cfg is the effective config, loaded before any command runs
+	cfg = config.Default()
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the whiskers configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration",
+	Long: `Print the configuration merged from $HOME/.whiskers.yaml, the repository's
+.whiskers.yaml, the file given with --config and WHISKERS_* environment variables.
+Source passwords are hidden.
+For example:
+  whiskers config show
+  whiskers config show --config ci.whiskers.yaml`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(cfg.Files) == 0 {
+			fmt.Println("# No config files found, using the defaults")
+		}
+		for _, file := range cfg.Files {
+			fmt.Printf("# Loaded %s\n", file)
+		}
+		if len(cfg.Env) > 0 {
+			fmt.Printf("# Overridden by %s\n", strings.Join(cfg.Env, ", "))
+		}
+
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(cfg.Redacted()); err != nil {
+			return fmt.Errorf("failed to print config: %w", err)
+		}
+		return encoder.Close()
+	},
+}
+
+// loadConfig loads the config before a command runs, sets up the
+// credentials of its gem sources and fills in the command's flags from it
+func loadConfig(cmd *cobra.Command, args []string) error {
+	loaded, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+	cfg = loaded
+
+	for _, source := range cfg.Sources {
+		if username, password := source.Credentials(); username != "" || password != "" {
+			gem.SetCredentials(source.URL, gem.Credentials{Username: username, Password: password})
+		}
+	}
+	return applyConfig(cmd.Flags(), cfg)
+}
+
+// configFlag is a flag whose default comes from the config
+type configFlag struct {
+	name   string
+	values []string
+	// list settings only apply to flags that take a list
+	list bool
+}
+
+// configFlags returns the flags the config provides defaults for
+func configFlags(c *config.Config) []configFlag {
+	var trusted []string
+	for _, source := range c.Sources {
+		if source.Trusted {
+			trusted = append(trusted, source.URL)
+		}
+	}
+	return []configFlag{
+		{name: "rule-config", values: []string{c.RuleConfig}},
+		{name: "rules", values: c.Rules, list: true},
+		{name: "scanner", values: c.Scanners, list: true},
+		{name: "sarif-command", values: []string{c.SARIFCommand}},
+		{name: "yara-rules", values: []string{c.YARARules}},
+		{name: "suppressions", values: []string{c.Suppressions}},
+		{name: "trusted-source", values: trusted, list: true},
+		{name: "download-workers", values: []string{strconv.Itoa(c.Concurrency.Downloads)}},
+		{name: "extract-workers", values: []string{strconv.Itoa(c.Concurrency.Extractions)}},
+		{name: "scan-workers", values: []string{strconv.Itoa(c.Concurrency.Scans)}},
+		{name: "format", values: []string{c.Format}},
+		{name: "fail-on", values: []string{c.FailOn}},
+		{name: "fail-on-incomplete", values: []string{strconv.FormatBool(c.FailOnIncomplete)}},
+		{name: "min-severity", values: []string{c.MinSeverity}},
+		{name: "policy", values: []string{c.Policy}},
+	}
+}
+
+// applyConfig sets the flags that weren't given on the command line to
+// their configured values
+func applyConfig(flags *pflag.FlagSet, c *config.Config) error {
+	for _, setting := range configFlags(c) {
+		flag := flags.Lookup(setting.name)
+		if flag == nil || flag.Changed {
+			continue
+		}
+
+		slice, isList := flag.Value.(pflag.SliceValue)
+		if setting.list != isList {
+			continue
+		}
+		if isList {
+			if err := slice.Replace(setting.values); err != nil {
+				return fmt.Errorf("invalid configured --%s: %w", setting.name, err)
+			}
+			continue
+		}
+		if setting.values[0] == "" {
+			continue
+		}
+		if err := flag.Value.Set(setting.values[0]); err != nil {
+			return fmt.Errorf("invalid configured --%s: %w", setting.name, err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"whiskers/policy"
+	"whiskers/report"
+	"whiskers/semgrep"
+	"whiskers/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the scan commands
+const (
+	// ExitClean means no new findings at or above the --fail-on severity
+	ExitClean = 0
+	// ExitError means the command itself failed, e.g. on a bad flag
+	ExitError = 1
+	// ExitFindings means new findings at or above the --fail-on severity
+	ExitFindings = 2
+	// ExitIncomplete means some gems or files could not be scanned, with
+	// --fail-on-incomplete and no findings to fail on
+	ExitIncomplete = 3
+	// ExitDenied means the --policy denied at least one gem change
+	ExitDenied = 4
+)
+
+// exitCodesHelp documents the exit codes in the help of the scan commands
+const exitCodesHelp = `
+
+Exit codes:
+  0  no new findings at or above the --fail-on severity
+  1  the command failed
+  2  new findings at or above the --fail-on severity
+  3  some gems or files could not be scanned (with --fail-on-incomplete)
+  4  the --policy denied a gem change
+
+--fail-on defaults to none, so findings only fail a scan once it is set. A
+policy denial takes precedence over findings, and findings over incomplete
+scans.`
+
+// failOnNone disables failing on findings
+const failOnNone = "none"
+
+// exitCode is the code whiskers exits with once a command succeeds
+var exitCode = ExitClean
+
+// failFlags are the flags that decide when a scan command fails
+type failFlags struct {
+	failOn           string
+	failOnIncomplete bool
+	policyPath       string
+	// policy is loaded from policyPath by validate
+	policy *policy.Policy
+}
+
+// register adds the failure threshold flags to a command
+func (f *failFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.failOn, "fail-on", failOnNone, "exit with code 2 on new findings at least this severe (INFO, WARNING, ERROR or none)")
+	cmd.Flags().BoolVar(&f.failOnIncomplete, "fail-on-incomplete", false, "exit with code 3 if any gem or file could not be scanned")
+	cmd.Flags().StringVar(&f.policyPath, "policy", "", "policy file deciding whether the changes are allowed, exiting with code 4 on deny")
+}
+
+// validate checks the --fail-on severity is one whiskers knows, and loads the --policy
+func (f *failFlags) validate() error {
+	if !strings.EqualFold(f.failOn, failOnNone) && semgrep.SeverityLevel(f.failOn) == 0 {
+		return fmt.Errorf("unknown --fail-on severity %q (expected INFO, WARNING, ERROR or none)", f.failOn)
+	}
+	if f.policyPath != "" {
+		p, err := policy.Load(f.policyPath)
+		if err != nil {
+			return err
+		}
+		f.policy = p
+	}
+	return nil
+}
+
+// decide evaluates the --policy against a report, if one was given, and
+// prints its decisions
+func (f *failFlags) decide(out io.Writer, r *report.Report) {
+	if f.policy == nil {
+		return
+	}
+	r.ApplyPolicy(f.policy)
+	printPolicy(out, r.Policy)
+}
+
+// check sets the exit code for a report, explaining why the scan failed
+func (f *failFlags) check(out io.Writer, r *report.Report) {
+	if denied := r.Policy.Denied(); denied > 0 {
+		fmt.Fprintf(out, "\nFailing: the policy denied %s\n", utils.Plural(denied, "gem change"))
+		exitCode = ExitDenied
+		return
+	}
+
+	if !strings.EqualFold(f.failOn, failOnNone) {
+		failing := 0
+		for _, g := range r.Gems {
+			for _, finding := range g.Findings {
+				if finding.AtLeast(f.failOn) {
+					failing++
+				}
+			}
+		}
+		if failing > 0 {
+			fmt.Fprintf(out, "\nFailing: %s at or above %s\n", utils.Plural(failing, "new finding"), strings.ToUpper(f.failOn))
+			exitCode = ExitFindings
+			return
+		}
+	}
+
+	if incomplete := r.IncompleteGems(); f.failOnIncomplete && incomplete > 0 {
+		fmt.Fprintf(out, "\nFailing: %s could not be fully scanned\n", utils.Plural(incomplete, "gem"))
+		exitCode = ExitIncomplete
+	}
+}
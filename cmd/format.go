@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"whiskers/report"
+
+	"github.com/spf13/cobra"
+)
+
+// Output formats of the scan commands
+const (
+	formatText     = "text"
+	formatSARIF    = "sarif"
+	formatMarkdown = "markdown"
+	formatHTML     = "html"
+	formatJUnit    = "junit"
+)
+
+// formatFlags are the flags that select how a scan command reports its results
+type formatFlags struct {
+	format string
+	output string
+}
+
+// register adds the output format flags to a command
+func (f *formatFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.format, "format", formatText, "output format (text, sarif, markdown, html or junit)")
+	cmd.Flags().StringVarP(&f.output, "output", "o", "", "save a JSON report of the scan to this file")
+}
+
+// validate checks the format is one whiskers can write
+func (f *formatFlags) validate() error {
+	return validateFormat(f.format)
+}
+
+// validateFormat checks a format is one whiskers can write
+func validateFormat(format string) error {
+	switch format {
+	case formatText, formatSARIF, formatMarkdown, formatHTML, formatJUnit:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q (expected text, sarif, markdown, html or junit)", format)
+}
+
+// log returns where human-readable output goes. Reports in other formats
+// are written to stdout, so the text moves to stderr.
+func (f *formatFlags) log() io.Writer {
+	if f.format == formatText {
+		return os.Stdout
+	}
+	return os.Stderr
+}
+
+// write saves the JSON report if --output was given, and prints the report
+// to stdout unless the format is text
+func (f *formatFlags) write(r *report.Report) error {
+	if f.output != "" {
+		if err := r.Save(f.output); err != nil {
+			return err
+		}
+		fmt.Fprintf(f.log(), "\nReport saved to %s\n", f.output)
+	}
+
+	return render(os.Stdout, f.format, r)
+}
+
+// render writes a report in any format but text, which the commands print themselves
+func render(w io.Writer, format string, r *report.Report) error {
+	switch format {
+	case formatSARIF:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report.SARIF(r)); err != nil {
+			return fmt.Errorf("failed to write SARIF: %w", err)
+		}
+	case formatMarkdown:
+		if _, err := io.WriteString(w, report.Markdown(r, report.MarkdownOptions{})); err != nil {
+			return fmt.Errorf("failed to write markdown: %w", err)
+		}
+	case formatHTML:
+		return report.HTML(w, r)
+	case formatJUnit:
+		return report.JUnit(w, r)
+	}
+	return nil
+}
@@ -39,7 +39,7 @@ For example:
 		gem2 := gem.NewGem(name, version2, source)
 
 		// Create base directory for downloads
-		baseDir := "/tmp/gems"
+		baseDir := cfg.CacheDir
 
 		// Download and extract both versions
 		fmt.Printf("Downloading %s (%s)...\n", name, version1)
@@ -57,13 +57,7 @@ For example:
 		path2 := filepath.Join(baseDir, fmt.Sprintf("%s-%s", name, version2))
 
 		// Compare the directories
-		ignoreFiles := []string{
-			"Gemfile.lock",
-			".gitignore",
-			"gem.deps.rb",
-		}
-
-		diff, err := utils.ComparePaths(path1, path2, ignoreFiles)
+		diff, err := utils.ComparePaths(path1, path2, cfg.Ignore)
 		if err != nil {
 			return fmt.Errorf("failed to compare gem versions: %w", err)
 		}
@@ -2,17 +2,27 @@ package cmd
 
 import (
 	"fmt"
-	"path/filepath"
+	"io"
+	"os"
+	"time"
 	"whiskers/gem"
+	"whiskers/report"
+	"whiskers/scan"
 	"whiskers/semgrep"
-	"whiskers/utils"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	gemDiffScanSourceURL string
-	rulesPath           string
+	gemDiffScanSourceURL      string
+	gemDiffScanScannerFlags   scannerFlags
+	gemDiffScanRuleFlags      ruleFlags
+	gemDiffScanFormatFlags    formatFlags
+	gemDiffScanFailFlags      failFlags
+	gemDiffScanMinSeverity    string
+	gemDiffScanSuppressions   string
+	gemDiffScanShowSuppressed bool
+	gemDiffScanTrustedSources []string
 )
 
 var gemDiffScanCmd = &cobra.Command{
@@ -21,9 +31,22 @@ var gemDiffScanCmd = &cobra.Command{
 	Long: `Download and compare two versions of a Ruby gem, then run semgrep on the changes to find new issues.
 For example:
   whiskers gem-diff-scan rails 7.0.0 7.0.8.5
-  whiskers gem-diff-scan rails 7.0.0 7.0.8.5 --rules ./my-rules`,
+  whiskers gem-diff-scan rails 7.0.0 7.0.8.5 --rules ./my-rules
+  whiskers gem-diff-scan rails 7.0.0 7.0.8.5 --fail-on WARNING` + exitCodesHelp,
 	Args: cobra.ExactArgs(3),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := gemDiffScanFormatFlags.validate(); err != nil {
+			return err
+		}
+		if err := gemDiffScanFailFlags.validate(); err != nil {
+			return err
+		}
+		if err := validateMinSeverity(gemDiffScanMinSeverity); err != nil {
+			return err
+		}
+		out := gemDiffScanFormatFlags.log()
+		started := time.Now()
+
 		name := args[0]
 		version1 := args[1]
 		version2 := args[2]
@@ -37,112 +60,132 @@ For example:
 			}
 		}
 
-		gem1 := gem.NewGem(name, version1, source)
-		gem2 := gem.NewGem(name, version2, source)
-
-		// Create base directory for downloads
-		baseDir := "/tmp/gems"
-
-		// Download and extract both versions
-		fmt.Printf("Downloading %s (%s)...\n", name, version1)
-		if err := gem1.DownloadAndExtract(baseDir); err != nil {
-			return fmt.Errorf("failed to download %s version %s: %w", name, version1, err)
-		}
-
-		fmt.Printf("Downloading %s (%s)...\n", name, version2)
-		if err := gem2.DownloadAndExtract(baseDir); err != nil {
-			return fmt.Errorf("failed to download %s version %s: %w", name, version2, err)
+		change := gem.VersionChange{
+			Name:   name,
+			Before: gem.NewGem(name, version1, source),
+			After:  gem.NewGem(name, version2, source),
 		}
 
-		// Get paths to the extracted gems
-		path1 := filepath.Join(baseDir, fmt.Sprintf("%s-%s", name, version1))
-		path2 := filepath.Join(baseDir, fmt.Sprintf("%s-%s", name, version2))
-
-		// Compare the directories
-		ignoreFiles := []string{
-			"Gemfile.lock",
-			".gitignore",
-			"gem.deps.rb",
-		}
-
-		diff, err := utils.ComparePaths(path1, path2, ignoreFiles)
+		suppressions, err := loadSuppressions(gemDiffScanSuppressions)
 		if err != nil {
-			return fmt.Errorf("failed to compare gem versions: %w", err)
-		}
-
-		if !diff.HasChanges() {
-			fmt.Printf("\nNo changes found between %s and %s\n", version1, version2)
-			return nil
-		}
-
-		// Create semgrep runner
-		runner := semgrep.NewRunner(rulesPath)
-
-		// Get files to scan (changed + added)
-		var filesToScan1 []string
-		var filesToScan2 []string
-
-		// Add changed files
-		for _, file := range diff.Changed {
-			filesToScan1 = append(filesToScan1, filepath.Join(path1, file))
-			filesToScan2 = append(filesToScan2, filepath.Join(path2, file))
+			return err
 		}
 
-		// Add new files (only in version 2)
-		for _, file := range diff.Added {
-			filesToScan2 = append(filesToScan2, filepath.Join(path2, file))
+		ruleSet, err := gemDiffScanRuleFlags.load()
+		if err != nil {
+			return err
 		}
-
-		// Run semgrep on both versions
-		fmt.Printf("\nScanning files in version %s...\n", version1)
-		findings1, err := runner.Scan(filesToScan1)
+		defer ruleSet.Close()
+		fmt.Fprintf(out, "Using rules: %s\n", ruleSet.Describe())
+
+		// Download, compare and scan both versions
+		scanner, err := scan.NewScanner(scan.Options{
+			BaseDir:        cfg.CacheDir,
+			IgnoreFiles:    cfg.Ignore,
+			Rules:          ruleSet,
+			Scanners:       gemDiffScanScannerFlags.names,
+			SARIFCommand:   gemDiffScanScannerFlags.sarifCommand,
+			YARARules:      gemDiffScanScannerFlags.yaraRules,
+			MinSeverity:    gemDiffScanMinSeverity,
+			Suppressions:   suppressions,
+			TrustedSources: gemDiffScanTrustedSources,
+			Logf: func(format string, args ...interface{}) {
+				fmt.Fprintf(out, format, args...)
+			},
+		})
 		if err != nil {
-			return fmt.Errorf("failed to scan version %s: %w", version1, err)
+			return err
 		}
+		defer scanner.Close()
+		fmt.Fprintf(out, "Using scanners: %s\n", scanner.Backend())
 
-		fmt.Printf("Scanning files in version %s...\n", version2)
-		findings2, err := runner.Scan(filesToScan2)
+		// A gem that can't be downloaded or scanned is incomplete, as in
+		// gemfile-diff-scan, so --fail-on-incomplete decides the exit code
+		result, err := scanner.ScanChange(change)
 		if err != nil {
-			return fmt.Errorf("failed to scan version %s: %w", version2, err)
+			result = &scan.Result{Change: change, Err: fmt.Errorf("failed to scan %s: %w", name, err)}
 		}
 
-		// Create map of findings in version 1 for easy comparison
-		oldFindings := make(map[string]bool)
-		for _, f := range findings1 {
-			key := fmt.Sprintf("%s:%s:%s", f.Path, f.RuleID, f.Lines)
-			oldFindings[key] = true
-		}
+		printGemDiffScanResult(out, result)
 
-		// Find new findings
-		var newFindings []*semgrep.Finding
-		for _, f := range findings2 {
-			key := fmt.Sprintf("%s:%s:%s", f.Path, f.RuleID, f.Lines)
-			if !oldFindings[key] {
-				newFindings = append(newFindings, f)
-			}
+		r := report.New("gem-diff-scan", []*scan.Result{result}, nil, ruleSet,
+			report.Tool{Version: Version, Scanners: scanner.Backend()}, cfg.CacheDir, started)
+		r.ScoreRisk(cfg.Risk)
+		printRisk(out, r)
+		gemDiffScanFailFlags.decide(out, r)
+		if err := gemDiffScanFormatFlags.write(r); err != nil {
+			return err
 		}
+		gemDiffScanFailFlags.check(os.Stderr, r)
+		return nil
+	},
+}
 
-		// Print results
-		if len(newFindings) == 0 {
-			fmt.Println("\nNo new issues found!")
-			return nil
+// printGemDiffScanResult prints the outcome of scanning a gem change as text
+func printGemDiffScanResult(out io.Writer, result *scan.Result) {
+	if result.Err != nil {
+		fmt.Fprintf(out, "\nWarning: %v\n", result.Err)
+		return
+	}
+
+	if !result.Diff.HasChanges() {
+		fmt.Fprintf(out, "\nNo changes found between %s and %s\n", result.Change.Before.Version, result.Change.After.Version)
+		return
+	}
+
+	if len(result.LoadTimeFiles) > 0 {
+		fmt.Fprintf(out, "\nChanged files that run on require:\n")
+		for _, file := range result.LoadTimeFiles {
+			fmt.Fprintf(out, "  ! %s\n", file)
 		}
+	}
 
-		fmt.Printf("\nFound %d new issues:\n", len(newFindings))
-		for _, f := range newFindings {
-			// Make the path relative to the gem root
-			if err := f.Rebase(path2); err != nil {
-				return fmt.Errorf("failed to rebase path: %w", err)
-			}
-			fmt.Println(f.Display())
+	for _, e := range result.RuleErrors {
+		fmt.Fprintf(out, "\nWarning: semgrep rule error: %s\n", e.Display())
+	}
+
+	// Files semgrep couldn't analyze may hide issues it would have found
+	if len(result.Unanalyzed) > 0 {
+		fmt.Fprintf(out, "\n%d files could not be analyzed:\n", len(result.Unanalyzed))
+		for _, e := range result.Unanalyzed {
+			fmt.Fprintf(out, "  ? %s (%s)\n", e.Path, e.Display())
 		}
+	}
+
+	if len(result.Suppressed) > 0 || len(result.ExpiredSuppressions) > 0 {
+		fmt.Fprintln(out)
+		printSuppressions(out, result, "", gemDiffScanShowSuppressed)
+	}
+
+	// Print results
+	if len(result.Findings) == 0 {
+		fmt.Fprintln(out, "\nNo new issues found!")
+		return
+	}
+
+	fmt.Fprintf(out, "\nFound %d new issues:\n", len(result.Findings))
+	for _, f := range result.Findings {
+		fmt.Fprintln(out, f.Display())
+	}
+}
 
-		return nil
-	},
+// validateMinSeverity checks the --min-severity flag names a known severity
+func validateMinSeverity(minSeverity string) error {
+	if minSeverity != "" && semgrep.SeverityLevel(minSeverity) == 0 {
+		return fmt.Errorf("unknown --min-severity %q (expected INFO, WARNING or ERROR)", minSeverity)
+	}
+	return nil
 }
 
 func init() {
 	rootCmd.AddCommand(gemDiffScanCmd)
 	gemDiffScanCmd.Flags().StringVarP(&gemDiffScanSourceURL, "source", "s", "", "gem source URL (default is RubyGems.org)")
-	gemDiffScanCmd.Flags().StringVarP(&rulesPath, "rules", "r", "./semgrep-rules", "path to semgrep rules")
-} 
+	gemDiffScanRuleFlags.register(gemDiffScanCmd)
+	gemDiffScanFormatFlags.register(gemDiffScanCmd)
+	gemDiffScanFailFlags.register(gemDiffScanCmd)
+	gemDiffScanScannerFlags.register(gemDiffScanCmd)
+	gemDiffScanCmd.Flags().StringVar(&gemDiffScanSuppressions, "suppressions", "", "suppression file of reviewed findings to hide")
+	gemDiffScanCmd.Flags().BoolVar(&gemDiffScanShowSuppressed, "show-suppressed", false, "list suppressed findings instead of only counting them")
+	gemDiffScanCmd.Flags().StringSliceVar(&gemDiffScanTrustedSources, "trusted-source", nil, "gem source URL whose gems may use whiskers:ignore annotations (repeatable)")
+	gemDiffScanCmd.Flags().StringVar(&gemDiffScanMinSeverity, "min-severity", "", "only report findings at least this severe (INFO, WARNING or ERROR)")
+}
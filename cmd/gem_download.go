@@ -37,7 +37,7 @@ For example:
 		g := gem.NewGem(name, version, source)
 
 		// Create the base directory for gems
-		baseDir := "/tmp/gems"
+		baseDir := cfg.CacheDir
 		if err := os.MkdirAll(baseDir, 0755); err != nil {
 			return fmt.Errorf("failed to create gems directory: %w", err)
 		}
@@ -2,17 +2,33 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 	"whiskers/gem"
-	"whiskers/semgrep"
-	"whiskers/utils"
+	"whiskers/report"
+	"whiskers/risk"
+	"whiskers/scan"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	gemfileDiffScanRulesPath string
+	gemfileDiffScanScannerFlags    scannerFlags
+	gemfileDiffScanRuleFlags       ruleFlags
+	gemfileDiffScanFormatFlags     formatFlags
+	gemfileDiffScanFailFlags       failFlags
+	gemfileDiffScanDownloadWorkers int
+	gemfileDiffScanExtractWorkers  int
+	gemfileDiffScanScanWorkers     int
+	gemfileDiffScanBatch           bool
+	gemfileDiffScanMinSeverity     string
+	gemfileDiffScanSuppressions    string
+	gemfileDiffScanShowSuppressed  bool
+	gemfileDiffScanTrustedSources  []string
 )
 
 var gemfileDiffScanCmd = &cobra.Command{
@@ -21,9 +37,24 @@ var gemfileDiffScanCmd = &cobra.Command{
 	Long: `Load a Gemfile diff from a JSON file, download changed gems, and scan for new security issues.
 For example:
   whiskers gemfile-diff-scan diff.json
-  whiskers gemfile-diff-scan diff.json --rules ./my-rules`,
+  whiskers gemfile-diff-scan diff.json --rules ./my-rules
+  whiskers gemfile-diff-scan diff.json --download-workers 8 --scan-workers 4
+  whiskers gemfile-diff-scan diff.json --batch
+  whiskers gemfile-diff-scan diff.json --fail-on ERROR --fail-on-incomplete` + exitCodesHelp,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := gemfileDiffScanFormatFlags.validate(); err != nil {
+			return err
+		}
+		if err := gemfileDiffScanFailFlags.validate(); err != nil {
+			return err
+		}
+		if err := validateMinSeverity(gemfileDiffScanMinSeverity); err != nil {
+			return err
+		}
+		out := gemfileDiffScanFormatFlags.log()
+		started := time.Now()
+
 		diffPath := args[0]
 
 		// Check if file exists
@@ -38,171 +69,244 @@ For example:
 		}
 
 		// Print the diff summary
-		printDiffSummary(diff)
-
-		// Create semgrep runner
-		runner := semgrep.NewRunner(gemfileDiffScanRulesPath)
+		printDiffSummary(out, diff)
 
 		// Process version changes
 		changes := diff.GetVersionChanges()
 		if len(changes) == 0 {
-			fmt.Println("\nNo version changes to scan")
+			fmt.Fprintln(out, "\nNo version changes to scan")
+			r := report.New("gemfile-diff-scan", nil, diff, nil, report.Tool{Version: Version}, cfg.CacheDir, started)
+			gemfileDiffScanFailFlags.decide(out, r)
+			if err := gemfileDiffScanFormatFlags.write(r); err != nil {
+				return err
+			}
+			gemfileDiffScanFailFlags.check(os.Stderr, r)
 			return nil
 		}
 
-		fmt.Printf("\nScanning %d gems for security changes...\n", len(changes))
+		fmt.Fprintf(out, "\nScanning %d gems for security changes...\n", len(changes))
 
-		// Map to store findings by gem
-		newFindingsByGem := make(map[string][]*semgrep.Finding)
+		// Scan in a stable order so the output is deterministic
+		sortChanges(changes)
 
-		// Create base directory for downloads
-		baseDir := "/tmp/gems"
-
-		// Process each changed gem
-		for _, change := range changes {
-			fmt.Printf("\nAnalyzing %s (%s → %s)...\n", change.Name, change.Before.Version, change.After.Version)
+		suppressions, err := loadSuppressions(gemfileDiffScanSuppressions)
+		if err != nil {
+			return err
+		}
 
-			// Download and extract both versions
-			fmt.Printf("  Downloading version %s...\n", change.Before.Version)
-			if err := change.Before.DownloadAndExtract(baseDir); err != nil {
-				fmt.Printf("  Warning: failed to download version %s: %v\n", change.Before.Version, err)
-				continue
-			}
+		ruleSet, err := gemfileDiffScanRuleFlags.load()
+		if err != nil {
+			return err
+		}
+		defer ruleSet.Close()
+		fmt.Fprintf(out, "Using rules: %s\n", ruleSet.Describe())
+
+		// Report per-gem progress as the pipeline runs
+		var progressMu sync.Mutex
+		finished := 0
+		scanner, err := scan.NewScanner(scan.Options{
+			BaseDir:        cfg.CacheDir,
+			IgnoreFiles:    cfg.Ignore,
+			Rules:          ruleSet,
+			Scanners:       gemfileDiffScanScannerFlags.names,
+			SARIFCommand:   gemfileDiffScanScannerFlags.sarifCommand,
+			YARARules:      gemfileDiffScanScannerFlags.yaraRules,
+			MinSeverity:    gemfileDiffScanMinSeverity,
+			Suppressions:   suppressions,
+			TrustedSources: gemfileDiffScanTrustedSources,
+			Concurrency: scan.Concurrency{
+				Downloads:   gemfileDiffScanDownloadWorkers,
+				Extractions: gemfileDiffScanExtractWorkers,
+				Scans:       gemfileDiffScanScanWorkers,
+			},
+			Batch: gemfileDiffScanBatch,
+			Logf: func(format string, args ...interface{}) {
+				fmt.Fprintf(os.Stderr, format, args...)
+			},
+			Progress: func(change gem.VersionChange, stage scan.Stage) {
+				progressMu.Lock()
+				defer progressMu.Unlock()
+				if stage == scan.StageDone || stage == scan.StageFailed {
+					finished++
+				}
+				if stage != scan.StageQueued {
+					fmt.Fprintf(os.Stderr, "[%d/%d] %s: %s\n", finished, len(changes), change.Name, stage)
+				}
+			},
+		})
+		if err != nil {
+			return err
+		}
+		defer scanner.Close()
+		fmt.Fprintf(out, "Using scanners: %s\n", scanner.Backend())
 
-			fmt.Printf("  Downloading version %s...\n", change.After.Version)
-			if err := change.After.DownloadAndExtract(baseDir); err != nil {
-				fmt.Printf("  Warning: failed to download version %s: %v\n", change.After.Version, err)
-				continue
-			}
+		results := scanner.ScanChanges(changes)
 
-			// Get paths to the extracted gems
-			beforePath := filepath.Join(baseDir, fmt.Sprintf("%s-%s", change.Name, change.Before.Version))
-			afterPath := filepath.Join(baseDir, fmt.Sprintf("%s-%s", change.Name, change.After.Version))
-
-			// Compare the directories
-			diff, err := utils.ComparePaths(beforePath, afterPath, []string{
-				"Gemfile.lock",
-				".gitignore",
-				"gem.deps.rb",
-			})
-			if err != nil {
-				fmt.Printf("  Warning: failed to compare versions: %v\n", err)
-				continue
-			}
+		r := report.New("gemfile-diff-scan", results, diff, ruleSet,
+			report.Tool{Version: Version, Scanners: scanner.Backend()}, cfg.CacheDir, started)
+		r.ScoreRisk(cfg.Risk)
 
-			if !diff.HasChanges() {
-				fmt.Println("  No file changes found")
-				continue
-			}
+		// Print the riskiest gems first
+		sortResultsByRisk(results, r)
+		printScanResults(out, results, gemfileDiffScanShowSuppressed)
+		printRisk(out, r)
 
-			// Get files to scan
-			var filesToScan1 []string
-			var filesToScan2 []string
+		gemfileDiffScanFailFlags.decide(out, r)
+		if err := gemfileDiffScanFormatFlags.write(r); err != nil {
+			return err
+		}
+		gemfileDiffScanFailFlags.check(os.Stderr, r)
+		return nil
+	},
+}
 
-			// Add changed files
-			for _, file := range diff.Changed {
-				filesToScan1 = append(filesToScan1, filepath.Join(beforePath, file))
-				filesToScan2 = append(filesToScan2, filepath.Join(afterPath, file))
+// printScanResults prints each gem's progress log and outcome in order,
+// followed by the new findings of every gem
+func printScanResults(out io.Writer, results []*scan.Result, showSuppressed bool) {
+	var gemsWithFindings []*scan.Result
+	for _, result := range results {
+		change := result.Change
+		fmt.Fprintf(out, "\nAnalyzing %s (%s → %s)...\n", change.Name, change.Before.Version, change.After.Version)
+		for _, line := range strings.SplitAfter(result.Log, "\n") {
+			if line != "" {
+				fmt.Fprint(out, "  "+line)
 			}
+		}
 
-			// Add new files (only in version 2)
-			for _, file := range diff.Added {
-				filesToScan2 = append(filesToScan2, filepath.Join(afterPath, file))
-			}
+		if result.Err != nil {
+			fmt.Fprintf(out, "  Warning: %v\n", result.Err)
+			continue
+		}
 
-			// Run semgrep on both versions
-			fmt.Printf("  Scanning files in version %s...\n", change.Before.Version)
-			findings1, err := runner.Scan(filesToScan1)
-			if err != nil {
-				fmt.Printf("  Warning: failed to scan version %s: %v\n", change.Before.Version, err)
-				continue
-			}
+		if !result.Diff.HasChanges() {
+			fmt.Fprintln(out, "  No file changes found")
+			continue
+		}
 
-			fmt.Printf("  Scanning files in version %s...\n", change.After.Version)
-			findings2, err := runner.Scan(filesToScan2)
-			if err != nil {
-				fmt.Printf("  Warning: failed to scan version %s: %v\n", change.After.Version, err)
-				continue
-			}
+		if len(result.LoadTimeFiles) > 0 {
+			fmt.Fprintf(out, "  %d changed files run on require\n", len(result.LoadTimeFiles))
+		}
 
-			// Create map of findings in version 1
-			oldFindings := make(map[string]bool)
-			for _, f := range findings1 {
-				key := fmt.Sprintf("%s:%s:%s", f.Path, f.RuleID, f.Lines)
-				oldFindings[key] = true
-			}
+		for _, e := range result.RuleErrors {
+			fmt.Fprintf(out, "  Warning: semgrep rule error: %s\n", e.Display())
+		}
 
-			// Find new findings
-			var newFindings []*semgrep.Finding
-			for _, f := range findings2 {
-				key := fmt.Sprintf("%s:%s:%s", f.Path, f.RuleID, f.Lines)
-				if !oldFindings[key] {
-					if err := f.Rebase(afterPath); err != nil {
-						fmt.Printf("  Warning: failed to rebase path: %v\n", err)
-						continue
-					}
-					newFindings = append(newFindings, f)
-				}
+		if len(result.Unanalyzed) > 0 {
+			fmt.Fprintf(out, "  %d files could not be analyzed\n", len(result.Unanalyzed))
+			for _, e := range result.Unanalyzed {
+				fmt.Fprintf(out, "    ? %s (%s)\n", e.Path, e.Display())
 			}
+		}
 
-			if len(newFindings) > 0 {
-				newFindingsByGem[change.Name] = newFindings
-			}
+		printSuppressions(out, result, "  ", showSuppressed)
+
+		if len(result.Findings) > 0 {
+			gemsWithFindings = append(gemsWithFindings, result)
 		}
+	}
 
-		// Print results
-		if len(newFindingsByGem) == 0 {
-			fmt.Println("\nNo new security issues found!")
-			return nil
+	// Print results
+	if len(gemsWithFindings) == 0 {
+		fmt.Fprintln(out, "\nNo new security issues found!")
+		return
+	}
+
+	fmt.Fprintln(out, "\nNew security issues found:")
+	for _, result := range gemsWithFindings {
+		fmt.Fprintf(out, "\n%s:\n", result.Change.Name)
+		for _, f := range result.Findings {
+			fmt.Fprintln(out, f.Display())
 		}
+	}
+}
 
-		fmt.Println("\nNew security issues found:")
-		for gemName, findings := range newFindingsByGem {
-			fmt.Printf("\n%s:\n", gemName)
-			for _, f := range findings {
-				fmt.Println(f.Display())
-			}
+// sortResultsByRisk orders scan results like the gems of their report,
+// which ScoreRisk orders from riskiest to safest
+func sortResultsByRisk(results []*scan.Result, r *report.Report) {
+	rank := make(map[string]int, len(r.Gems))
+	for i, g := range r.Gems {
+		rank[g.Name] = i
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return rank[results[i].Change.Name] < rank[results[j].Change.Name]
+	})
+}
+
+// printRisk prints the risk score of each scored gem, riskiest first, with
+// the factors adding up to it
+func printRisk(out io.Writer, r *report.Report) {
+	printed := false
+	for _, g := range r.Gems {
+		if g.Risk == nil {
+			continue
+		}
+		if !printed {
+			fmt.Fprintln(out, "\nRisk scores:")
+			printed = true
+		}
+		fmt.Fprintf(out, "  %6g  %s (%s → %s)\n", g.Risk.Score, g.Name, g.Before.Version, g.After.Version)
+		printRiskFactors(out, g.Risk)
+	}
+	for _, name := range r.RiskyAddedGems() {
+		if !printed {
+			fmt.Fprintln(out, "\nRisk scores:")
+			printed = true
 		}
+		score := r.AddedRisk[name]
+		fmt.Fprintf(out, "  %6g  %s (added)\n", score.Score, name)
+		printRiskFactors(out, score)
+	}
+}
 
-		return nil
-	},
+// printRiskFactors prints the factors that add up to a risk score
+func printRiskFactors(out io.Writer, score *risk.Score) {
+	for _, factor := range score.Factors {
+		fmt.Fprintf(out, "          +%g %s: %s\n", factor.Points, factor.Name, factor.Detail)
+	}
+}
+
+// sortChanges orders version changes by gem name
+func sortChanges(changes []gem.VersionChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
 }
 
-func printDiffSummary(diff *gem.GemfileDiff) {
+func printDiffSummary(out io.Writer, diff *gem.GemfileDiff) {
 	// Print added gems
 	if added := diff.GetAddedGems(); len(added) > 0 {
-		fmt.Println("\nAdded gems:")
+		fmt.Fprintln(out, "\nAdded gems:")
 		for _, gem := range added {
-			fmt.Printf("  + %s (%s)\n", gem.Name, gem.Version)
+			fmt.Fprintf(out, "  + %s (%s)\n", gem.Name, gem.Version)
 			if !gem.IsFromRubyGems() {
-				fmt.Printf("    source: %s (%s)\n", gem.Source.URL, gem.Source.Type)
+				fmt.Fprintf(out, "    source: %s (%s)\n", gem.Source.URL, gem.Source.Type)
 			}
 		}
 	}
 
 	// Print removed gems
 	if removed := diff.GetRemovedGems(); len(removed) > 0 {
-		fmt.Println("\nRemoved gems:")
+		fmt.Fprintln(out, "\nRemoved gems:")
 		for _, gem := range removed {
-			fmt.Printf("  - %s (%s)\n", gem.Name, gem.Version)
+			fmt.Fprintf(out, "  - %s (%s)\n", gem.Name, gem.Version)
 			if !gem.IsFromRubyGems() {
-				fmt.Printf("    source: %s (%s)\n", gem.Source.URL, gem.Source.Type)
+				fmt.Fprintf(out, "    source: %s (%s)\n", gem.Source.URL, gem.Source.Type)
 			}
 		}
 	}
 
 	// Print version changes
 	if changes := diff.GetVersionChanges(); len(changes) > 0 {
-		fmt.Println("\nVersion changes:")
+		fmt.Fprintln(out, "\nVersion changes:")
 		for _, change := range changes {
-			fmt.Printf("  ~ %s: %s → %s\n",
+			fmt.Fprintf(out, "  ~ %s: %s → %s\n",
 				change.Name,
 				change.Before.Version,
 				change.After.Version)
 
 			if !change.Before.IsFromRubyGems() || !change.After.IsFromRubyGems() {
 				if change.Before.Source != change.After.Source {
-					fmt.Printf("    source changed: %s (%s) → %s (%s)\n",
+					fmt.Fprintf(out, "    source changed: %s (%s) → %s (%s)\n",
 						change.Before.Source.URL, change.Before.Source.Type,
 						change.After.Source.URL, change.After.Source.Type)
 				}
@@ -213,5 +317,16 @@ func printDiffSummary(diff *gem.GemfileDiff) {
 
 func init() {
 	rootCmd.AddCommand(gemfileDiffScanCmd)
-	gemfileDiffScanCmd.Flags().StringVarP(&gemfileDiffScanRulesPath, "rules", "r", "./semgrep-rules", "path to semgrep rules")
+	gemfileDiffScanRuleFlags.register(gemfileDiffScanCmd)
+	gemfileDiffScanFormatFlags.register(gemfileDiffScanCmd)
+	gemfileDiffScanFailFlags.register(gemfileDiffScanCmd)
+	gemfileDiffScanScannerFlags.register(gemfileDiffScanCmd)
+	gemfileDiffScanCmd.Flags().StringVar(&gemfileDiffScanMinSeverity, "min-severity", "", "only report findings at least this severe (INFO, WARNING or ERROR)")
+	gemfileDiffScanCmd.Flags().StringVar(&gemfileDiffScanSuppressions, "suppressions", "", "suppression file of reviewed findings to hide")
+	gemfileDiffScanCmd.Flags().BoolVar(&gemfileDiffScanShowSuppressed, "show-suppressed", false, "list suppressed findings instead of only counting them")
+	gemfileDiffScanCmd.Flags().StringSliceVar(&gemfileDiffScanTrustedSources, "trusted-source", nil, "gem source URL whose gems may use whiskers:ignore annotations (repeatable)")
+	gemfileDiffScanCmd.Flags().IntVar(&gemfileDiffScanDownloadWorkers, "download-workers", scan.DefaultConcurrency.Downloads, "number of concurrent gem downloads")
+	gemfileDiffScanCmd.Flags().IntVar(&gemfileDiffScanExtractWorkers, "extract-workers", scan.DefaultConcurrency.Extractions, "number of concurrent gem extractions")
+	gemfileDiffScanCmd.Flags().IntVar(&gemfileDiffScanScanWorkers, "scan-workers", scan.DefaultConcurrency.Scans, "number of concurrent scanner invocations")
+	gemfileDiffScanCmd.Flags().BoolVar(&gemfileDiffScanBatch, "batch", false, "scan all gems with as few scanner invocations as possible")
 }
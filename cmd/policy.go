@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"whiskers/policy"
+	"whiskers/report"
+
+	"github.com/spf13/cobra"
+)
+
+var policyCheckPath string
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Decide whether dependency changes are allowed",
+}
+
+var policyCheckCmd = &cobra.Command{
+	Use:   "check [report.json]",
+	Short: "Check a saved scan report against a policy",
+	Long: `Evaluate a policy file against a JSON report saved by a scan command with --output,
+printing an allow, warn or deny decision for each added or updated gem.
+For example:
+  whiskers gemfile-diff-scan diff.json --output report.json
+  whiskers policy check report.json --policy policy.yaml
+
+Exits with code 4 if the policy denies any gem change.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if policyCheckPath == "" {
+			return fmt.Errorf("--policy is required")
+		}
+		p, err := policy.Load(policyCheckPath)
+		if err != nil {
+			return err
+		}
+		r, err := report.Load(args[0])
+		if err != nil {
+			return err
+		}
+
+		r.ApplyPolicy(p)
+		printPolicy(os.Stdout, r.Policy)
+		if r.Policy.Decision == policy.Deny {
+			exitCode = ExitDenied
+		}
+		return nil
+	},
+}
+
+// printPolicy prints the decision for each gem with the rules it broke,
+// followed by the overall decision
+func printPolicy(out io.Writer, result *policy.Result) {
+	fmt.Fprintf(out, "\nPolicy decisions (%s):\n", result.Policy)
+	if len(result.Gems) == 0 {
+		fmt.Fprintln(out, "  No added or updated gems")
+	}
+	for _, g := range result.Gems {
+		version := g.Version
+		if g.PreviousVersion != "" {
+			version = g.PreviousVersion + " → " + g.Version
+		}
+		fmt.Fprintf(out, "  %-5s %s (%s)\n", g.Decision, g.Name, version)
+		for _, v := range g.Violations {
+			fmt.Fprintf(out, "        %s: %s [%s]\n", v.Action, v.Message, v.Rule)
+		}
+	}
+	fmt.Fprintf(out, "\nPolicy decision: %s\n", result.Decision)
+}
+
+func init() {
+	rootCmd.AddCommand(policyCmd)
+	policyCmd.AddCommand(policyCheckCmd)
+	policyCheckCmd.Flags().StringVar(&policyCheckPath, "policy", "", "policy file to check the report against")
+}
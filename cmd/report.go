@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+	"whiskers/report"
+	"whiskers/rules"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportShowSuppressed bool
+	reportShowFormat     string
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Work with saved scan reports",
+}
+
+var reportShowCmd = &cobra.Command{
+	Use:   "show [report.json]",
+	Short: "Print a saved scan report",
+	Long: `Print a JSON report saved by a scan command with --output as human-readable text,
+or in any of the scan output formats.
+For example:
+  whiskers gemfile-diff-scan diff.json --output report.json
+  whiskers report show report.json
+  whiskers report show report.json --format markdown`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateFormat(reportShowFormat); err != nil {
+			return err
+		}
+		r, err := report.Load(args[0])
+		if err != nil {
+			return err
+		}
+		out := os.Stdout
+		if reportShowFormat != formatText {
+			return render(out, reportShowFormat, r)
+		}
+
+		fmt.Fprintf(out, "Report of %s run at %s (took %s)\n", r.Command,
+			r.StartedAt.Local().Format("2006-01-02 15:04:05 MST"),
+			(time.Duration(r.DurationMS) * time.Millisecond).Round(time.Millisecond))
+		fmt.Fprintf(out, "Scanned with %s %s\n", r.Tool.Name, r.Tool.Version)
+		if len(r.Rules.Packs) > 0 {
+			set := &rules.Set{Packs: r.Rules.Packs}
+			fmt.Fprintf(out, "Using rules: %s\n", set.Describe())
+		}
+		if r.Tool.Scanners != "" {
+			fmt.Fprintf(out, "Using scanners: %s\n", r.Tool.Scanners)
+		}
+
+		if r.Diff != nil {
+			printDiffSummary(out, r.Diff.Diff())
+		}
+		if len(r.Gems) == 0 {
+			fmt.Fprintln(out, "\nNo version changes to scan")
+		} else {
+			printScanResults(out, r.Results(), reportShowSuppressed)
+		}
+
+		printRisk(out, r)
+		if r.Policy != nil {
+			printPolicy(out, r.Policy)
+		}
+		fmt.Fprintf(out, "\nVerdict: %s (%d new issues in %d gems)\n", r.Verdict, r.Findings(), len(r.Gems))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.AddCommand(reportShowCmd)
+	reportShowCmd.Flags().StringVar(&reportShowFormat, "format", formatText, "output format (text, sarif, markdown, html or junit)")
+	reportShowCmd.Flags().BoolVar(&reportShowSuppressed, "show-suppressed", false, "list suppressed findings instead of only counting them")
+}
@@ -6,23 +6,32 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Version is the whiskers release, set at build time with
+// -ldflags "-X whiskers/cmd.Version=..."
+var Version = "dev"
+
 var rootCmd = &cobra.Command{
-	Use:   "whiskers",
-	Short: "Whiskers is a CLI tool for cats",
+	Use:     "whiskers",
+	Version: Version,
+	Short:   "Whiskers is a CLI tool for cats",
 	Long: `A longer description of the Whiskers CLI tool
 that can span multiple lines and provide more detailed
 information about the application.`,
+	PersistentPreRunE: loadConfig,
 }
 
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(ExitError)
+	}
+	if exitCode != ExitClean {
+		os.Exit(exitCode)
 	}
 }
 
 func init() {
 	// Here you can define flags and configuration settings that are
 	// global to all commands
-	rootCmd.PersistentFlags().StringP("config", "c", "", "config file (default is $HOME/.whiskers.yaml)")
+	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "config file, overriding ./.whiskers.yaml and $HOME/.whiskers.yaml")
 } 
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"whiskers/engine"
+	"whiskers/rules"
+
+	"github.com/spf13/cobra"
+)
+
+// ruleFlags are the flags that select the rules of a scan
+type ruleFlags struct {
+	paths   []string
+	replace bool
+	config  string
+}
+
+var (
+	rulesListFlags    ruleFlags
+	rulesLockFlags    ruleFlags
+	rulesTestFlags    ruleFlags
+	rulesTestFixtures string
+	rulesTestScanner  string
+)
+
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Inspect and lock the semgrep rules used by scans",
+}
+
+var rulesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List rule IDs, severities and sources",
+	Long: `List the rules a scan would use: the built-in rules or the packs of a rule config, plus any given with --rules.
+For example:
+  whiskers rules list
+  whiskers rules list --rules ./my-rules
+  whiskers rules list --rule-config whiskers-rules.yaml`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ruleSet, err := rulesListFlags.load()
+		if err != nil {
+			return err
+		}
+		defer ruleSet.Close()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSEVERITY\tPACK\tSOURCE")
+		for _, r := range ruleSet.Rules {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.ID, r.Severity, r.Pack, r.Source)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Printf("\n%d rules from %s\n", len(ruleSet.Rules), ruleSet.Describe())
+		return nil
+	},
+}
+
+var rulesLockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Record the digest of each rule pack in a lock file",
+	Long: `Resolve the packs of a rule config and record their digests, and the commits of git packs,
+in a lock file next to it. Scans using the config then fail if a pack no longer matches the lock.
+For example:
+  whiskers rules lock --rule-config whiskers-rules.yaml`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if rulesLockFlags.config == "" {
+			return fmt.Errorf("--rule-config is required")
+		}
+		config, err := rules.LoadConfig(rulesLockFlags.config)
+		if err != nil {
+			return err
+		}
+
+		// Resolve refs afresh rather than from the existing lock
+		ruleSet, err := rules.Load(config, nil)
+		if err != nil {
+			return err
+		}
+		defer ruleSet.Close()
+
+		if err := ruleSet.Lock().Save(config.LockPath()); err != nil {
+			return err
+		}
+		fmt.Printf("Locked %s in %s\n", ruleSet.Describe(), config.LockPath())
+		return nil
+	},
+}
+
+var rulesTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Run rules against annotated fixtures and report their precision",
+	Long: `Scan Ruby fixtures annotated with "# ruleid: <rule>" (must match) and "# ok: <rule>" (must not match)
+comments and report the precision and recall of each rule. "# todoruleid:" and "# todook:" mark known
+misses and false positives, which are counted but don't fail the test. Any other match is a failure.
+Without --fixtures, the built-in fixture corpus is used. With --scanner ruby, the built-in Go
+rules are tested instead of semgrep, which is also the fallback when semgrep isn't installed.
+For example:
+  whiskers rules test
+  whiskers rules test --scanner ruby
+  whiskers rules test --rules ./my-rules --replace-rules --fixtures ./my-fixtures`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ruleSet, err := rulesTestFlags.load()
+		if err != nil {
+			return err
+		}
+		defer ruleSet.Close()
+
+		fixtures, err := rules.LoadFixtures(rulesTestFixtures)
+		if err != nil {
+			return err
+		}
+		defer fixtures.Close()
+
+		if rulesTestScanner != engine.Semgrep && rulesTestScanner != engine.Ruby {
+			return fmt.Errorf("--scanner must be %s or %s", engine.Semgrep, engine.Ruby)
+		}
+		scanner, err := engine.New(engine.Options{
+			Scanners: []string{rulesTestScanner},
+			Rules:    ruleSet,
+			Logf: func(format string, args ...interface{}) {
+				fmt.Printf(format, args...)
+			},
+		})
+		if err != nil {
+			return err
+		}
+		defer scanner.Close()
+
+		result, err := scanner.Scan(fixtures.Dir, fixtures.Files)
+		if err != nil {
+			return fmt.Errorf("failed to scan fixtures: %w", err)
+		}
+		for _, e := range result.RuleErrors {
+			fmt.Printf("Warning: rule error: %s\n", e.Display())
+		}
+		for _, e := range result.Unanalyzed() {
+			fmt.Printf("Warning: fixture could not be analyzed: %s\n", e.Display())
+		}
+
+		report := fixtures.Evaluate(ruleSet, result.Findings)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTP\tFP\tFN\tTN\tPRECISION\tRECALL")
+		for _, s := range report.Rules {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\n", s.ID,
+				s.TruePositives, s.FalsePositives, s.FalseNegatives, s.TrueNegatives,
+				formatRatio(s.Precision()), formatRatio(s.Recall()))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if len(report.Failures) > 0 {
+			fmt.Println()
+			for _, f := range report.Failures {
+				fmt.Printf("FAIL %s:%d %s: %s\n", f.Path, f.Line, f.Rule, f.Message)
+			}
+			return fmt.Errorf("%d rule expectations failed", len(report.Failures))
+		}
+
+		fmt.Printf("\nAll expectations met in %d fixtures\n", len(fixtures.Files))
+		return nil
+	},
+}
+
+// formatRatio formats a precision or recall as a percentage, or "-" if it is undefined
+func formatRatio(ratio float64, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", ratio*100)
+}
+
+// register adds the rule selection flags to a command
+func (f *ruleFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVarP(&f.paths, "rules", "r", nil, "semgrep rule file or directory to add to the configured rules (repeatable)")
+	cmd.Flags().BoolVar(&f.replace, "replace-rules", false, "use only the rules given with --rules instead of the configured rules")
+	cmd.Flags().StringVar(&f.config, "rule-config", "", "rule pack config file (default is the built-in rules)")
+}
+
+// load materializes the selected rules, checking them against the config's lock file if it has one
+func (f *ruleFlags) load() (*rules.Set, error) {
+	config := rules.DefaultConfig()
+	var lock *rules.Lock
+	if f.config != "" {
+		var err error
+		if config, err = rules.LoadConfig(f.config); err != nil {
+			return nil, err
+		}
+		if lock, err = rules.LoadLock(config.LockPath()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.AddPaths(f.paths, f.replace); err != nil {
+		return nil, err
+	}
+	return rules.Load(config, lock)
+}
+
+func init() {
+	rootCmd.AddCommand(rulesCmd)
+	rulesCmd.AddCommand(rulesListCmd)
+	rulesCmd.AddCommand(rulesLockCmd)
+	rulesCmd.AddCommand(rulesTestCmd)
+	rulesListFlags.register(rulesListCmd)
+	rulesLockCmd.Flags().StringVar(&rulesLockFlags.config, "rule-config", "", "rule pack config file to lock")
+	rulesTestFlags.register(rulesTestCmd)
+	rulesTestCmd.Flags().StringVar(&rulesTestScanner, "scanner", engine.Semgrep, "backend to test the rules with (semgrep or ruby)")
+	rulesTestCmd.Flags().StringVar(&rulesTestFixtures, "fixtures", "", "directory of annotated Ruby fixtures (default is the built-in corpus)")
+}
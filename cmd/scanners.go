@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"whiskers/engine"
+
+	"github.com/spf13/cobra"
+)
+
+// scannerFlags are the flags that select the analysis backends of a scan
+type scannerFlags struct {
+	names        []string
+	sarifCommand string
+	yaraRules    string
+}
+
+// register adds the scanner selection flags to a command
+func (f *scannerFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&f.names, "scanner", engine.DefaultScanners,
+		fmt.Sprintf("analysis backends to run, combined (%s)", strings.Join(engine.Available(), ", ")))
+	cmd.Flags().StringVar(&f.sarifCommand, "sarif-command", "", "external analyzer for the sarif scanner; the files to scan are appended and it must print SARIF")
+	cmd.Flags().StringVar(&f.yaraRules, "yara-rules", "", "directory of YARA rules for the yara scanner, run over every changed file including binaries")
+}
@@ -0,0 +1,402 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"whiskers/engine"
+	"whiskers/risk"
+	"whiskers/scan"
+	"whiskers/semgrep"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the config file looked up in the repository and
+// the home directory
+const FileName = ".whiskers.yaml"
+
+// EnvFile names a config file to use in place of the --config flag
+const EnvFile = "WHISKERS_CONFIG"
+
+// Config holds the settings shared by the whiskers commands. Most of them
+// are defaults for the scan commands' flags, which override them.
+type Config struct {
+	// CacheDir is where gems are downloaded and extracted
+	CacheDir string `yaml:"cache_dir"`
+	// RuleConfig is a rule pack config file, see rules.LoadConfig
+	RuleConfig string `yaml:"rule_config,omitempty"`
+	// Rules are rule files or directories added to the configured rules
+	Rules        []string `yaml:"rules,omitempty"`
+	Scanners     []string `yaml:"scanners"`
+	SARIFCommand string   `yaml:"sarif_command,omitempty"`
+	YARARules    string   `yaml:"yara_rules,omitempty"`
+	// Ignore are globs of files left out of gem diffs, see utils.ComparePaths
+	Ignore       []string `yaml:"ignore"`
+	Suppressions string   `yaml:"suppressions,omitempty"`
+	// Sources are gem servers to download from with credentials, or to
+	// trust. Unlike other lists, they are merged across config files by URL.
+	Sources     []Source    `yaml:"sources,omitempty"`
+	Concurrency Concurrency `yaml:"concurrency"`
+	Format      string      `yaml:"format"`
+	// FailOn and FailOnIncomplete decide the exit code of scans
+	FailOn           string `yaml:"fail_on"`
+	FailOnIncomplete bool   `yaml:"fail_on_incomplete"`
+	MinSeverity      string `yaml:"min_severity,omitempty"`
+	// Policy is a policy file deciding whether dependency changes are allowed
+	Policy string `yaml:"policy,omitempty"`
+	// Risk are the weights of the risk score of each gem change
+	Risk risk.Weights `yaml:"risk"`
+
+	// Files are the config files the settings were read from, in order
+	Files []string `yaml:"-"`
+	// Env are the environment variables that overrode settings
+	Env []string `yaml:"-"`
+}
+
+// Source is a gem server
+type Source struct {
+	URL string `yaml:"url"`
+	// Username and Password are sent with every download from the source.
+	// Both may name environment variables, e.g. ${GEM_SERVER_TOKEN}.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Trusted lets the source's gems silence findings with whiskers:ignore annotations
+	Trusted bool `yaml:"trusted,omitempty"`
+}
+
+// Concurrency is the number of workers for each stage of a scan
+type Concurrency struct {
+	Downloads   int `yaml:"downloads"`
+	Extractions int `yaml:"extractions"`
+	Scans       int `yaml:"scans"`
+}
+
+// Default returns the settings used when nothing overrides them
+func Default() *Config {
+	return &Config{
+		CacheDir: "/tmp/gems",
+		Scanners: slices.Clone(engine.DefaultScanners),
+		Ignore:   slices.Clone(scan.DefaultIgnoreFiles),
+		Concurrency: Concurrency{
+			Downloads:   scan.DefaultConcurrency.Downloads,
+			Extractions: scan.DefaultConcurrency.Extractions,
+			Scans:       scan.DefaultConcurrency.Scans,
+		},
+		Format: "text",
+		FailOn: "none",
+		Risk:   risk.DefaultWeights(),
+	}
+}
+
+// Load reads the settings from $HOME/.whiskers.yaml, then the .whiskers.yaml
+// of the repository the working directory is in, then the file given with
+// --config or $WHISKERS_CONFIG, each overriding the settings of the last.
+// Environment variables override them all. Relative paths in a file are
+// resolved against its directory. The repository's file may only set
+// RepoSettings, since whoever changes the repository controls it.
+func Load(path string) (*Config, error) {
+	c := Default()
+
+	if home, err := os.UserHomeDir(); err == nil {
+		if err := c.loadFile(filepath.Join(home, FileName), false, false); err != nil {
+			return nil, err
+		}
+	}
+	if repo := findRepoFile(); repo != "" {
+		if err := c.loadFile(repo, false, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if path == "" {
+		path = os.Getenv(EnvFile)
+	}
+	if path != "" {
+		if err := c.loadFile(path, true, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RepoSettings are the settings a repository's .whiskers.yaml may set. The
+// rest decide what is scanned, what runs, what is trusted, how gems are
+// scored and when a scan fails, so a pull request must not be able to change
+// them: ignoring *.rb would hide every Ruby file, and zero risk weights every
+// score.
+var RepoSettings = []string{"concurrency", "format"}
+
+// findRepoFile looks for a config file in the working directory and its
+// parents, up to the root of the git repository
+func findRepoFile() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		file := filepath.Join(dir, FileName)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadFile overrides the settings with those in a config file. A missing
+// file is skipped unless it is required. A repository's file may only set
+// RepoSettings.
+func (c *Config) loadFile(path string, required, repo bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config path %s: %w", path, err)
+	}
+	if slices.Contains(c.Files, abs) {
+		return nil
+	}
+
+	data, err := os.ReadFile(abs)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if repo {
+		if err := checkRepoSettings(path, data); err != nil {
+			return err
+		}
+	}
+
+	// Only resolve the paths this file sets, which are still relative
+	before := *c
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	base := filepath.Dir(abs)
+	for _, setting := range []struct {
+		value *string
+		old   string
+	}{
+		{&c.CacheDir, before.CacheDir},
+		{&c.RuleConfig, before.RuleConfig},
+		{&c.YARARules, before.YARARules},
+		{&c.Suppressions, before.Suppressions},
+		{&c.Policy, before.Policy},
+	} {
+		if *setting.value != setting.old {
+			*setting.value = resolve(base, *setting.value)
+		}
+	}
+	if !slices.Equal(c.Rules, before.Rules) {
+		for i, rule := range c.Rules {
+			c.Rules[i] = resolve(base, rule)
+		}
+	}
+
+	// Sources add to those of earlier files, replacing any with the same URL
+	if !slices.Equal(c.Sources, before.Sources) {
+		sources := c.Sources
+		for _, old := range before.Sources {
+			if !slices.ContainsFunc(sources, func(s Source) bool { return sameURL(s.URL, old.URL) }) {
+				sources = append(sources, old)
+			}
+		}
+		c.Sources = sources
+	}
+
+	c.Files = append(c.Files, abs)
+	return nil
+}
+
+// checkRepoSettings rejects a repository's config file that sets anything
+// but RepoSettings
+func checkRepoSettings(path string, data []byte) error {
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	var denied []string
+	for key := range settings {
+		if !slices.Contains(RepoSettings, key) {
+			denied = append(denied, key)
+		}
+	}
+	if len(denied) == 0 {
+		return nil
+	}
+	slices.Sort(denied)
+	return fmt.Errorf("repository config %s may not set %s; set them in $HOME/%s, --config or WHISKERS_* variables instead",
+		path, strings.Join(denied, ", "), FileName)
+}
+
+// sameURL returns true if two source URLs differ at most in a trailing slash
+func sameURL(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
+
+// resolve makes a relative path relative to base
+func resolve(base, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(base, path)
+}
+
+// envSettings are the environment variables that override settings. Lists
+// are comma separated.
+var envSettings = []struct {
+	name string
+	set  func(c *Config, value string) error
+}{
+	{"WHISKERS_CACHE_DIR", func(c *Config, v string) error { c.CacheDir = v; return nil }},
+	{"WHISKERS_RULE_CONFIG", func(c *Config, v string) error { c.RuleConfig = v; return nil }},
+	{"WHISKERS_RULES", func(c *Config, v string) error { c.Rules = splitList(v); return nil }},
+	{"WHISKERS_SCANNERS", func(c *Config, v string) error { c.Scanners = splitList(v); return nil }},
+	{"WHISKERS_SARIF_COMMAND", func(c *Config, v string) error { c.SARIFCommand = v; return nil }},
+	{"WHISKERS_YARA_RULES", func(c *Config, v string) error { c.YARARules = v; return nil }},
+	{"WHISKERS_IGNORE", func(c *Config, v string) error { c.Ignore = splitList(v); return nil }},
+	{"WHISKERS_SUPPRESSIONS", func(c *Config, v string) error { c.Suppressions = v; return nil }},
+	{"WHISKERS_DOWNLOAD_WORKERS", func(c *Config, v string) error { return setInt(&c.Concurrency.Downloads, v) }},
+	{"WHISKERS_EXTRACT_WORKERS", func(c *Config, v string) error { return setInt(&c.Concurrency.Extractions, v) }},
+	{"WHISKERS_SCAN_WORKERS", func(c *Config, v string) error { return setInt(&c.Concurrency.Scans, v) }},
+	{"WHISKERS_FORMAT", func(c *Config, v string) error { c.Format = v; return nil }},
+	{"WHISKERS_FAIL_ON", func(c *Config, v string) error { c.FailOn = v; return nil }},
+	{"WHISKERS_FAIL_ON_INCOMPLETE", func(c *Config, v string) error { return setBool(&c.FailOnIncomplete, v) }},
+	{"WHISKERS_MIN_SEVERITY", func(c *Config, v string) error { c.MinSeverity = v; return nil }},
+	{"WHISKERS_POLICY", func(c *Config, v string) error { c.Policy = v; return nil }},
+}
+
+// applyEnv overrides the settings with the environment variables that are set
+func (c *Config) applyEnv() error {
+	for _, setting := range envSettings {
+		value, ok := os.LookupEnv(setting.name)
+		if !ok {
+			continue
+		}
+		if err := setting.set(c, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", setting.name, err)
+		}
+		c.Env = append(c.Env, setting.name)
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// setInt parses an integer setting
+func setInt(target *int, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("expected a number, got %q", value)
+	}
+	*target = n
+	return nil
+}
+
+// setBool parses a boolean setting
+func setBool(target *bool, value string) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("expected true or false, got %q", value)
+	}
+	*target = b
+	return nil
+}
+
+// validate checks the settings that the commands can't check themselves
+func (c *Config) validate() error {
+	if c.CacheDir == "" {
+		return fmt.Errorf("cache_dir must not be empty")
+	}
+	if !strings.EqualFold(c.FailOn, "none") && semgrep.SeverityLevel(c.FailOn) == 0 {
+		return fmt.Errorf("invalid fail_on %q (expected INFO, WARNING, ERROR or none)", c.FailOn)
+	}
+	if c.MinSeverity != "" && semgrep.SeverityLevel(c.MinSeverity) == 0 {
+		return fmt.Errorf("invalid min_severity %q (expected INFO, WARNING or ERROR)", c.MinSeverity)
+	}
+	if c.Concurrency.Downloads < 0 || c.Concurrency.Extractions < 0 || c.Concurrency.Scans < 0 {
+		return fmt.Errorf("concurrency must not be negative")
+	}
+	if err := c.Risk.Validate(); err != nil {
+		return fmt.Errorf("invalid risk: %w", err)
+	}
+	for _, source := range c.Sources {
+		if source.URL == "" {
+			return fmt.Errorf("every source needs a url")
+		}
+	}
+	return nil
+}
+
+// Credentials returns the username and password of a source. A value that
+// is exactly ${VAR} is replaced by the environment variable; any other is
+// used as is, so passwords may contain $.
+func (s Source) Credentials() (string, string) {
+	return expandVar(s.Username), expandVar(s.Password)
+}
+
+// expandVar returns the environment variable a value names as ${VAR}, or
+// the value itself
+func expandVar(value string) string {
+	if name, ok := envReference(value); ok {
+		return os.Getenv(name)
+	}
+	return value
+}
+
+// envReference returns the name of the environment variable a value names as ${VAR}
+func envReference(value string) (string, bool) {
+	if len(value) > 3 && strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}") {
+		return value[2 : len(value)-1], true
+	}
+	return "", false
+}
+
+// Redacted returns a copy of the settings that is safe to print, with
+// source passwords hidden
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.Sources = slices.Clone(c.Sources)
+	for i := range redacted.Sources {
+		password := redacted.Sources[i].Password
+		// References to environment variables are safe to show
+		if _, isRef := envReference(password); password != "" && !isRef {
+			redacted.Sources[i].Password = "********"
+		}
+	}
+	return &redacted
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeFile writes a config file in dir and returns its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFileMerging(t *testing.T) {
+	dir := t.TempDir()
+	home := writeFile(t, dir, "home.yaml", `
+cache_dir: cache
+rules: [extra]
+sources:
+  - url: https://gems.example.com/
+    username: ci
+    password: ${TOKEN}
+  - url: https://other.example.com
+`)
+	override := writeFile(t, dir, "override.yaml", `
+format: sarif
+sources:
+  - url: https://gems.example.com
+    trusted: true
+`)
+
+	c := Default()
+	if err := c.loadFile(home, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.loadFile(override, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(dir, "cache"); c.CacheDir != want {
+		t.Errorf("CacheDir = %q, want %q", c.CacheDir, want)
+	}
+	if want := []string{filepath.Join(dir, "extra")}; !slices.Equal(c.Rules, want) {
+		t.Errorf("Rules = %v, want %v", c.Rules, want)
+	}
+	if c.Format != "sarif" {
+		t.Errorf("Format = %q, want sarif", c.Format)
+	}
+	// A source with the same URL replaces the earlier one, the others are kept
+	if len(c.Sources) != 2 {
+		t.Fatalf("Sources = %+v, want 2 sources", c.Sources)
+	}
+	if !c.Sources[0].Trusted || c.Sources[0].Username != "" {
+		t.Errorf("Sources[0] = %+v, want the trusted source without credentials", c.Sources[0])
+	}
+	if c.Sources[1].URL != "https://other.example.com" {
+		t.Errorf("Sources[1] = %+v, want the other source", c.Sources[1])
+	}
+	if !slices.Equal(c.Files, []string{home, override}) {
+		t.Errorf("Files = %v", c.Files)
+	}
+}
+
+func TestLoadFileMissing(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	if err := Default().loadFile(missing, false, false); err != nil {
+		t.Errorf("optional missing file: %v", err)
+	}
+	if err := Default().loadFile(missing, true, false); err == nil {
+		t.Error("required missing file: got no error")
+	}
+}
+
+func TestLoadFileRepoSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// denied are the settings the error should name, if any
+		denied []string
+	}{
+		{"harmless", "format: markdown\nconcurrency: {scans: 2}\n", nil},
+		{"empty", "", nil},
+		{"sarif command", "scanners: [sarif]\nsarif_command: curl evil.example\n", []string{"sarif_command", "scanners"}},
+		{"trusted source", "sources:\n  - url: https://evil.example/\n    trusted: true\n", []string{"sources"}},
+		{"fail thresholds", "fail_on: INFO\nfail_on_incomplete: false\n", []string{"fail_on", "fail_on_incomplete"}},
+		{"policy and suppressions", "policy: p.yaml\nsuppressions: s.yaml\n", []string{"policy", "suppressions"}},
+		{"cache dir", "cache_dir: /\n", []string{"cache_dir"}},
+		{"ignore", "ignore: ['*.rb']\n", []string{"ignore"}},
+		{"risk weights", "risk: {severity: {ERROR: 0, WARNING: 0, INFO: 0}, typosquat: 0}\n", []string{"risk"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), FileName, tt.content)
+			c := Default()
+			err := c.loadFile(path, false, true)
+			if tt.denied == nil {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			for _, key := range tt.denied {
+				if !strings.Contains(err.Error(), key) {
+					t.Errorf("error %q doesn't name %s", err, key)
+				}
+			}
+			// Nothing from a rejected file is applied
+			if c.FailOn != Default().FailOn || len(c.Sources) != 0 ||
+				!slices.Equal(c.Ignore, Default().Ignore) || c.Risk != Default().Risk {
+				t.Errorf("rejected settings were applied: %+v", c)
+			}
+		})
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	t.Setenv("WHISKERS_TEST_TOKEN", "s3cret")
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{"${WHISKERS_TEST_TOKEN}", "s3cret"},
+		{"pa$$word", "pa$$word"},
+		{"$WHISKERS_TEST_TOKEN", "$WHISKERS_TEST_TOKEN"},
+		{"x${WHISKERS_TEST_TOKEN}", "x${WHISKERS_TEST_TOKEN}"},
+		{"${}", "${}"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		_, got := Source{Password: tt.password}.Credentials()
+		if got != tt.want {
+			t.Errorf("Credentials() with password %q = %q, want %q", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := Default()
+	c.Sources = []Source{{URL: "a", Password: "literal"}, {URL: "b", Password: "${TOKEN}"}, {URL: "c"}}
+	redacted := c.Redacted()
+	for i, want := range []string{"********", "${TOKEN}", ""} {
+		if got := redacted.Sources[i].Password; got != want {
+			t.Errorf("Sources[%d].Password = %q, want %q", i, got, want)
+		}
+	}
+	if c.Sources[0].Password != "literal" {
+		t.Error("Redacted changed the original config")
+	}
+}
@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"whiskers/sarif"
+	"whiskers/semgrep"
+)
+
+// CommandScanner runs an external analyzer that prints SARIF to stdout
+type CommandScanner struct {
+	args []string
+	// MaxArgBytes bounds the total size of the file arguments passed to a single invocation
+	MaxArgBytes int
+}
+
+// NewCommandScanner creates a scanner for a command line such as
+// "mytool scan --sarif". The command line is split into words the way a
+// POSIX shell does, without expansions. The files to scan are appended as
+// arguments.
+func NewCommandScanner(command string) (*CommandScanner, error) {
+	args, err := splitWords(command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sarif command: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("the sarif scanner requires a command")
+	}
+	return &CommandScanner{
+		args:        args,
+		MaxArgBytes: semgrep.DefaultMaxArgBytes,
+	}, nil
+}
+
+// splitWords splits a command line into words, honoring single quotes,
+// double quotes and backslash escapes like a POSIX shell
+func splitWords(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range command {
+		switch {
+		case escaped:
+			// Inside double quotes a backslash only escapes characters special there
+			if quote == '"' && !strings.ContainsRune("$`\"\\\n", c) {
+				word.WriteRune('\\')
+			}
+			// An escaped newline continues the line
+			if c != '\n' {
+				word.WriteRune(c)
+				inWord = true
+			}
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\\':
+			escaped = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// Name returns "sarif"
+func (s *CommandScanner) Name() string {
+	return SARIF
+}
+
+// Scan runs the command from root over the files
+func (s *CommandScanner) Scan(root string, files []string) (*semgrep.ScanResult, error) {
+	result := &semgrep.ScanResult{Findings: []*semgrep.Finding{}}
+	for _, chunk := range chunkArgs(files, s.MaxArgBytes) {
+		chunkResult, err := s.scan(root, chunk)
+		if err != nil {
+			return nil, err
+		}
+		result.Merge(chunkResult)
+	}
+	return result, nil
+}
+
+// scan runs a single invocation of the command
+func (s *CommandScanner) scan(root string, files []string) (*semgrep.ScanResult, error) {
+	cmd := exec.Command(s.args[0], append(append([]string{}, s.args[1:]...), files...)...)
+	cmd.Dir = root
+	output, err := cmd.Output()
+
+	// Analyzers commonly exit non-zero when they find something, so only
+	// give up if there is no usable output
+	log, parseErr := sarif.Parse(output)
+	if parseErr != nil || len(output) == 0 {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("%s failed: %s", s.args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to run %s: %w", s.args[0], err)
+		}
+		return nil, parseErr
+	}
+	return FromSARIF(log, root), nil
+}
+
+// Close does nothing
+func (s *CommandScanner) Close() error {
+	return nil
+}
+
+// FromSARIF converts the results of a SARIF log to findings. Relative file
+// URIs are resolved against root. Notifications about a file become parse
+// errors, so the file is reported as unanalyzed, and other error
+// notifications become rule errors.
+func FromSARIF(log *sarif.Log, root string) *semgrep.ScanResult {
+	result := &semgrep.ScanResult{Findings: []*semgrep.Finding{}}
+	for i := range log.Runs {
+		run := &log.Runs[i]
+		for _, r := range run.Results {
+			level := r.Level
+			if level == "" {
+				level = run.RuleLevel(r)
+			}
+			f := &semgrep.Finding{
+				RuleID:   r.RuleID,
+				Message:  r.Message.Text,
+				Severity: sarif.Severity(level),
+			}
+			if len(r.Locations) > 0 {
+				location := r.Locations[0].PhysicalLocation
+				f.Path = resolveURI(location.ArtifactLocation.URI, root)
+				if region := location.Region; region != nil {
+					f.Line = region.StartLine
+					f.Column = region.StartColumn
+					f.EndLine = region.EndLine
+					f.EndColumn = region.EndColumn
+					if region.Snippet != nil {
+						f.Lines = region.Snippet.Text
+					}
+				}
+			}
+			result.Findings = append(result.Findings, f)
+		}
+
+		for _, invocation := range run.Invocations {
+			for _, n := range invocation.ToolExecutionNotifications {
+				scanErr := semgrep.ScanError{
+					Type:    run.Tool.Driver.Name,
+					Level:   n.Level,
+					Message: n.Message.Text,
+				}
+				switch {
+				case len(n.Locations) > 0:
+					scanErr.Path = resolveURI(n.Locations[0].PhysicalLocation.ArtifactLocation.URI, root)
+					result.ParseErrors = append(result.ParseErrors, scanErr)
+				case n.Level == "error":
+					result.RuleErrors = append(result.RuleErrors, scanErr)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// resolveURI turns a SARIF artifact URI into an absolute path
+func resolveURI(uri, root string) string {
+	path := uri
+	if u, err := url.Parse(uri); err == nil && (u.Scheme == "file" || u.Scheme == "") {
+		path = u.Path
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	return filepath.Clean(path)
+}
+
+// chunkArgs splits files into groups whose combined argument size stays within maxBytes
+func chunkArgs(files []string, maxBytes int) [][]string {
+	if maxBytes <= 0 {
+		maxBytes = semgrep.DefaultMaxArgBytes
+	}
+
+	var chunks [][]string
+	var current []string
+	size := 0
+	for _, file := range files {
+		if len(current) > 0 && size+len(file)+1 > maxBytes {
+			chunks = append(chunks, current)
+			current = nil
+			size = 0
+		}
+		current = append(current, file)
+		size += len(file) + 1
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
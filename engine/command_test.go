@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+	"whiskers/sarif"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{"mytool scan --sarif", []string{"mytool", "scan", "--sarif"}, false},
+		{"  mytool\t scan  ", []string{"mytool", "scan"}, false},
+		{`"/opt/my tool/bin/scan" --format sarif`, []string{"/opt/my tool/bin/scan", "--format", "sarif"}, false},
+		{`scan --rules 'a b' --name it\'s`, []string{"scan", "--rules", "a b", "--name", "it's"}, false},
+		{`scan "say \"hi\"" "a\b" 'c\d'`, []string{"scan", `say "hi"`, `a\b`, `c\d`}, false},
+		{`scan my\ file`, []string{"scan", "my file"}, false},
+		{`scan "" x`, []string{"scan", "", "x"}, false},
+		{"scan \\\n  --sarif", []string{"scan", "--sarif"}, false},
+		{"$HOME/scan `id`", []string{"$HOME/scan", "`id`"}, false},
+		{"", nil, false},
+		{" \t\n", nil, false},
+		{`scan "unterminated`, nil, true},
+		{`scan 'unterminated`, nil, true},
+		{`scan \`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitWords(tt.command)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitWords(%q) error = %v, want error: %v", tt.command, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestNewCommandScanner(t *testing.T) {
+	for _, command := range []string{"", "   ", `"unterminated`} {
+		if _, err := NewCommandScanner(command); err == nil {
+			t.Errorf("NewCommandScanner(%q): got no error", command)
+		}
+	}
+	if _, err := New(Options{Scanners: []string{SARIF}, SARIFCommand: " \t"}); err == nil {
+		t.Error("New with a blank sarif command: got no error")
+	}
+}
+
+func TestFromSARIF(t *testing.T) {
+	log, err := sarif.Parse([]byte(`{
+		"version": "2.1.0",
+		"runs": [{
+			"tool": {"driver": {"name": "mytool", "rules": [
+				{"id": "r1", "defaultConfiguration": {"level": "error"}},
+				{"id": "r2"}
+			]}},
+			"results": [
+				{"ruleId": "r1", "message": {"text": "by rule id"},
+				 "locations": [{"physicalLocation": {"artifactLocation": {"uri": "lib/foo.rb"},
+				   "region": {"startLine": 3, "startColumn": 2, "endLine": 4, "endColumn": 9, "snippet": {"text": "eval(x)"}}}}]},
+				{"ruleId": "other", "ruleIndex": 1, "level": "note", "message": {"text": "explicit level"},
+				 "locations": [{"physicalLocation": {"artifactLocation": {"uri": "file:///gems/foo/lib/bar.rb"}}}]},
+				{"ruleId": "r2", "message": {"text": "no level"},
+				 "locations": [{"physicalLocation": {"artifactLocation": {"uri": "lib/my%20file.rb"}}}]},
+				{"ruleId": "r3", "message": {"text": "no location"}}
+			],
+			"invocations": [{"toolExecutionNotifications": [
+				{"level": "error", "message": {"text": "cannot parse"},
+				 "locations": [{"physicalLocation": {"artifactLocation": {"uri": "lib/broken.rb"}}}]},
+				{"level": "error", "message": {"text": "rule crashed"}},
+				{"level": "note", "message": {"text": "just a note"}}
+			]}]
+		}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := FromSARIF(log, "/gems/foo")
+	tests := []struct {
+		rule, path, severity string
+		line                 int
+		lines                string
+	}{
+		{"r1", "/gems/foo/lib/foo.rb", "ERROR", 3, "eval(x)"},
+		{"other", "/gems/foo/lib/bar.rb", "INFO", 0, ""},
+		{"r2", "/gems/foo/lib/my file.rb", "WARNING", 0, ""},
+		{"r3", "", "WARNING", 0, ""},
+	}
+	if len(result.Findings) != len(tests) {
+		t.Fatalf("got %d findings, want %d", len(result.Findings), len(tests))
+	}
+	for i, tt := range tests {
+		f := result.Findings[i]
+		if f.RuleID != tt.rule || f.Path != tt.path || f.Severity != tt.severity || f.Line != tt.line || f.Lines != tt.lines {
+			t.Errorf("finding %d = %+v, want %+v", i, *f, tt)
+		}
+	}
+	if f := result.Findings[0]; f.Column != 2 || f.EndLine != 4 || f.EndColumn != 9 {
+		t.Errorf("finding 0 region = %d:%d-%d, want 2:4-9", f.Column, f.EndLine, f.EndColumn)
+	}
+
+	if len(result.ParseErrors) != 1 || result.ParseErrors[0].Path != "/gems/foo/lib/broken.rb" || result.ParseErrors[0].Type != "mytool" {
+		t.Errorf("ParseErrors = %+v, want the unparseable file", result.ParseErrors)
+	}
+	if len(result.RuleErrors) != 1 || result.RuleErrors[0].Message != "rule crashed" {
+		t.Errorf("RuleErrors = %+v, want the rule crash", result.RuleErrors)
+	}
+}
@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"whiskers/rules"
+	"whiskers/semgrep"
+)
+
+// Names of the available scanners
+const (
+	Semgrep    = "semgrep"
+	Heuristics = "heuristics"
+	SARIF      = "sarif"
+	YARA       = "yara"
+	Ruby       = "ruby"
+)
+
+// DefaultScanners are used when a run doesn't select any
+var DefaultScanners = []string{Semgrep, Heuristics}
+
+// Scanner is an analysis backend. It scans files, given as absolute paths
+// under root, and returns its findings in semgrep's format along with any
+// files or rules it could not analyze.
+type Scanner interface {
+	Name() string
+	Scan(root string, files []string) (*semgrep.ScanResult, error)
+	Close() error
+}
+
+// Options selects and configures the scanners of a run
+type Options struct {
+	// Scanners are the names of the scanners to run, DefaultScanners if empty
+	Scanners []string
+	// Rules are the semgrep rules, required by the semgrep scanner
+	Rules *rules.Set
+	// SARIFCommand is the command line of the sarif scanner. The files to
+	// scan are appended as arguments and it must print SARIF to stdout.
+	SARIFCommand string
+	// YARARules is the directory of .yar and .yara rules run by the yara scanner
+	YARARules string
+	// Logf receives warnings, e.g. fmt.Printf
+	Logf func(format string, args ...interface{})
+}
+
+// New creates the scanners selected by opts, combined into one
+func New(opts Options) (Scanner, error) {
+	names := opts.Scanners
+	if len(names) == 0 {
+		names = DefaultScanners
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...interface{}) {}
+	}
+
+	var multi Multi
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		var scanner Scanner
+		switch name {
+		case Semgrep:
+			if opts.Rules == nil {
+				multi.Close()
+				return nil, fmt.Errorf("the semgrep scanner requires rules")
+			}
+			if _, err := exec.LookPath("semgrep"); err != nil {
+				// Degrade to the built-in Go rules rather than not scanning at all
+				if seen[Ruby] {
+					continue
+				}
+				seen[Ruby] = true
+				opts.Logf("Warning: semgrep not found, falling back to the built-in Ruby rules\n")
+				if uncovered := Uncovered(opts.Rules); len(uncovered) > 0 {
+					opts.Logf("Warning: %d rules have no built-in equivalent and are skipped: %s\n",
+						len(uncovered), strings.Join(uncovered, ", "))
+				}
+				scanner = NewRubyScanner(opts.Rules)
+				break
+			}
+			scanner = NewSemgrepScanner(opts.Rules)
+		case Ruby:
+			scanner = NewRubyScanner(opts.Rules)
+		case Heuristics:
+			scanner = NewHeuristicsScanner()
+		case SARIF:
+			var err error
+			if scanner, err = NewCommandScanner(opts.SARIFCommand); err != nil {
+				multi.Close()
+				return nil, err
+			}
+		case YARA:
+			if opts.YARARules == "" {
+				multi.Close()
+				return nil, fmt.Errorf("the yara scanner requires a rules directory")
+			}
+			var err error
+			if scanner, err = NewYARAScanner(opts.YARARules); err != nil {
+				multi.Close()
+				return nil, err
+			}
+		default:
+			multi.Close()
+			return nil, fmt.Errorf("unknown scanner %q (available: %s)", name, strings.Join(Available(), ", "))
+		}
+		multi = append(multi, scanner)
+	}
+	return multi, nil
+}
+
+// Available returns the names of every scanner
+func Available() []string {
+	return []string{Semgrep, Heuristics, SARIF, YARA, Ruby}
+}
+
+// Multi runs several scanners over the same files and merges their results
+type Multi []Scanner
+
+// Name returns the names of the combined scanners, e.g. "semgrep+heuristics"
+func (m Multi) Name() string {
+	names := make([]string, 0, len(m))
+	for _, scanner := range m {
+		names = append(names, scanner.Name())
+	}
+	return strings.Join(names, "+")
+}
+
+// Scan runs every scanner in turn. A scanner that fails fails the scan,
+// since its findings would otherwise be silently missing.
+func (m Multi) Scan(root string, files []string) (*semgrep.ScanResult, error) {
+	result := &semgrep.ScanResult{Findings: []*semgrep.Finding{}}
+	for _, scanner := range m {
+		scanResult, err := scanner.Scan(root, files)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", scanner.Name(), err)
+		}
+		for _, f := range scanResult.Findings {
+			if f.Scanner == "" {
+				f.Scanner = scanner.Name()
+			}
+		}
+		result.Merge(scanResult)
+	}
+	return result, nil
+}
+
+// Close closes every scanner
+func (m Multi) Close() error {
+	var firstErr error
+	for _, scanner := range m {
+		if err := scanner.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
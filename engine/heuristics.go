@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"whiskers/heuristics"
+	"whiskers/semgrep"
+)
+
+// HeuristicsScanner runs whiskers' own file analyses, which look for
+// obfuscated payloads that rules can't see through
+type HeuristicsScanner struct {
+	analyzer *heuristics.ObfuscationAnalyzer
+}
+
+// NewHeuristicsScanner creates a scanner with the default thresholds
+func NewHeuristicsScanner() *HeuristicsScanner {
+	return &HeuristicsScanner{analyzer: heuristics.NewObfuscationAnalyzer()}
+}
+
+// Name returns "heuristics"
+func (s *HeuristicsScanner) Name() string {
+	return Heuristics
+}
+
+// Scan analyzes each file
+func (s *HeuristicsScanner) Scan(root string, files []string) (*semgrep.ScanResult, error) {
+	findings, err := s.analyzer.Scan(files)
+	if err != nil {
+		return nil, err
+	}
+	return &semgrep.ScanResult{Findings: findings}, nil
+}
+
+// Close does nothing
+func (s *HeuristicsScanner) Close() error {
+	return nil
+}
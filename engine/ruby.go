@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"whiskers/ruby"
+	"whiskers/rules"
+	"whiskers/semgrep"
+)
+
+// rubyExtensions are the file extensions the ruby scanner parses
+var rubyExtensions = map[string]bool{
+	".rb":      true,
+	".rake":    true,
+	".gemspec": true,
+	".ru":      true,
+}
+
+// rubyFilenames are Ruby files without an extension
+var rubyFilenames = map[string]bool{
+	"Rakefile": true,
+	"Gemfile":  true,
+}
+
+// RubyScanner runs the built-in rules of the ruby package, so whiskers works
+// without semgrep. It only covers the intent of the built-in semgrep rules.
+type RubyScanner struct {
+	rules []*ruby.Rule
+}
+
+// NewRubyScanner creates a scanner for the built-in rules that are enabled
+// in set, with its severity overrides applied. All rules are used if set is nil.
+func NewRubyScanner(set *rules.Set) *RubyScanner {
+	if set == nil {
+		return &RubyScanner{rules: ruby.Rules}
+	}
+
+	severities := make(map[string]string)
+	for _, r := range set.Rules {
+		severities[r.ID] = r.Severity
+	}
+	var selected []*ruby.Rule
+	for _, r := range ruby.Rules {
+		severity, ok := severities[r.ID]
+		if !ok {
+			continue
+		}
+		rule := *r
+		if severity != "" {
+			rule.Severity = severity
+		}
+		selected = append(selected, &rule)
+	}
+	return &RubyScanner{rules: selected}
+}
+
+// Uncovered returns the IDs of the rules in set the ruby scanner has no equivalent for
+func Uncovered(set *rules.Set) []string {
+	builtin := make(map[string]bool)
+	for _, r := range ruby.Rules {
+		builtin[r.ID] = true
+	}
+	var ids []string
+	for _, id := range set.IDs() {
+		if !builtin[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Name returns "ruby"
+func (s *RubyScanner) Name() string {
+	return Ruby
+}
+
+// Scan parses the Ruby files among files and runs the rules over them
+func (s *RubyScanner) Scan(root string, files []string) (*semgrep.ScanResult, error) {
+	result := &semgrep.ScanResult{Findings: []*semgrep.Finding{}}
+	for _, file := range files {
+		if !rubyExtensions[strings.ToLower(filepath.Ext(file))] && !rubyFilenames[filepath.Base(file)] {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze %s: %w", file, err)
+		}
+		if bytes.IndexByte(content[:min(len(content), 8000)], 0) != -1 {
+			continue
+		}
+
+		f := ruby.Parse(content)
+		f.Path = file
+		result.Findings = append(result.Findings, ruby.Scan(f, s.rules)...)
+	}
+	return result, nil
+}
+
+// Close does nothing
+func (s *RubyScanner) Close() error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"whiskers/rules"
+	"whiskers/semgrep"
+)
+
+// SemgrepScanner runs a set of semgrep rules
+type SemgrepScanner struct {
+	runner *semgrep.Runner
+}
+
+// NewSemgrepScanner creates a scanner for the rule set
+func NewSemgrepScanner(set *rules.Set) *SemgrepScanner {
+	runner := semgrep.NewRunner(set.Dir)
+	runner.RuleIDs = set.IDs()
+	return &SemgrepScanner{runner: runner}
+}
+
+// Name returns "semgrep"
+func (s *SemgrepScanner) Name() string {
+	return Semgrep
+}
+
+// Scan runs semgrep over the files, in as few invocations as the argv limit allows
+func (s *SemgrepScanner) Scan(root string, files []string) (*semgrep.ScanResult, error) {
+	return s.runner.ScanBatch(files)
+}
+
+// Close does nothing
+func (s *SemgrepScanner) Close() error {
+	return nil
+}
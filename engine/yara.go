@@ -0,0 +1,302 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"whiskers/semgrep"
+)
+
+// Regular expressions for yara's output with -m -s
+var (
+	// Matches a string match line, e.g. "0x1a2:$payload: eval("
+	yaraStringRegex = regexp.MustCompile(`^0x([0-9a-fA-F]+):(\$[\w*]*):\s?(.*)$`)
+	// Matches a per-file error, e.g. "error scanning /tmp/x: could not open file"
+	yaraFileErrorRegex = regexp.MustCompile(`^error scanning (.+?): (.+)$`)
+)
+
+// YARAScanner runs a directory of YARA rules with the yara CLI. Unlike
+// semgrep it looks at every file, including binaries, so it catches
+// payloads known by their strings or bytes rather than their code.
+type YARAScanner struct {
+	ruleFiles []string
+}
+
+// yaraMatch is a rule that matched a file
+type yaraMatch struct {
+	rule string
+	meta map[string]string
+	path string
+	// The first string that matched, if yara reported any
+	offset     int64
+	identifier string
+	data       string
+}
+
+// NewYARAScanner creates a scanner for the .yar and .yara files under dir
+func NewYARAScanner(dir string) (*YARAScanner, error) {
+	var ruleFiles []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if ext := strings.ToLower(filepath.Ext(path)); ext == ".yar" || ext == ".yara" {
+			ruleFiles = append(ruleFiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read YARA rules: %w", err)
+	}
+	if len(ruleFiles) == 0 {
+		return nil, fmt.Errorf("no YARA rules (.yar or .yara) found in %s", dir)
+	}
+	sort.Strings(ruleFiles)
+	return &YARAScanner{ruleFiles: ruleFiles}, nil
+}
+
+// Name returns "yara"
+func (s *YARAScanner) Name() string {
+	return YARA
+}
+
+// Scan runs the rules over the files. The files are passed to yara in a
+// list file, so there is no limit on how many are scanned at once.
+func (s *YARAScanner) Scan(root string, files []string) (*semgrep.ScanResult, error) {
+	list, err := os.CreateTemp("", "whiskers-yara-*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create yara scan list: %w", err)
+	}
+	defer os.Remove(list.Name())
+	_, err = list.WriteString(strings.Join(files, "\n") + "\n")
+	if closeErr := list.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write yara scan list: %w", err)
+	}
+
+	// -m and -s print each rule's metadata and matched strings, -w hides
+	// warnings about slow rules
+	args := []string{"-m", "-s", "-w"}
+	args = append(args, s.ruleFiles...)
+	args = append(args, "--scan-list", list.Name())
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("yara", args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+
+	result := &semgrep.ScanResult{Findings: []*semgrep.Finding{}}
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		if match := yaraFileErrorRegex.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			result.ParseErrors = append(result.ParseErrors, semgrep.ScanError{
+				Type:    "YARA error",
+				Level:   "error",
+				Message: match[2],
+				Path:    match[1],
+			})
+		}
+	}
+
+	// Errors in the rules stop yara before it scans anything
+	if err != nil && len(output) == 0 && len(result.ParseErrors) == 0 {
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("yara failed: %s", strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("failed to run yara: %w", err)
+	}
+
+	for _, match := range parseYARAOutput(string(output)) {
+		result.Findings = append(result.Findings, match.finding())
+	}
+	return result, nil
+}
+
+// Close does nothing
+func (s *YARAScanner) Close() error {
+	return nil
+}
+
+// parseYARAOutput reads the rule and string lines yara prints with -m -s
+func parseYARAOutput(output string) []*yaraMatch {
+	var matches []*yaraMatch
+	var current *yaraMatch
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		if match := yaraStringRegex.FindStringSubmatch(line); match != nil {
+			// Only the first matched string of a rule locates the finding
+			if current != nil && current.identifier == "" {
+				current.offset, _ = strconv.ParseInt(match[1], 16, 64)
+				current.identifier = match[2]
+				current.data = match[3]
+			}
+			continue
+		}
+
+		if m := parseYARARuleLine(line); m != nil {
+			matches = append(matches, m)
+			current = m
+		}
+	}
+	return matches
+}
+
+// parseYARARuleLine parses a line such as
+// `SuspiciousLoader [author="x",severity="ERROR"] /tmp/gems/foo-1.0/lib/foo.rb`
+func parseYARARuleLine(line string) *yaraMatch {
+	rule, rest, ok := strings.Cut(line, " ")
+	if !ok || rule == "" {
+		return nil
+	}
+
+	m := &yaraMatch{rule: rule, meta: make(map[string]string)}
+	if strings.HasPrefix(rest, "[") {
+		end := metaEnd(rest)
+		if end < 0 {
+			return nil
+		}
+		m.meta = parseYARAMeta(rest[1:end])
+		rest = strings.TrimPrefix(rest[end+1:], " ")
+	}
+	if rest == "" {
+		return nil
+	}
+	m.path = rest
+	return m
+}
+
+// metaEnd returns the index of the bracket closing a metadata list, skipping quoted strings
+func metaEnd(s string) int {
+	inString := false
+	for i := 1; i < len(s); i++ {
+		switch {
+		case inString && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			inString = !inString
+		case !inString && s[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseYARAMeta parses comma-separated key=value pairs, where values are
+// quoted strings, integers or booleans
+func parseYARAMeta(s string) map[string]string {
+	meta := make(map[string]string)
+	for len(s) > 0 {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(key)
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			value = rest[1:min(end, len(rest))]
+			if unquoted, err := strconv.Unquote(`"` + value + `"`); err == nil {
+				value = unquoted
+			}
+			rest = rest[min(end+1, len(rest)):]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+
+		meta[key] = value
+		s = strings.TrimPrefix(rest, ",")
+	}
+	return meta
+}
+
+// finding converts a match to a finding. Rule metadata supplies the
+// message (description), severity, confidence and references.
+func (m *yaraMatch) finding() *semgrep.Finding {
+	f := &semgrep.Finding{
+		RuleID:   "yara." + m.rule,
+		Message:  m.meta["description"],
+		Path:     m.path,
+		Line:     1,
+		Severity: yaraSeverity(m.meta["severity"]),
+		Metadata: semgrep.Metadata{
+			Category:   "security",
+			Confidence: strings.ToUpper(m.meta["confidence"]),
+		},
+	}
+	if f.Message == "" {
+		f.Message = fmt.Sprintf("YARA rule %s matched", m.rule)
+	}
+	for _, key := range []string{"reference", "url"} {
+		if ref := m.meta[key]; ref != "" {
+			f.Metadata.References = append(f.Metadata.References, ref)
+		}
+	}
+	if m.identifier == "" {
+		return f
+	}
+
+	// Text files are located by line, so the finding shows the code; binary
+	// files are shown by the matched string, which stays stable across
+	// versions where the offset doesn't
+	content, err := os.ReadFile(m.path)
+	if err == nil && m.offset <= int64(len(content)) && !bytes.Contains(content[:min(len(content), 8000)], []byte{0}) {
+		before := content[:m.offset]
+		f.Line = bytes.Count(before, []byte("\n")) + 1
+		start := bytes.LastIndexByte(before, '\n') + 1
+		end := bytes.IndexByte(content[m.offset:], '\n')
+		if end < 0 {
+			end = len(content) - int(m.offset)
+		}
+		line := strings.TrimSpace(string(content[start : int(m.offset)+end]))
+		if len(line) > 200 {
+			line = line[:200] + "..."
+		}
+		f.Lines = line
+	} else {
+		f.Lines = fmt.Sprintf("%s: %s", m.identifier, m.data)
+		f.Message += fmt.Sprintf(" (binary file, offset 0x%x)", m.offset)
+	}
+	return f
+}
+
+// yaraSeverity maps a rule's severity metadata to a semgrep severity, WARNING by default
+func yaraSeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "error", "high", "critical":
+		return "ERROR"
+	case "info", "low", "note":
+		return "INFO"
+	default:
+		return "WARNING"
+	}
+}
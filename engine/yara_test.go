@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseYARAMeta(t *testing.T) {
+	tests := []struct {
+		meta string
+		want map[string]string
+	}{
+		{`author="x",severity="ERROR"`, map[string]string{"author": "x", "severity": "ERROR"}},
+		{`score=80,enabled=true`, map[string]string{"score": "80", "enabled": "true"}},
+		{`description="eval, then exec",version=2`, map[string]string{"description": "eval, then exec", "version": "2"}},
+		{`description="say \"hi\" [now]"`, map[string]string{"description": `say "hi" [now]`}},
+		{`url="https://example.com/?a=b"`, map[string]string{"url": "https://example.com/?a=b"}},
+		{`description="unterminated`, map[string]string{"description": "unterminated"}},
+		{``, map[string]string{}},
+		{`garbage`, map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := parseYARAMeta(tt.meta); !maps.Equal(got, tt.want) {
+			t.Errorf("parseYARAMeta(%q) = %v, want %v", tt.meta, got, tt.want)
+		}
+	}
+}
+
+func TestParseYARAOutput(t *testing.T) {
+	output := `SuspiciousLoader [author="x",description="loads [code]",severity="ERROR"] /gems/foo-1.0/lib/foo.rb
+0x1a2:$eval: eval(
+0x2b0:$decode: Base64.decode64
+NoMeta /gems/foo-1.0/lib/my file.rb
+
+Packed [] /gems/foo-1.0/ext/blob.bin
+0x10:$magic: \x7fELF
+0x40:$other: later strings are ignored
+Broken [author="x" /gems/foo-1.0/lib/bar.rb
+`
+	got := parseYARAOutput(output)
+	want := []yaraMatch{
+		{rule: "SuspiciousLoader", meta: map[string]string{"author": "x", "description": "loads [code]", "severity": "ERROR"},
+			path: "/gems/foo-1.0/lib/foo.rb", offset: 0x1a2, identifier: "$eval", data: "eval("},
+		{rule: "NoMeta", meta: map[string]string{}, path: "/gems/foo-1.0/lib/my file.rb"},
+		{rule: "Packed", meta: map[string]string{}, path: "/gems/foo-1.0/ext/blob.bin", offset: 0x10, identifier: "$magic", data: `\x7fELF`},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d matches, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.rule != w.rule || g.path != w.path || g.offset != w.offset || g.identifier != w.identifier || g.data != w.data || !maps.Equal(g.meta, w.meta) {
+			t.Errorf("match %d = %+v, want %+v", i, *g, w)
+		}
+	}
+}
+
+func TestYARAFinding(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "foo.rb")
+	if err := os.WriteFile(text, []byte("require 'base64'\n  eval(Base64.decode64(x))\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(dir, "blob.bin")
+	if err := os.WriteFile(binary, []byte("\x00\x01\x7fELF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		match yaraMatch
+		line  int
+		lines string
+	}{
+		{"text file", yaraMatch{rule: "Eval", path: text, offset: 19, identifier: "$eval", data: "eval("}, 2, "eval(Base64.decode64(x))"},
+		{"binary file", yaraMatch{rule: "Elf", path: binary, offset: 2, identifier: "$magic", data: `\x7fELF`}, 1, `$magic: \x7fELF`},
+		{"no strings", yaraMatch{rule: "Any", path: text}, 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.match.finding()
+			if f.RuleID != "yara."+tt.match.rule || f.Line != tt.line || f.Lines != tt.lines || f.Severity != "WARNING" {
+				t.Errorf("finding = %+v, want line %d %q", *f, tt.line, tt.lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package gem
+
+import (
+	"strings"
+	"sync"
+)
+
+// Credentials authenticate downloads from a private gem server
+type Credentials struct {
+	Username string
+	Password string
+}
+
+var (
+	credentialsMu sync.RWMutex
+	// credentials maps normalized source URLs to their credentials
+	credentials = make(map[string]Credentials)
+)
+
+// SetCredentials sets the credentials sent when downloading gems from a source URL
+func SetCredentials(sourceURL string, creds Credentials) {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	credentials[normalizeSourceURL(sourceURL)] = creds
+}
+
+// CredentialsFor returns the credentials set for a source URL, if any
+func CredentialsFor(sourceURL string) (Credentials, bool) {
+	credentialsMu.RLock()
+	defer credentialsMu.RUnlock()
+	creds, ok := credentials[normalizeSourceURL(sourceURL)]
+	return creds, ok
+}
+
+// normalizeSourceURL makes source URLs that differ only in a trailing slash equal
+func normalizeSourceURL(sourceURL string) string {
+	return strings.TrimSuffix(sourceURL, "/")
+}
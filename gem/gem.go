@@ -8,12 +8,18 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Source represents where a gem can be fetched from
 type Source struct {
 	Type string // e.g., "git", "rubygems"
 	URL  string // e.g., "https://rubygems.org" or git repository URL
+	// Revision, Tag, Branch and Ref pin a git source
+	Revision string `json:",omitempty"`
+	Tag      string `json:",omitempty"`
+	Branch   string `json:",omitempty"`
+	Ref      string `json:",omitempty"`
 }
 
 // Gem represents a Ruby gem with its basic metadata
@@ -42,60 +48,87 @@ func (g *Gem) IsFromRubyGems() bool {
 	return g.Source.URL == "https://rubygems.org/"
 }
 
-// GetDownloadURL returns the URL to download the gem
+// GetDownloadURL returns the URL to download the gem, or nothing if its
+// source isn't a gem server, e.g. a git repository or a local path
 func (g *Gem) GetDownloadURL() string {
-	if g.IsFromRubyGems() {
-		return fmt.Sprintf("https://rubygems.org/gems/%s-%s.gem", g.Name, g.Version)
+	if g.Source.Type == "git" || g.Source.Type == "path" || g.Source.URL == "" {
+		return ""
 	}
-	return ""
+	return fmt.Sprintf("%s/gems/%s-%s.gem", strings.TrimSuffix(g.Source.URL, "/"), g.Name, g.Version)
 }
 
 // DownloadAndExtract downloads the gem file and extracts it to the specified directory
 func (g *Gem) DownloadAndExtract(baseDir string) error {
-	if !g.IsFromRubyGems() {
-		return fmt.Errorf("downloading is only supported for RubyGems.org gems")
+	gemFile, err := g.Download()
+	if err != nil {
+		return err
 	}
+	defer os.Remove(gemFile)
 
-	// Create the target directory (baseDir/name-version)
-	targetDir := filepath.Join(baseDir, fmt.Sprintf("%s-%s", g.Name, g.Version))
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", targetDir, err)
-	}
+	return g.Extract(gemFile, baseDir)
+}
 
-	// Download the gem file
+// Download fetches the .gem file to a temporary file and returns its path.
+// The caller is responsible for removing it.
+func (g *Gem) Download() (string, error) {
 	url := g.GetDownloadURL()
-	resp, err := http.Get(url)
+	if url == "" {
+		return "", fmt.Errorf("downloading is only supported for gems from a gem server, not %s sources", g.Source.Type)
+	}
+
+	// Download the gem file, authenticating to private gem servers
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid download URL %s: %w", url, err)
+	}
+	if creds, ok := CredentialsFor(g.Source.URL); ok {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to download gem from %s: %w", url, err)
+		return "", fmt.Errorf("failed to download gem from %s: %w", url, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download gem from %s: status %d", url, resp.StatusCode)
+		return "", fmt.Errorf("failed to download gem from %s: status %d", url, resp.StatusCode)
 	}
 
 	// Create a temporary file to store the downloaded gem
 	tempFile, err := os.CreateTemp("", "*.gem")
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %w", err)
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
 	}
-	defer os.Remove(tempFile.Name())
 	defer tempFile.Close()
 
 	// Copy the downloaded content to the temporary file
 	if _, err := io.Copy(tempFile, resp.Body); err != nil {
-		return fmt.Errorf("failed to save downloaded gem: %w", err)
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("failed to save downloaded gem: %w", err)
+	}
+
+	return tempFile.Name(), nil
+}
+
+// Extract unpacks a downloaded .gem file to the specified directory
+func (g *Gem) Extract(gemFile, baseDir string) error {
+	// Create the target directory (baseDir/name-version)
+	targetDir := g.ExtractPath(baseDir)
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", targetDir, err)
 	}
 
-	// Rewind the temp file for reading
-	if _, err := tempFile.Seek(0, 0); err != nil {
-		return fmt.Errorf("failed to rewind temporary file: %w", err)
+	tempFile, err := os.Open(gemFile)
+	if err != nil {
+		return fmt.Errorf("failed to open gem file: %w", err)
 	}
+	defer tempFile.Close()
 
 	// Open the .gem file as a tar archive
 	tarReader := tar.NewReader(tempFile)
 
-	// Find and extract data.tar.gz
+	// Find and extract metadata.gz and data.tar.gz
+	foundData := false
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
@@ -105,6 +138,13 @@ func (g *Gem) DownloadAndExtract(baseDir string) error {
 			return fmt.Errorf("failed to read tar header: %w", err)
 		}
 
+		if header.Name == "metadata.gz" {
+			if err := g.extractMetadata(tarReader, baseDir); err != nil {
+				return err
+			}
+			continue
+		}
+
 		if header.Name == "data.tar.gz" {
 			// Create a temporary file for data.tar.gz
 			dataTarGz, err := os.CreateTemp("", "data.tar.gz")
@@ -170,11 +210,49 @@ func (g *Gem) DownloadAndExtract(baseDir string) error {
 					file.Close()
 				}
 			}
-			return nil
+			foundData = true
 		}
 	}
 
-	return fmt.Errorf("data.tar.gz not found in gem file")
+	if !foundData {
+		return fmt.Errorf("data.tar.gz not found in gem file")
+	}
+	return nil
+}
+
+// extractMetadata decompresses the gemspec metadata next to the extracted gem
+func (g *Gem) extractMetadata(r io.Reader, baseDir string) error {
+	gzReader, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip reader for metadata.gz: %w", err)
+	}
+	defer gzReader.Close()
+
+	file, err := os.Create(g.MetadataPath(baseDir))
+	if err != nil {
+		return fmt.Errorf("failed to create metadata file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, gzReader); err != nil {
+		return fmt.Errorf("failed to write metadata file: %w", err)
+	}
+	return nil
+}
+
+// ExtractPath returns the directory the gem is extracted to under baseDir
+func (g *Gem) ExtractPath(baseDir string) string {
+	return filepath.Join(baseDir, fmt.Sprintf("%s-%s", g.Name, g.Version))
+}
+
+// MetadataPath returns the path of the gemspec metadata saved under baseDir
+func (g *Gem) MetadataPath(baseDir string) string {
+	return g.ExtractPath(baseDir) + ".gemspec.yaml"
+}
+
+// LoadSpec reads the gemspec metadata saved when the gem was extracted under baseDir
+func (g *Gem) LoadSpec(baseDir string) (*Spec, error) {
+	return LoadSpec(g.MetadataPath(baseDir))
 }
 
 // DefaultSource returns the default RubyGems source
@@ -183,4 +261,4 @@ func DefaultSource() Source {
 		Type: "rubygems",
 		URL:  "https://rubygems.org/",
 	}
-} 
+}
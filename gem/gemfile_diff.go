@@ -109,10 +109,9 @@ func (d *GemfileDiff) GetVersionChanges() []VersionChange {
 	return d.VersionChanges
 }
 
-// SaveToJSON writes the diff to a JSON file at the specified path
-func (d *GemfileDiff) SaveToJSON(path string) error {
-	// Convert to JSON-friendly structure
-	diffJSON := DiffJSON{
+// ToJSON converts the diff to its JSON structure
+func (d *GemfileDiff) ToJSON() *DiffJSON {
+	diffJSON := &DiffJSON{
 		Added:         make([]GemJSON, len(d.Added)),
 		Removed:       make([]GemJSON, len(d.Removed)),
 		VersionChanges: make([]VersionChangeJSON, len(d.VersionChanges)),
@@ -153,8 +152,43 @@ func (d *GemfileDiff) SaveToJSON(path string) error {
 		}
 	}
 
+	return diffJSON
+}
+
+// Diff converts the JSON structure back to a GemfileDiff
+func (d *DiffJSON) Diff() *GemfileDiff {
+	diff := &GemfileDiff{
+		Added:         make([]*Gem, len(d.Added)),
+		Removed:       make([]*Gem, len(d.Removed)),
+		VersionChanges: make([]VersionChange, len(d.VersionChanges)),
+	}
+
+	// Convert Added gems
+	for i, gemJSON := range d.Added {
+		diff.Added[i] = NewGem(gemJSON.Name, gemJSON.Version, gemJSON.Source)
+	}
+
+	// Convert Removed gems
+	for i, gemJSON := range d.Removed {
+		diff.Removed[i] = NewGem(gemJSON.Name, gemJSON.Version, gemJSON.Source)
+	}
+
+	// Convert Version changes
+	for i, change := range d.VersionChanges {
+		diff.VersionChanges[i] = VersionChange{
+			Name:   change.Name,
+			Before: NewGem(change.BeforeGem.Name, change.BeforeGem.Version, change.BeforeGem.Source),
+			After:  NewGem(change.AfterGem.Name, change.AfterGem.Version, change.AfterGem.Source),
+		}
+	}
+
+	return diff
+}
+
+// SaveToJSON writes the diff to a JSON file at the specified path
+func (d *GemfileDiff) SaveToJSON(path string) error {
 	// Marshal to JSON
-	data, err := json.MarshalIndent(diffJSON, "", "  ")
+	data, err := json.MarshalIndent(d.ToJSON(), "", "  ")
 	if err != nil {
 		return err
 	}
@@ -177,31 +211,5 @@ func LoadFromJSON(path string) (*GemfileDiff, error) {
 		return nil, err
 	}
 
-	// Create new GemfileDiff
-	diff := &GemfileDiff{
-		Added:         make([]*Gem, len(diffJSON.Added)),
-		Removed:       make([]*Gem, len(diffJSON.Removed)),
-		VersionChanges: make([]VersionChange, len(diffJSON.VersionChanges)),
-	}
-
-	// Convert Added gems
-	for i, gemJSON := range diffJSON.Added {
-		diff.Added[i] = NewGem(gemJSON.Name, gemJSON.Version, gemJSON.Source)
-	}
-
-	// Convert Removed gems
-	for i, gemJSON := range diffJSON.Removed {
-		diff.Removed[i] = NewGem(gemJSON.Name, gemJSON.Version, gemJSON.Source)
-	}
-
-	// Convert Version changes
-	for i, change := range diffJSON.VersionChanges {
-		diff.VersionChanges[i] = VersionChange{
-			Name:   change.Name,
-			Before: NewGem(change.BeforeGem.Name, change.BeforeGem.Version, change.BeforeGem.Source),
-			After:  NewGem(change.AfterGem.Name, change.AfterGem.Version, change.AfterGem.Source),
-		}
-	}
-
-	return diff, nil
-} 
+	return diffJSON.Diff(), nil
+}
@@ -19,8 +19,10 @@ var (
 	gemSpecRegex = regexp.MustCompile(`^\s+([^\s(]+)\s*\(([^)]+)\)`)
 	// Matches lines like "  remote: https://rubygems.org/"
 	sourceRegex = regexp.MustCompile(`^\s*remote:\s*(.+)`)
+	// Matches lines like "  tag: v1.2.0" under a GIT section
+	gitPinRegex = regexp.MustCompile(`^\s*(revision|tag|branch|ref):\s*(.+)`)
 	// Matches section headers like "GEM" or "PATH"
-	sectionRegex = regexp.MustCompile(`^(GEM|PATH|PLATFORMS|DEPENDENCIES|BUNDLED WITH)\s*$`)
+	sectionRegex = regexp.MustCompile(`^(GIT|GEM|PATH|PLATFORMS|DEPENDENCIES|BUNDLED WITH)\s*$`)
 )
 
 // NewGemfileLock creates a new GemfileLock instance from file contents
@@ -71,13 +73,31 @@ func (g *GemfileLock) parse() {
 			continue
 		}
 
+		// Git sources are pinned to a revision, and may name a tag or branch
+		if currentSection == "GIT" && !inSpecs {
+			if pinMatch := gitPinRegex.FindStringSubmatch(line); pinMatch != nil {
+				value := strings.TrimSpace(pinMatch[2])
+				switch pinMatch[1] {
+				case "revision":
+					currentSource.Revision = value
+				case "tag":
+					currentSource.Tag = value
+				case "branch":
+					currentSource.Branch = value
+				case "ref":
+					currentSource.Ref = value
+				}
+				continue
+			}
+		}
+
 		// Look for the specs subsection
 		if trimmedLine == "specs:" {
 			inSpecs = true
 			continue
 		}
 
-		// Only parse specs section under GEM or PATH, skip DEPENDENCIES section
+		// Only parse specs section under GIT, GEM or PATH, skip DEPENDENCIES section
 		if !inSpecs || inDependencies {
 			continue
 		}
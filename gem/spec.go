@@ -0,0 +1,227 @@
+package gem
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec represents the gemspec metadata shipped in a .gem file's metadata.gz
+type Spec struct {
+	Name         string            `yaml:"name"`
+	Version      SpecVersion       `yaml:"version"`
+	Authors      []string          `yaml:"authors"`
+	Email        StringList        `yaml:"email"`
+	Homepage     string            `yaml:"homepage"`
+	Bindir       string            `yaml:"bindir"`
+	Executables  []string          `yaml:"executables"`
+	Extensions   []string          `yaml:"extensions"`
+	RequirePaths []string          `yaml:"require_paths"`
+	Files        []string          `yaml:"files"`
+	Dependencies []Dependency      `yaml:"dependencies"`
+	Metadata     map[string]string `yaml:"metadata"`
+}
+
+// SpecVersion is a Gem::Version, serialized as a mapping with a single version key
+type SpecVersion string
+
+// UnmarshalYAML decodes a Gem::Version mapping or a plain version string
+func (v *SpecVersion) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*v = SpecVersion(node.Value)
+		return nil
+	}
+
+	var version struct {
+		Version string `yaml:"version"`
+	}
+	if err := node.Decode(&version); err != nil {
+		return err
+	}
+	*v = SpecVersion(version.Version)
+	return nil
+}
+
+// StringList is a gemspec field that may be either a single string or a list
+type StringList []string
+
+// UnmarshalYAML decodes either a scalar or a sequence of strings
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if node.Value != "" {
+			*l = StringList{node.Value}
+		}
+		return nil
+	}
+
+	var values []string
+	if err := node.Decode(&values); err != nil {
+		return err
+	}
+	*l = values
+	return nil
+}
+
+// Dependency represents a Gem::Dependency declared by a gemspec
+type Dependency struct {
+	Name        string
+	Type        string // "runtime" or "development"
+	Requirement string // e.g. "~> 1.0, >= 1.0.2"
+}
+
+// UnmarshalYAML decodes a Gem::Dependency, flattening its Gem::Requirement
+func (d *Dependency) UnmarshalYAML(node *yaml.Node) error {
+	var raw struct {
+		Name        string `yaml:"name"`
+		Type        string `yaml:"type"`
+		Requirement struct {
+			Requirements [][]yaml.Node `yaml:"requirements"`
+		} `yaml:"requirement"`
+	}
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	var constraints []string
+	for _, requirement := range raw.Requirement.Requirements {
+		if len(requirement) != 2 {
+			continue
+		}
+		var version SpecVersion
+		if err := requirement[1].Decode(&version); err != nil {
+			return err
+		}
+		constraints = append(constraints, fmt.Sprintf("%s %s", requirement[0].Value, version))
+	}
+
+	d.Name = raw.Name
+	d.Type = strings.TrimPrefix(raw.Type, ":")
+	d.Requirement = strings.Join(constraints, ", ")
+	return nil
+}
+
+// LoadSpec reads gemspec metadata saved by DownloadAndExtract
+func LoadSpec(path string) (*Spec, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec Spec
+	if err := yaml.Unmarshal(content, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse gem metadata %s: %w", path, err)
+	}
+
+	if len(spec.RequirePaths) == 0 {
+		spec.RequirePaths = []string{"lib"}
+	}
+	return &spec, nil
+}
+
+// HasExtensions returns true if the gem builds native extensions at install time
+func (s *Spec) HasExtensions() bool {
+	return s != nil && len(s.Extensions) > 0
+}
+
+// SpecChange is a difference in one field of two gemspecs. A changed single
+// value, such as the homepage, is its old value removed and its new value added.
+type SpecChange struct {
+	Field   string   `json:"field"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// CompareSpecs returns the fields that differ between two gemspecs, in a
+// fixed order. The file list is left out, since the file diff covers it.
+func CompareSpecs(before, after *Spec) []SpecChange {
+	if before == nil || after == nil {
+		return nil
+	}
+
+	fields := []struct {
+		name          string
+		before, after []string
+	}{
+		{"authors", before.Authors, after.Authors},
+		{"email", before.Email, after.Email},
+		{"homepage", optional(before.Homepage), optional(after.Homepage)},
+		{"dependencies", before.dependencies("runtime"), after.dependencies("runtime")},
+		{"development_dependencies", before.dependencies("development"), after.dependencies("development")},
+		{"extensions", before.Extensions, after.Extensions},
+		{"executables", before.Executables, after.Executables},
+		{"bindir", optional(before.Bindir), optional(after.Bindir)},
+		{"require_paths", before.RequirePaths, after.RequirePaths},
+		{"metadata", before.metadataPairs(), after.metadataPairs()},
+	}
+
+	var changes []SpecChange
+	for _, field := range fields {
+		added, removed := compareLists(field.before, field.after)
+		if len(added) > 0 || len(removed) > 0 {
+			changes = append(changes, SpecChange{Field: field.name, Added: added, Removed: removed})
+		}
+	}
+	return changes
+}
+
+// dependencies lists the dependencies of a type as "name requirement"
+func (s *Spec) dependencies(depType string) []string {
+	var deps []string
+	for _, d := range s.Dependencies {
+		if d.Type != depType {
+			continue
+		}
+		if d.Requirement != "" {
+			deps = append(deps, d.Name+" "+d.Requirement)
+		} else {
+			deps = append(deps, d.Name)
+		}
+	}
+	return deps
+}
+
+// metadataPairs lists the metadata as sorted "key: value" pairs
+func (s *Spec) metadataPairs() []string {
+	pairs := make([]string, 0, len(s.Metadata))
+	for key, value := range s.Metadata {
+		pairs = append(pairs, key+": "+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// optional returns a single value as a list, or nothing if it is empty
+func optional(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// compareLists returns the values only in after and the values only in before
+func compareLists(before, after []string) ([]string, []string) {
+	inBefore := make(map[string]bool, len(before))
+	for _, v := range before {
+		inBefore[v] = true
+	}
+	inAfter := make(map[string]bool, len(after))
+	for _, v := range after {
+		inAfter[v] = true
+	}
+
+	var added, removed []string
+	for _, v := range after {
+		if !inBefore[v] {
+			added = append(added, v)
+		}
+	}
+	for _, v := range before {
+		if !inAfter[v] {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,195 @@
+package gem
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Bump classifies the kind of version change between two gem versions
+type Bump string
+
+// Known version bump classes
+const (
+	BumpNone       Bump = "none"
+	BumpMajor      Bump = "major"
+	BumpMinor      Bump = "minor"
+	BumpPatch      Bump = "patch"
+	BumpPrerelease Bump = "prerelease"
+	BumpDowngrade  Bump = "downgrade"
+)
+
+// Matches the boundaries between digit and letter runs, e.g. "1.0.0rc1"
+var versionSegmentRegex = regexp.MustCompile(`[0-9]+|[a-zA-Z]+`)
+
+// versionNumber drops the platform Gemfile.lock appends to the versions of
+// platform-specific gems, e.g. "1.16.0-x86_64-linux" becomes "1.16.0"
+func versionNumber(version string) string {
+	number, _, _ := strings.Cut(version, "-")
+	return number
+}
+
+// versionSegments splits a version string the way RubyGems does, with
+// trailing zero segments removed so that "1.0" and "1.0.0" compare equal
+func versionSegments(version string) []string {
+	segments := versionSegmentRegex.FindAllString(versionNumber(version), -1)
+	for len(segments) > 1 && segments[len(segments)-1] == "0" {
+		segments = segments[:len(segments)-1]
+	}
+	return segments
+}
+
+// isPrerelease returns true if the version contains a letter, e.g. "2.0.0.rc1"
+func isPrerelease(version string) bool {
+	return strings.ContainsAny(strings.ToLower(versionNumber(version)), "abcdefghijklmnopqrstuvwxyz")
+}
+
+// CompareVersions compares two gem versions using RubyGems ordering. It
+// returns -1 if a < b, 0 if they are equal and 1 if a > b.
+func CompareVersions(a, b string) int {
+	left := versionSegments(a)
+	right := versionSegments(b)
+
+	for i := 0; i < len(left) || i < len(right); i++ {
+		l, r := "0", "0"
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		if c := compareSegments(l, r); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareSegments compares two version segments. Numeric segments compare
+// numerically, and a prerelease (letter) segment sorts before any number.
+func compareSegments(a, b string) int {
+	aNum, aErr := strconv.Atoi(a)
+	bNum, bErr := strconv.Atoi(b)
+
+	switch {
+	case aErr == nil && bErr == nil:
+		if aNum < bNum {
+			return -1
+		}
+		if aNum > bNum {
+			return 1
+		}
+		return 0
+	case aErr == nil:
+		return 1
+	case bErr == nil:
+		return -1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// ClassifyBump returns the kind of version change from before to after
+func ClassifyBump(before, after string) Bump {
+	switch CompareVersions(before, after) {
+	case 0:
+		return BumpNone
+	case 1:
+		return BumpDowngrade
+	}
+
+	if isPrerelease(after) {
+		return BumpPrerelease
+	}
+
+	left := versionSegments(before)
+	right := versionSegments(after)
+	for i := 0; i < len(left) || i < len(right); i++ {
+		l, r := "0", "0"
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		if compareSegments(l, r) == 0 {
+			continue
+		}
+		switch i {
+		case 0:
+			return BumpMajor
+		case 1:
+			return BumpMinor
+		}
+		return BumpPatch
+	}
+	return BumpPatch
+}
+
+// Bump returns the kind of version change for this gem
+func (c VersionChange) Bump() Bump {
+	return ClassifyBump(c.Before.Version, c.After.Version)
+}
+
+// Matches a single requirement such as ">= 1.2" or "~> 2.0.1"
+var requirementRegex = regexp.MustCompile(`^\s*(=|!=|>=|<=|>|<|~>)?\s*([0-9][0-9a-zA-Z.\-]*)\s*$`)
+
+// MatchesRequirement returns true if version satisfies a comma-separated
+// RubyGems requirement such as ">= 1.2, < 2.0" or "~> 3.1". An empty
+// requirement matches every version.
+func MatchesRequirement(version, requirement string) (bool, error) {
+	if strings.TrimSpace(requirement) == "" {
+		return true, nil
+	}
+
+	for _, part := range strings.Split(requirement, ",") {
+		match := requirementRegex.FindStringSubmatch(part)
+		if match == nil {
+			return false, fmt.Errorf("invalid version requirement: %q", strings.TrimSpace(part))
+		}
+
+		op, target := match[1], match[2]
+		c := CompareVersions(version, target)
+		var ok bool
+		switch op {
+		case "", "=":
+			ok = c == 0
+		case "!=":
+			ok = c != 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		case "~>":
+			ok = c >= 0 && CompareVersions(version, pessimisticBound(target)) < 0
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// pessimisticBound returns the exclusive upper bound of "~> version" the way
+// RubyGems bumps it, e.g. "2.0.1" becomes "2.1" and "3" becomes "4"
+func pessimisticBound(version string) string {
+	// Prerelease segments are dropped first, e.g. "1.0.0.rc1" becomes "1.1"
+	segments := versionSegmentRegex.FindAllString(versionNumber(version), -1)
+	for i, segment := range segments {
+		if _, err := strconv.Atoi(segment); err != nil && i > 0 {
+			segments = segments[:i]
+			break
+		}
+	}
+	if len(segments) > 1 {
+		segments = segments[:len(segments)-1]
+	}
+	last, _ := strconv.Atoi(segments[len(segments)-1])
+	segments[len(segments)-1] = strconv.Itoa(last + 1)
+	return strings.Join(segments, ".")
+}
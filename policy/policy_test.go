@@ -0,0 +1,179 @@
+package policy
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"whiskers/gem"
+	"whiskers/semgrep"
+)
+
+// rubygems is the default gem source
+var rubygems = gem.Source{Type: "rubygems", URL: "https://rubygems.org/"}
+
+// update returns a change of a gem from one version to another on rubygems.org
+func update(name, before, after string) Change {
+	return Change{
+		Name:   name,
+		Before: &gem.GemJSON{Name: name, Version: before, Source: rubygems},
+		After:  gem.GemJSON{Name: name, Version: after, Source: rubygems},
+		Bump:   gem.ClassifyBump(before, after),
+	}
+}
+
+// violations returns the rules each gem broke, keyed by gem name
+func violations(result *Result) map[string][]string {
+	broken := make(map[string][]string)
+	for _, g := range result.Gems {
+		for _, v := range g.Violations {
+			broken[g.Name] = append(broken[g.Name], v.Rule)
+		}
+	}
+	return broken
+}
+
+func TestEvaluate(t *testing.T) {
+	withSpec := func(c Change, changes ...gem.SpecChange) Change {
+		c.MetadataChanges = changes
+		return c
+	}
+	withFindings := func(c Change, severities ...string) Change {
+		for _, severity := range severities {
+			c.Findings = append(c.Findings, &semgrep.Finding{Severity: severity})
+		}
+		return c
+	}
+	added := Change{Name: "rials", After: gem.GemJSON{Name: "rials", Version: "1.0", Source: rubygems}, Typosquats: []string{"rails"}}
+	gitBranch := update("forked", "1.0", "1.1")
+	gitBranch.After.Source = gem.Source{Type: "git", URL: "https://github.com/me/forked", Branch: "main"}
+	moved := update("moved", "1.0", "1.1")
+	moved.After.Source.URL = "https://evil.example/"
+	incomplete := update("broken", "1.0", "1.1")
+	incomplete.Incomplete = true
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		changes  []Change
+		decision Decision
+		want     map[string][]string
+	}{
+		{
+			name:     "no rules",
+			changes:  []Change{update("rails", "7.0.0", "7.0.1"), added},
+			decision: Allow,
+			want:     map[string][]string{},
+		},
+		{
+			name:     "sources",
+			policy:   Policy{AllowedSources: []string{"https://rubygems.org"}, Rules: []Rule{{ID: "sources", Check: CheckSourceNotAllowed, Action: Deny}}},
+			changes:  []Change{update("rails", "7.0.0", "7.0.1"), moved, added},
+			decision: Deny,
+			want:     map[string][]string{"moved": {"sources"}},
+		},
+		{
+			name:     "downgrade and git",
+			policy:   Policy{Rules: []Rule{{ID: "downgrade", Check: CheckDowngrade, Action: Warn}, {ID: "git", Check: CheckGitWithoutTag, Action: Warn}}},
+			changes:  []Change{update("rails", "7.0.1", "7.0.0"), gitBranch, update("rack", "2.0", "2.1")},
+			decision: Warn,
+			want:     map[string][]string{"rails": {"downgrade"}, "forked": {"git"}},
+		},
+		{
+			name:   "adds extension and executable",
+			policy: Policy{Rules: []Rule{{ID: "ext", Check: CheckAddsExtension, Action: Deny}, {ID: "exe", Check: CheckAddsExecutable, Action: Warn}}},
+			changes: []Change{
+				withSpec(update("nokogiri", "1.0", "1.1"), gem.SpecChange{Field: "extensions", Added: []string{"ext/a/extconf.rb", "ext/b/extconf.rb"}}),
+				withSpec(update("rake", "1.0", "1.1"), gem.SpecChange{Field: "executables", Added: []string{"rake"}}),
+				withSpec(update("rack", "1.0", "1.1"), gem.SpecChange{Field: "extensions", Removed: []string{"ext/extconf.rb"}}),
+				// Added gems aren't downloaded, so they have no gemspec changes
+				added,
+			},
+			decision: Deny,
+			want:     map[string][]string{"nokogiri": {"ext", "ext"}, "rake": {"exe"}},
+		},
+		{
+			name:     "max findings",
+			policy:   Policy{Rules: []Rule{{ID: "findings", Check: CheckMaxFindings, Action: Deny, Severity: "WARNING", Max: 1}}},
+			changes:  []Change{withFindings(update("a", "1.0", "1.1"), "WARNING", "HIGH"), withFindings(update("b", "1.0", "1.1"), "ERROR", "INFO", "INFO")},
+			decision: Deny,
+			want:     map[string][]string{"a": {"findings"}},
+		},
+		{
+			name:     "incomplete and typosquat",
+			policy:   Policy{Rules: []Rule{{ID: "incomplete", Check: CheckIncomplete, Action: Warn}, {ID: "typosquat", Check: CheckTyposquat, Action: Deny}}},
+			changes:  []Change{incomplete, added},
+			decision: Deny,
+			want:     map[string][]string{"broken": {"incomplete"}, "rials": {"typosquat"}},
+		},
+		{
+			name: "gems and except",
+			policy: Policy{Rules: []Rule{
+				{ID: "only-rails", Check: CheckDowngrade, Action: Deny, Gems: []string{"rail*"}, Except: []string{"railties"}},
+			}},
+			changes:  []Change{update("rails", "2.0", "1.0"), update("railties", "2.0", "1.0"), update("rack", "2.0", "1.0")},
+			decision: Deny,
+			want:     map[string][]string{"rails": {"only-rails"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.validate(); err != nil {
+				t.Fatal(err)
+			}
+			result := tt.policy.Evaluate(tt.changes)
+			if result.Decision != tt.decision {
+				t.Errorf("Decision = %s, want %s", result.Decision, tt.decision)
+			}
+			got := violations(result)
+			if len(got) != len(tt.want) {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+			for name, rules := range tt.want {
+				if !slices.Equal(got[name], rules) {
+					t.Errorf("violations of %s = %v, want %v", name, got[name], rules)
+				}
+			}
+			if len(result.Gems) != len(tt.changes) {
+				t.Fatalf("got %d gem decisions, want %d", len(result.Gems), len(tt.changes))
+			}
+			if !slices.IsSortedFunc(result.Gems, func(a, b GemDecision) int { return strings.Compare(a.Name, b.Name) }) {
+				t.Errorf("gems aren't sorted by name: %+v", result.Gems)
+			}
+		})
+	}
+}
+
+func TestEvaluateGemDecision(t *testing.T) {
+	p := Policy{Rules: []Rule{
+		{ID: "warn", Check: CheckDowngrade, Action: Warn},
+		{ID: "deny", Check: CheckDowngrade, Action: Deny},
+	}}
+	result := p.Evaluate([]Change{update("rails", "7.1", "7.0")})
+	g := result.Gems[0]
+	if g.Decision != Deny || g.Version != "7.0" || g.PreviousVersion != "7.1" {
+		t.Errorf("decision = %+v, want a deny of 7.1 → 7.0", g)
+	}
+	if len(g.Violations) != 2 || g.Violations[0].Message != "downgrades from 7.1 to 7.0" {
+		t.Errorf("violations = %+v", g.Violations)
+	}
+	if result.Denied() != 1 {
+		t.Errorf("Denied() = %d, want 1", result.Denied())
+	}
+}
+
+func TestWorse(t *testing.T) {
+	tests := []struct {
+		a, b, want Decision
+	}{
+		{Allow, Allow, Allow},
+		{Allow, Warn, Warn},
+		{Deny, Warn, Deny},
+		{Warn, Allow, Warn},
+		{Allow, Deny, Deny},
+	}
+	for _, tt := range tests {
+		if got := Worse(tt.a, tt.b); got != tt.want {
+			t.Errorf("Worse(%s, %s) = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
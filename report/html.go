@@ -0,0 +1,351 @@
+package report
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"whiskers/semgrep"
+	"whiskers/utils"
+)
+
+// diffContext is how many unchanged lines are shown around changes and findings
+const diffContext = 3
+
+// maxHTMLFileBytes is the largest file whose contents the HTML report shows
+const maxHTMLFileBytes = 1 << 20
+
+// maxHTMLRows caps the diff rows shown for one file
+const maxHTMLRows = 5000
+
+//go:embed html.tmpl
+var htmlSource string
+
+var htmlTemplate = template.Must(template.New("report").Parse(htmlSource))
+
+// htmlPage is what the HTML template renders
+type htmlPage struct {
+	*Report
+	Gems       []*htmlGem
+	Severities []string
+	RuleIDs    []string
+	Packs      string
+	Findings   int
+}
+
+// htmlGem is a gem section of the HTML report
+type htmlGem struct {
+	*Gem
+	Anchor   string
+	Counts   []htmlCount
+	Status   string
+	Findings []*htmlFinding
+	Files    []*htmlFile
+}
+
+// htmlCount is the number of a gem's findings of a severity
+type htmlCount struct {
+	Level string
+	Count int
+}
+
+// htmlLevels are the severities of findings in the HTML report, most severe first
+var htmlLevels = []string{"error", "warning", "info", "unknown"}
+
+// htmlFinding is a finding with where it is shown in the report
+type htmlFinding struct {
+	*semgrep.Finding
+	// Level is the normalized severity in lowercase, e.g. "error"
+	Level string
+	// Href links to the finding's line, or to its file if the line isn't shown
+	Href string
+	Link string
+}
+
+// htmlFile is a file of a gem shown as a side-by-side diff
+type htmlFile struct {
+	Path   string
+	Status string
+	Anchor string
+	// Note explains why the file's contents aren't shown
+	Note      string
+	Rows      []*htmlRow
+	Truncated int
+	Findings  int
+}
+
+// htmlRow is a row of a side-by-side diff, or a gap of unchanged lines
+type htmlRow struct {
+	Gap      int
+	Old, New htmlCell
+	Anchor   string
+	Findings []*htmlFinding
+	Level    string
+}
+
+// htmlCell is one side of a diff row
+type htmlCell struct {
+	Num   int
+	Text  string
+	Class string
+}
+
+// HTML writes a report as a single self-contained HTML page: a gem index,
+// each gem's metadata changes and findings, and a side-by-side diff of its
+// changed files with findings shown on their lines. File contents are read
+// from the extracted gems, so the diffs are only shown while they still exist.
+func HTML(w io.Writer, r *Report) error {
+	page := &htmlPage{Report: r}
+
+	severities := make(map[string]bool)
+	ruleIDs := make(map[string]bool)
+	for i, g := range sortedForReview(r.Gems) {
+		hg := newHTMLGem(g, fmt.Sprintf("gem-%d", i))
+		for _, f := range hg.Findings {
+			severities[f.Level] = true
+			ruleIDs[f.RuleID] = true
+		}
+		page.Findings += len(hg.Findings)
+		page.Gems = append(page.Gems, hg)
+	}
+
+	for _, level := range htmlLevels {
+		if severities[level] {
+			page.Severities = append(page.Severities, level)
+		}
+	}
+	for id := range ruleIDs {
+		page.RuleIDs = append(page.RuleIDs, id)
+	}
+	sort.Strings(page.RuleIDs)
+
+	packs := make([]string, 0, len(r.Rules.Packs))
+	for _, p := range r.Rules.Packs {
+		packs = append(packs, p.Name)
+	}
+	page.Packs = strings.Join(packs, ", ")
+
+	if err := htmlTemplate.Execute(w, page); err != nil {
+		return fmt.Errorf("failed to write HTML report: %w", err)
+	}
+	return nil
+}
+
+// newHTMLGem lays out a gem's findings and file diffs
+func newHTMLGem(g *Gem, anchor string) *htmlGem {
+	hg := &htmlGem{
+		Gem:    g,
+		Anchor: anchor,
+		Status: gemStatus(g),
+	}
+
+	counts := make(map[string]int)
+	byPath := make(map[string][]*htmlFinding)
+	for _, f := range g.Findings {
+		level := strings.ToLower(normalizeSeverity(f.Severity))
+		if level == "" {
+			level = "unknown"
+		}
+		hf := &htmlFinding{Finding: f, Level: level, Link: sourceLink(g, f)}
+		counts[level]++
+		hg.Findings = append(hg.Findings, hf)
+		if f.Path != "" {
+			byPath[f.Path] = append(byPath[f.Path], hf)
+		}
+	}
+
+	for _, level := range htmlLevels {
+		if counts[level] > 0 {
+			hg.Counts = append(hg.Counts, htmlCount{Level: level, Count: counts[level]})
+		}
+	}
+
+	// Changed files first, then added and removed ones, then unchanged files
+	// with findings, such as load-time code
+	statuses := make(map[string]string)
+	var paths []string
+	if g.Files != nil {
+		for _, group := range []struct {
+			status string
+			paths  []string
+		}{{"changed", g.Files.Changed}, {"added", g.Files.Added}, {"removed", g.Files.Removed}} {
+			sorted := append([]string{}, group.paths...)
+			sort.Strings(sorted)
+			for _, path := range sorted {
+				statuses[path] = group.status
+				paths = append(paths, path)
+			}
+		}
+	}
+	var unchanged []string
+	for path := range byPath {
+		if _, ok := statuses[path]; !ok {
+			statuses[path] = "unchanged"
+			unchanged = append(unchanged, path)
+		}
+	}
+	sort.Strings(unchanged)
+	paths = append(paths, unchanged...)
+
+	for i, path := range paths {
+		file := &htmlFile{
+			Path:     path,
+			Status:   statuses[path],
+			Anchor:   fmt.Sprintf("%s-file-%d", anchor, i),
+			Findings: len(byPath[path]),
+		}
+		for _, f := range byPath[path] {
+			f.Href = "#" + file.Anchor
+		}
+		file.layout(g, byPath[path])
+		hg.Files = append(hg.Files, file)
+	}
+	return hg
+}
+
+// layout reads both versions of a file and fills in its diff rows
+func (file *htmlFile) layout(g *Gem, findings []*htmlFinding) {
+	var before, after []string
+	var note string
+	if file.Status != "added" {
+		before, note = readDisplayLines(g.BeforePath, file.Path)
+	}
+	if note == "" && file.Status != "removed" {
+		after, note = readDisplayLines(g.AfterPath, file.Path)
+	}
+	if note != "" {
+		file.Note = note
+		return
+	}
+	if file.Status == "unchanged" {
+		before = after
+	}
+
+	atLine := make(map[int][]*htmlFinding)
+	for _, f := range findings {
+		atLine[f.Line] = append(atLine[f.Line], f)
+	}
+
+	// Show every change and finding, with some unchanged lines around them
+	diff := utils.DiffLines(before, after)
+	shown := make([]bool, len(diff))
+	for k, line := range diff {
+		if line.Op != utils.LineEqual || len(atLine[line.New]) > 0 {
+			for c := max(k-diffContext, 0); c <= min(k+diffContext, len(diff)-1); c++ {
+				shown[c] = true
+			}
+		}
+	}
+
+	gap := 0
+	for k := 0; k < len(diff); {
+		if !shown[k] {
+			gap++
+			k++
+			continue
+		}
+		if gap > 0 {
+			file.Rows = append(file.Rows, &htmlRow{Gap: gap})
+			gap = 0
+		}
+
+		// Pair a run of deleted lines with the inserted lines that replace it
+		var deleted, inserted []utils.DiffLine
+		for k < len(diff) && diff[k].Op == utils.LineDeleted {
+			deleted = append(deleted, diff[k])
+			k++
+		}
+		for k < len(diff) && diff[k].Op == utils.LineInserted {
+			inserted = append(inserted, diff[k])
+			k++
+		}
+		if len(deleted) == 0 && len(inserted) == 0 {
+			line := diff[k]
+			file.addRow(htmlCell{Num: line.Old, Text: line.Text}, htmlCell{Num: line.New, Text: line.Text}, atLine)
+			k++
+			continue
+		}
+		for i := 0; i < max(len(deleted), len(inserted)); i++ {
+			var left, right htmlCell
+			if i < len(deleted) {
+				left = htmlCell{Num: deleted[i].Old, Text: deleted[i].Text, Class: "del"}
+			}
+			if i < len(inserted) {
+				right = htmlCell{Num: inserted[i].New, Text: inserted[i].Text, Class: "ins"}
+			}
+			file.addRow(left, right, atLine)
+		}
+	}
+	if gap > 0 {
+		file.Rows = append(file.Rows, &htmlRow{Gap: gap})
+	}
+
+	if len(file.Rows) > maxHTMLRows {
+		file.Truncated = len(file.Rows) - maxHTMLRows
+		file.Rows = file.Rows[:maxHTMLRows]
+	}
+}
+
+// addRow adds a diff row, annotated with the findings on its new line
+func (file *htmlFile) addRow(left, right htmlCell, atLine map[int][]*htmlFinding) {
+	row := &htmlRow{Old: left, New: right}
+	if right.Num > 0 {
+		row.Anchor = fmt.Sprintf("%s-L%d", file.Anchor, right.Num)
+		row.Findings = atLine[right.Num]
+		level := 0
+		for _, f := range row.Findings {
+			// Findings past the row cap keep linking to the file
+			if len(file.Rows) < maxHTMLRows {
+				f.Href = "#" + row.Anchor
+			}
+			if l := semgrep.SeverityLevel(f.Severity); l > level || row.Level == "" {
+				level = l
+				row.Level = f.Level
+			}
+		}
+	}
+	file.Rows = append(file.Rows, row)
+}
+
+// readDisplayLines reads a file of an extracted gem as lines of text, or
+// returns a note saying why it can't be shown
+func readDisplayLines(dir, path string) ([]string, string) {
+	full := filepath.Join(dir, path)
+	info, err := os.Stat(full)
+	if err != nil {
+		return nil, fmt.Sprintf("The file is no longer available at %s.", full)
+	}
+	if info.Size() > maxHTMLFileBytes {
+		return nil, fmt.Sprintf("The file is too large to show (%s).", formatSize(info.Size()))
+	}
+	data, err := os.ReadFile(full)
+	if err != nil {
+		return nil, fmt.Sprintf("The file could not be read: %v.", err)
+	}
+	if bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		return nil, fmt.Sprintf("Binary file (%s).", formatSize(info.Size()))
+	}
+
+	text := strings.ToValidUTF8(string(data), "�")
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil, ""
+	}
+	return strings.Split(text, "\n"), ""
+}
+
+// formatSize formats a size in bytes, e.g. "12.5 KB"
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
@@ -0,0 +1,219 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"whiskers/policy"
+)
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite holds the test cases of one gem
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr,omitempty"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+// junitProperty is a name/value pair describing a test suite
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// junitTestCase is a finding, a scan error, a passing scan of a gem, or a
+// policy decision
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitProblem `xml:"skipped,omitempty"`
+}
+
+// junitProblem is the failure or error of a test case
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",cdata"`
+}
+
+// JUnit writes a report as JUnit XML, so CI systems show findings as test
+// failures. Each gem is a test suite in which every new finding is a failed
+// test case and every scan error an errored one. A gem with neither has a
+// single passing test case. A policy's decisions are a test suite of their
+// own, in which denied gems fail and gems needing review are skipped.
+func JUnit(w io.Writer, r *Report) error {
+	suites := junitTestSuites{
+		Name: r.Tool.Name,
+		Time: junitSeconds(r.DurationMS),
+	}
+
+	for _, g := range r.Gems {
+		suite := junitTestSuite{
+			Name: fmt.Sprintf("%s %s → %s", g.Name, g.Before.Version, g.After.Version),
+			Time: junitSeconds(g.Timings.PrepareMS + g.Timings.ScanMS + g.Timings.AnalyzeMS),
+			Properties: []junitProperty{
+				{Name: "gem", Value: g.Name},
+				{Name: "version", Value: g.After.Version},
+				{Name: "previous_version", Value: g.Before.Version},
+				{Name: "bump", Value: string(g.Bump)},
+			},
+		}
+		if g.Risk != nil {
+			suite.Properties = append(suite.Properties, junitProperty{Name: "risk_score", Value: fmt.Sprint(g.Risk.Score)})
+		}
+		if !r.StartedAt.IsZero() {
+			suite.Timestamp = r.StartedAt.Format("2006-01-02T15:04:05")
+		}
+
+		addError := func(name, file, message, text string) {
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      name,
+				Classname: g.Name,
+				File:      file,
+				Error:     &junitProblem{Message: message, Type: "scan-error", Text: xmlText(text)},
+			})
+			suite.Errors++
+		}
+		if g.Error != "" {
+			addError("scan", "", "could not scan the gem", g.Error)
+		}
+		for _, e := range g.RuleErrors {
+			addError("rule error", e.Path, "a rule could not run", e.Display())
+		}
+		for _, e := range g.Unanalyzed {
+			addError("analyze "+e.Path, e.Path, "the file could not be analyzed", e.Display())
+		}
+
+		for _, f := range g.Findings {
+			location := f.Path
+			if f.Line > 0 {
+				location = fmt.Sprintf("%s:%d", f.Path, f.Line)
+			}
+			var text strings.Builder
+			fmt.Fprintf(&text, "%s at %s", strings.ToUpper(f.Severity), location)
+			if f.Metadata.Confidence != "" {
+				fmt.Fprintf(&text, " (%s confidence)", strings.ToLower(f.Metadata.Confidence))
+			}
+			if f.LoadTime {
+				text.WriteString(" (runs on require)")
+			}
+			text.WriteString("\n")
+			if f.Lines != "" {
+				fmt.Fprintf(&text, "\n%s\n", f.Lines)
+			}
+
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      fmt.Sprintf("%s %s", f.RuleID, location),
+				Classname: g.Name,
+				File:      f.Path,
+				Line:      f.Line,
+				Failure:   &junitProblem{Message: f.Message, Type: f.RuleID, Text: xmlText(text.String())},
+			})
+			suite.Failures++
+		}
+
+		if len(suite.Cases) == 0 {
+			suite.Cases = append(suite.Cases, junitTestCase{Name: "no new findings", Classname: g.Name})
+		}
+		suite.Tests = len(suite.Cases)
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	if r.Policy != nil {
+		suite := policySuite(r.Policy)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write JUnit XML: %w", err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return fmt.Errorf("failed to write JUnit XML: %w", err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("failed to write JUnit XML: %w", err)
+	}
+	return nil
+}
+
+// policySuite is a test suite with a test case per gem the policy decided on
+func policySuite(result *policy.Result) junitTestSuite {
+	suite := junitTestSuite{
+		Name:       "policy",
+		Time:       junitSeconds(0),
+		Properties: []junitProperty{{Name: "decision", Value: string(result.Decision)}},
+	}
+	if result.Policy != "" {
+		suite.Properties = append(suite.Properties, junitProperty{Name: "policy", Value: result.Policy})
+	}
+
+	for _, g := range result.Gems {
+		c := junitTestCase{Name: g.Name + " " + g.Version, Classname: "policy"}
+		var text strings.Builder
+		for _, v := range g.Violations {
+			fmt.Fprintf(&text, "%s: %s [%s]\n", v.Action, v.Message, v.Rule)
+		}
+		switch g.Decision {
+		case policy.Deny:
+			c.Failure = &junitProblem{Message: "denied by the policy", Type: "policy-deny", Text: xmlText(text.String())}
+			suite.Failures++
+		case policy.Warn:
+			c.Skipped = &junitProblem{Message: "needs review under the policy", Type: "policy-warn", Text: xmlText(text.String())}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	if len(suite.Cases) == 0 {
+		suite.Cases = append(suite.Cases, junitTestCase{Name: "no added or updated gems", Classname: "policy"})
+	}
+	suite.Tests = len(suite.Cases)
+	return suite
+}
+
+// junitSeconds formats a duration in milliseconds as JUnit's seconds
+func junitSeconds(ms int64) string {
+	return fmt.Sprintf("%.3f", float64(ms)/1000)
+}
+
+// xmlText replaces the characters XML documents can't contain, such as
+// control characters in a snippet, which CDATA sections don't escape
+func xmlText(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return r
+		case r < 0x20, r == 0xFFFE, r == 0xFFFF, r >= 0xD800 && r <= 0xDFFF:
+			return '\uFFFD'
+		}
+		return r
+	}, strings.ToValidUTF8(text, "\uFFFD"))
+}
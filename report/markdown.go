@@ -0,0 +1,485 @@
+package report
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+	"whiskers/policy"
+	"whiskers/risk"
+	"whiskers/semgrep"
+	"whiskers/utils"
+)
+
+// MaxCommentLength is GitHub's limit on the length of a pull request comment
+const MaxCommentLength = 65536
+
+// maxLocationsPerRule caps how many findings of one rule a gem section lists
+const maxLocationsPerRule = 10
+
+// Matches a GitHub repository URL, e.g. https://github.com/rails/rails/tree/main
+var githubRepoRegex = regexp.MustCompile(`^https?://github\.com/([\w.-]+)/([\w.-]+?)(?:\.git)?(?:[/?#].*)?$`)
+
+// MarkdownOptions configures the markdown renderer
+type MarkdownOptions struct {
+	// MaxLength is the longest the output may be. The default is MaxCommentLength.
+	MaxLength int
+}
+
+// Markdown renders a report as a pull request comment: a verdict banner, a
+// table of version changes with their risk factors, the policy's decisions,
+// typosquat warnings and a collapsible section per gem with its findings
+// grouped by rule. Gem sections that don't fit within the length limit are
+// left out, most severe gems first.
+func Markdown(r *Report, opts MarkdownOptions) string {
+	if opts.MaxLength <= 0 {
+		opts.MaxLength = MaxCommentLength
+	}
+
+	var head strings.Builder
+	writeBanner(&head, r)
+	writeChangeTable(&head, r)
+	writeRiskFactors(&head, r)
+	writePolicy(&head, r)
+	writeLockfileChanges(&head, r)
+	writeTyposquats(&head, r)
+
+	footer := markdownFooter(r)
+
+	// Leave room for the footer and a note about what was left out
+	budget := opts.MaxLength - len(footer) - 200
+	var out strings.Builder
+	if head.Len() > budget {
+		out.WriteString(truncate(head.String(), budget))
+		out.WriteString("\n\n_The summary was truncated to fit in a comment._\n")
+		out.WriteString(footer)
+		return out.String()
+	}
+	out.WriteString(head.String())
+
+	// Gems whose findings don't fit are listed by rule only, then left out
+	omitted := 0
+	for _, g := range sortedForReview(r.Gems) {
+		section := gemSection(g, false)
+		if section == "" {
+			continue
+		}
+		if out.Len()+len(section) > budget {
+			section = gemSection(g, true)
+		}
+		if out.Len()+len(section) > budget {
+			omitted++
+			continue
+		}
+		out.WriteString(section)
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&out, "\n_%s with findings or errors left out to fit in a comment; see the full report._\n", utils.Plural(omitted, "more gem"))
+	}
+	out.WriteString(footer)
+	return out.String()
+}
+
+// writeBanner writes a GitHub alert summarizing the verdict
+func writeBanner(out *strings.Builder, r *Report) {
+	findings, gemsWithFindings, incomplete := 0, 0, 0
+	for _, g := range r.Gems {
+		findings += len(g.Findings)
+		if len(g.Findings) > 0 {
+			gemsWithFindings++
+		}
+		if g.Incomplete() {
+			incomplete++
+		}
+	}
+
+	switch r.Verdict {
+	case VerdictFindings:
+		fmt.Fprintf(out, "> [!CAUTION]\n> **whiskers found %s in %s** out of %s scanned.\n",
+			utils.Plural(findings, "new issue"), utils.Plural(gemsWithFindings, "gem"), utils.Plural(len(r.Gems), "gem"))
+	case VerdictIncomplete:
+		fmt.Fprintf(out, "> [!WARNING]\n> **whiskers found no new issues, but could not fully scan %s** out of %s.\n",
+			utils.Plural(incomplete, "gem"), utils.Plural(len(r.Gems), "gem"))
+	default:
+		fmt.Fprintf(out, "> [!NOTE]\n> **whiskers found no new issues** in %s.\n", utils.Plural(len(r.Gems), "gem"))
+	}
+	if incomplete > 0 && r.Verdict == VerdictFindings {
+		fmt.Fprintf(out, "> %s could not be fully scanned.\n", utils.Plural(incomplete, "gem"))
+	}
+	if len(r.Typosquats) > 0 {
+		fmt.Fprintf(out, "> %s may be typosquatting popular gems.\n", utils.Plural(len(r.Typosquats), "added gem"))
+	}
+}
+
+// writeChangeTable writes a table of every scanned version change
+func writeChangeTable(out *strings.Builder, r *Report) {
+	if len(r.Gems) == 0 {
+		return
+	}
+	scored := r.Scored()
+	if scored {
+		out.WriteString("\n| Gem | Version | Bump | Risk | New issues | Status |\n|---|---|---|---|---|---|\n")
+	} else {
+		out.WriteString("\n| Gem | Version | Bump | New issues | Status |\n|---|---|---|---|---|\n")
+	}
+	for _, g := range r.Gems {
+		fmt.Fprintf(out, "| %s | %s → %s | %s |", tableCell(g.Name), tableCell(g.Before.Version), tableCell(g.After.Version), g.Bump)
+		if scored {
+			fmt.Fprintf(out, " %g |", g.RiskScore())
+		}
+		fmt.Fprintf(out, " %s | %s |\n", severityCounts(g.Findings), gemStatus(g))
+	}
+}
+
+// writeRiskFactors explains the risk scores in a collapsed section
+func writeRiskFactors(out *strings.Builder, r *Report) {
+	var risky []*Gem
+	for _, g := range r.Gems {
+		if g.RiskScore() > 0 {
+			risky = append(risky, g)
+		}
+	}
+	added := r.RiskyAddedGems()
+	if len(risky)+len(added) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\n<details><summary>Risk factors of %s</summary>\n\n", utils.Plural(len(risky)+len(added), "gem"))
+	for _, g := range risky {
+		writeRiskScore(out, htmlEscape(g.Name), g.Risk)
+	}
+	for _, name := range added {
+		writeRiskScore(out, htmlEscape(name)+" (added)", r.AddedRisk[name])
+	}
+	out.WriteString("\n</details>\n")
+}
+
+// writeRiskScore writes a list item of a score and its factors
+func writeRiskScore(out *strings.Builder, label string, score *risk.Score) {
+	fmt.Fprintf(out, "- **%s** %g:", label, score.Score)
+	for i, factor := range score.Factors {
+		if i > 0 {
+			out.WriteString(" ·")
+		}
+		fmt.Fprintf(out, " %s +%g (%s)", factor.Name, factor.Points, htmlEscape(factor.Detail))
+	}
+	out.WriteString("\n")
+}
+
+// writePolicy lists the gems the policy warned about or denied
+func writePolicy(out *strings.Builder, r *Report) {
+	if r.Policy == nil {
+		return
+	}
+	fmt.Fprintf(out, "\n### Policy: %s %s\n\n", decisionIcon(r.Policy.Decision), r.Policy.Decision)
+
+	flagged := 0
+	for _, g := range r.Policy.Gems {
+		if g.Decision == policy.Allow {
+			continue
+		}
+		if flagged == 0 {
+			out.WriteString("| Gem | Version | Decision | Reasons |\n|---|---|---|---|\n")
+		}
+		flagged++
+		version := g.Version
+		if g.PreviousVersion != "" {
+			version = g.PreviousVersion + " → " + g.Version
+		}
+		reasons := make([]string, 0, len(g.Violations))
+		for _, v := range g.Violations {
+			reasons = append(reasons, fmt.Sprintf("%s (`%s`)", markdownText(v.Message), tableCell(v.Rule)))
+		}
+		fmt.Fprintf(out, "| %s | %s | %s %s | %s |\n", tableCell(g.Name), tableCell(version),
+			decisionIcon(g.Decision), g.Decision, strings.Join(reasons, "<br>"))
+	}
+	if flagged == 0 {
+		fmt.Fprintf(out, "The policy allows all %s.\n", utils.Plural(len(r.Policy.Gems), "changed gem"))
+	}
+}
+
+// writeLockfileChanges lists the added and removed gems in a collapsed section
+func writeLockfileChanges(out *strings.Builder, r *Report) {
+	if r.Diff == nil || len(r.Diff.Added)+len(r.Diff.Removed) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\n<details><summary>%s added, %s removed</summary>\n\n",
+		utils.Plural(len(r.Diff.Added), "gem"), utils.Plural(len(r.Diff.Removed), "gem"))
+	for _, g := range r.Diff.Added {
+		fmt.Fprintf(out, "- ➕ `%s` %s", g.Name, g.Version)
+		if g.Source.URL != "" && g.Source.URL != "https://rubygems.org/" {
+			fmt.Fprintf(out, " from %s", g.Source.URL)
+		}
+		out.WriteString("\n")
+	}
+	for _, g := range r.Diff.Removed {
+		fmt.Fprintf(out, "- ➖ `%s` %s\n", g.Name, g.Version)
+	}
+	out.WriteString("\n</details>\n")
+}
+
+// writeTyposquats lists added gems whose names imitate popular gems
+func writeTyposquats(out *strings.Builder, r *Report) {
+	if len(r.Typosquats) == 0 {
+		return
+	}
+	out.WriteString("\n### Possible typosquats\n\n")
+	names := make([]string, 0, len(r.Typosquats))
+	for name := range r.Typosquats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		similar := make([]string, 0, len(r.Typosquats[name]))
+		for _, popular := range r.Typosquats[name] {
+			similar = append(similar, "`"+popular+"`")
+		}
+		fmt.Fprintf(out, "- `%s` looks like %s\n", name, strings.Join(similar, ", "))
+	}
+}
+
+// gemSection renders a collapsible section of a gem's findings and errors,
+// or nothing for a clean gem. A compact section only counts the findings of
+// each rule.
+func gemSection(g *Gem, compact bool) string {
+	if len(g.Findings) == 0 && !g.Incomplete() {
+		return ""
+	}
+
+	var out strings.Builder
+	summary := severityCounts(g.Findings)
+	if g.Error != "" {
+		summary = "not scanned"
+	}
+	fmt.Fprintf(&out, "\n<details><summary><b>%s</b> %s → %s (%s)</summary>\n\n",
+		htmlEscape(g.Name), htmlEscape(g.Before.Version), htmlEscape(g.After.Version), summary)
+
+	if g.Error != "" {
+		fmt.Fprintf(&out, "⚠️ Could not scan this gem: %s\n\n", codeSpan(g.Error))
+	}
+	for _, e := range g.RuleErrors {
+		fmt.Fprintf(&out, "⚠️ Rule error: %s\n\n", codeSpan(e.Display()))
+	}
+	if len(g.Unanalyzed) > 0 {
+		fmt.Fprintf(&out, "⚠️ %s could not be analyzed:\n", utils.Plural(len(g.Unanalyzed), "file"))
+		for _, e := range g.Unanalyzed {
+			fmt.Fprintf(&out, "- %s: %s\n", codeSpan(e.Path), codeSpan(e.Display()))
+		}
+		out.WriteString("\n")
+	}
+
+	// Group the findings by rule, in the order they were ranked
+	var ruleIDs []string
+	byRule := make(map[string][]*semgrep.Finding)
+	for _, f := range g.Findings {
+		if byRule[f.RuleID] == nil {
+			ruleIDs = append(ruleIDs, f.RuleID)
+		}
+		byRule[f.RuleID] = append(byRule[f.RuleID], f)
+	}
+	for _, id := range ruleIDs {
+		findings := byRule[id]
+		first := findings[0]
+		if compact {
+			fmt.Fprintf(&out, "- %s %s × %d\n", severityIcon(first.Severity), codeSpan(id), len(findings))
+			continue
+		}
+		// Messages and paths come from the gem, e.g. a decoded payload
+		fmt.Fprintf(&out, "**%s** %s: %s\n", severityIcon(first.Severity), codeSpan(id), markdownText(first.Message))
+		for i, f := range findings {
+			if i == maxLocationsPerRule {
+				fmt.Fprintf(&out, "- …and %d more\n", len(findings)-maxLocationsPerRule)
+				break
+			}
+			location := fmt.Sprintf("%s:%d", f.Path, f.Line)
+			if link := sourceLink(g, f); link != "" {
+				location = fmt.Sprintf("[%s](%s)", markdownText(location), link)
+			} else {
+				location = codeSpan(location)
+			}
+			if f.LoadTime {
+				location += " (runs on require)"
+			}
+			fmt.Fprintf(&out, "- %s %s\n", location, codeSpan(truncate(f.Lines, 200)))
+		}
+		out.WriteString("\n")
+	}
+	if compact && len(ruleIDs) > 0 {
+		out.WriteString("\n_Locations left out to fit in a comment._\n\n")
+	}
+	out.WriteString("</details>\n")
+	return out.String()
+}
+
+// markdownFooter names the tool, scanners and rule packs behind the report
+func markdownFooter(r *Report) string {
+	packs := make([]string, 0, len(r.Rules.Packs))
+	for _, p := range r.Rules.Packs {
+		packs = append(packs, p.Name)
+	}
+	footer := fmt.Sprintf("\n<sub>%s %s", r.Tool.Name, r.Tool.Version)
+	if r.Tool.Scanners != "" {
+		footer += " · scanners: " + r.Tool.Scanners
+	}
+	if len(packs) > 0 {
+		footer += " · rules: " + strings.Join(packs, ", ")
+	}
+	return footer + "</sub>\n"
+}
+
+// sortedForReview orders gems by their most severe finding, then by number
+// of findings, so the gems that most need review are kept when truncating
+func sortedForReview(gems []*Gem) []*Gem {
+	sorted := append([]*Gem{}, gems...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := maxSeverity(sorted[i].Findings), maxSeverity(sorted[j].Findings)
+		if a != b {
+			return a > b
+		}
+		return len(sorted[i].Findings) > len(sorted[j].Findings)
+	})
+	return sorted
+}
+
+// maxSeverity returns the level of the most severe finding
+func maxSeverity(findings []*semgrep.Finding) int {
+	level := 0
+	for _, f := range findings {
+		level = max(level, semgrep.SeverityLevel(f.Severity))
+	}
+	return level
+}
+
+// sourceLink links a finding to its line in the gem's GitHub repository, at
+// the conventional v<version> tag, when the gemspec names one
+func sourceLink(g *Gem, f *semgrep.Finding) string {
+	match := githubRepoRegex.FindStringSubmatch(g.SourceCodeURI)
+	if match == nil || f.Path == "" {
+		return ""
+	}
+	link := fmt.Sprintf("https://github.com/%s/%s/blob/v%s/%s", match[1], match[2],
+		url.PathEscape(g.After.Version), (&url.URL{Path: f.Path}).EscapedPath())
+	// A ) would end the link's destination
+	link = strings.NewReplacer("(", "%28", ")", "%29").Replace(link)
+	if f.Line > 0 {
+		link += fmt.Sprintf("#L%d", f.Line)
+	}
+	return link
+}
+
+// severityCounts summarizes findings by severity, e.g. "🔴 2 · 🟡 1"
+func severityCounts(findings []*semgrep.Finding) string {
+	if len(findings) == 0 {
+		return "none"
+	}
+	counts := make(map[string]int)
+	for _, f := range findings {
+		counts[normalizeSeverity(f.Severity)]++
+	}
+	var parts []string
+	for _, severity := range []string{"ERROR", "WARNING", "INFO", ""} {
+		if counts[severity] > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", severityIcon(severity), counts[severity]))
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+// severityIcon returns a colored marker for a severity
+func severityIcon(severity string) string {
+	switch normalizeSeverity(severity) {
+	case "ERROR":
+		return "🔴"
+	case "WARNING":
+		return "🟡"
+	case "INFO":
+		return "🔵"
+	}
+	return "⚪"
+}
+
+// decisionIcon returns a marker for a policy decision
+func decisionIcon(decision policy.Decision) string {
+	switch decision {
+	case policy.Deny:
+		return "⛔"
+	case policy.Warn:
+		return "⚠️"
+	}
+	return "✅"
+}
+
+// gemStatus describes whether a gem was fully scanned
+func gemStatus(g *Gem) string {
+	switch {
+	case g.Error != "":
+		return "⚠️ not scanned"
+	case g.Incomplete():
+		return "⚠️ incomplete"
+	case len(g.Findings) > 0:
+		return "review"
+	}
+	return "✅ clean"
+}
+
+// codeSpan formats text as inline code, using a longer fence if it contains backticks
+func codeSpan(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return ""
+	}
+	fence := "`"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	if fence != "`" || strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		return fence + " " + text + " " + fence
+	}
+	return fence + text + fence
+}
+
+// markdownText escapes text for inline markdown, flattening it to one line,
+// so untrusted text can't open HTML tags, links, emphasis or new blocks.
+// Pipes are escaped too, so it is safe in table cells.
+func markdownText(text string) string {
+	var out strings.Builder
+	for _, r := range strings.Join(strings.Fields(text), " ") {
+		switch {
+		case r == '&':
+			out.WriteString("&amp;")
+		case r == '<':
+			out.WriteString("&lt;")
+		case r == '>':
+			out.WriteString("&gt;")
+		case strings.ContainsRune("\\`*_[]|~", r):
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		default:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// tableCell escapes text for a markdown table cell
+func tableCell(text string) string {
+	return strings.ReplaceAll(text, "|", `\|`)
+}
+
+// htmlEscape escapes text for use inside HTML tags
+func htmlEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(text)
+}
+
+// truncate shortens text to at most n bytes, on a rune boundary, marking the cut
+func truncate(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	cut := max(n-len("…"), 0)
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "…"
+}
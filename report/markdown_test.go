@@ -0,0 +1,77 @@
+package report
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"whiskers/gem"
+	"whiskers/risk"
+	"whiskers/semgrep"
+)
+
+func TestMarkdownText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Hardcoded URL detected - potential risk", "Hardcoded URL detected - potential risk"},
+		{"</details>\n# No issues", "&lt;/details&gt; # No issues"},
+		{"[click](https://evil.example)", `\[click\](https://evil.example)`},
+		{"*bold* _em_ ~~gone~~ `code`", `\*bold\* \_em\_ \~\~gone\~\~ \` + "`code\\`"},
+		{"a | b & c", `a \| b &amp; c`},
+		{`back\slash`, `back\\slash`},
+	}
+	for _, tt := range tests {
+		if got := markdownText(tt.text); got != tt.want {
+			t.Errorf("markdownText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// A gem controls its findings' messages and paths, and must not be able to
+// change the structure of the comment
+func TestMarkdownHostileFinding(t *testing.T) {
+	message := "Encoded string decodes to code: </details>\n\n# ✅ No issues found\n<img src=x onerror=alert(1)> [ok](https://evil.example)"
+	path := "lib/x](https://evil.example)</details>\n# a.rb"
+	r := &Report{
+		Tool: Tool{Name: "whiskers"},
+		Gems: []*Gem{{
+			Name:          "evil",
+			Before:        gem.GemJSON{Version: "1.0.0"},
+			After:         gem.GemJSON{Version: "1.0.1"},
+			SourceCodeURI: "https://github.com/evil/evil",
+			Findings: []*semgrep.Finding{
+				{RuleID: "whiskers-encoded-code", Message: message, Path: path, Line: 3, Severity: "ERROR", Lines: "eval(x)"},
+			},
+		}},
+	}
+	out := Markdown(r, MarkdownOptions{})
+
+	if n := strings.Count(out, "</details>"); n != 1 {
+		t.Errorf("got %d </details> tags, want only the gem section's:\n%s", n, out)
+	}
+	for _, injected := range []string{"<img", "\n# ", "[ok]("} {
+		if strings.Contains(out, injected) {
+			t.Errorf("output contains %q:\n%s", injected, out)
+		}
+	}
+	if unescapedLink := regexp.MustCompile(`[^\\]\]\(https://evil`); unescapedLink.MatchString(out) {
+		t.Errorf("output links to the gem's URL:\n%s", out)
+	}
+	if !strings.Contains(out, "https://github.com/evil/evil/blob/v1.0.1/lib/x%5D%28https:") {
+		t.Errorf("source link isn't escaped:\n%s", out)
+	}
+}
+
+func TestMarkdownAddedGemRisk(t *testing.T) {
+	r := &Report{
+		Tool:       Tool{Name: "whiskers"},
+		Diff:       &gem.DiffJSON{Added: []gem.GemJSON{{Name: "rials", Version: "1.0.0"}}},
+		Typosquats: map[string][]string{"rials": {"rails"}},
+	}
+	r.ScoreRisk(risk.DefaultWeights())
+	out := Markdown(r, MarkdownOptions{})
+	if !strings.Contains(out, "- **rials (added)** 15: typosquat +15 (name looks like rails)") {
+		t.Errorf("risk factors of the added gem are missing:\n%s", out)
+	}
+}
@@ -0,0 +1,353 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"whiskers/baseline"
+	"whiskers/gem"
+	"whiskers/policy"
+	"whiskers/risk"
+	"whiskers/rules"
+	"whiskers/scan"
+	"whiskers/semgrep"
+	"whiskers/utils"
+)
+
+// SchemaVersion is the version of the JSON report format. It is bumped when
+// a field is removed or changes meaning; new fields may be added at any time.
+const SchemaVersion = 1
+
+// Verdict summarizes the outcome of a scan
+type Verdict string
+
+// Verdicts, from best to worst
+const (
+	// VerdictClean means every gem was scanned and nothing new was found
+	VerdictClean Verdict = "clean"
+	// VerdictIncomplete means nothing new was found, but some gems or files
+	// could not be scanned
+	VerdictIncomplete Verdict = "incomplete"
+	// VerdictFindings means at least one new issue was found
+	VerdictFindings Verdict = "findings"
+)
+
+// Tool identifies the whiskers build and analysis backends behind a report
+type Tool struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Scanners are the analysis backends, e.g. "semgrep+heuristics"
+	Scanners string `json:"scanners"`
+}
+
+// Rules records exactly which rules a scan ran
+type Rules struct {
+	Packs []rules.PackInfo `json:"packs"`
+	Rules []rules.Rule     `json:"rules"`
+}
+
+// Report is the machine-readable record of a scan
+type Report struct {
+	SchemaVersion int `json:"schema_version"`
+	// Command is the scan command that produced the report, e.g. "gemfile-diff-scan"
+	Command    string    `json:"command"`
+	Tool       Tool      `json:"tool"`
+	Rules      Rules     `json:"rules"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	// GemsDir is the directory the gems were extracted to
+	GemsDir string `json:"gems_dir"`
+	// Diff is the lockfile diff the gems came from, if the scan started from one
+	Diff *gem.DiffJSON `json:"lockfile_diff,omitempty"`
+	// Typosquats maps added gems to the popular gems their names imitate
+	Typosquats map[string][]string `json:"typosquats,omitempty"`
+	// AddedRisk are the risk scores of the added gems by name, see ScoreRisk
+	AddedRisk map[string]*risk.Score `json:"added_risk,omitempty"`
+	Gems      []*Gem                 `json:"gems"`
+	Verdict   Verdict                `json:"verdict"`
+	// Policy is the decision of the policy the scan was checked against, if any
+	Policy *policy.Result `json:"policy,omitempty"`
+}
+
+// Gem is the outcome of scanning one gem version change
+type Gem struct {
+	Name   string      `json:"name"`
+	Before gem.GemJSON `json:"before"`
+	After  gem.GemJSON `json:"after"`
+	Bump   gem.Bump    `json:"bump"`
+	// SourceCodeURI is the after version's source repository, from its gemspec
+	SourceCodeURI string `json:"source_code_uri,omitempty"`
+	// BeforePath and AfterPath are the extracted versions
+	BeforePath string `json:"before_path,omitempty"`
+	AfterPath  string `json:"after_path,omitempty"`
+	// Files lists the added, removed and changed files, relative to the gem
+	Files         *utils.FileDiff `json:"files,omitempty"`
+	LoadTimeFiles []string        `json:"load_time_files,omitempty"`
+	// MetadataChanges are the gemspec fields that differ between the versions
+	MetadataChanges []gem.SpecChange `json:"metadata_changes,omitempty"`
+	// Risk is the change's risk score, see Report.ScoreRisk
+	Risk *risk.Score `json:"risk,omitempty"`
+	// Findings are the new issues, with paths relative to the gem
+	Findings            []*semgrep.Finding     `json:"findings"`
+	Suppressed          []*semgrep.Finding     `json:"suppressed,omitempty"`
+	ExpiredSuppressions []baseline.Suppression `json:"expired_suppressions,omitempty"`
+	Unanalyzed          []semgrep.ScanError    `json:"unanalyzed,omitempty"`
+	RuleErrors          []semgrep.ScanError    `json:"rule_errors,omitempty"`
+	// Error is why the gem could not be scanned
+	Error   string  `json:"error,omitempty"`
+	Timings Timings `json:"timings"`
+	Verdict Verdict `json:"verdict"`
+}
+
+// Timings are the durations of each stage of scanning a gem, in milliseconds
+type Timings struct {
+	PrepareMS int64 `json:"prepare_ms"`
+	// ScanMS is zero for batched scans, which scan every gem at once
+	ScanMS    int64 `json:"scan_ms"`
+	AnalyzeMS int64 `json:"analyze_ms"`
+}
+
+// New builds a report of scan results. diff is the lockfile diff the changes
+// came from, or nil if they didn't come from one.
+func New(command string, results []*scan.Result, diff *gem.GemfileDiff, set *rules.Set, tool Tool, gemsDir string, started time.Time) *Report {
+	// Reports are read from other directories, and SARIF needs an absolute base URI
+	if abs, err := filepath.Abs(gemsDir); err == nil && gemsDir != "" {
+		gemsDir = abs
+	}
+	r := &Report{
+		SchemaVersion: SchemaVersion,
+		Command:       command,
+		Tool:          tool,
+		StartedAt:     started.UTC(),
+		DurationMS:    time.Since(started).Milliseconds(),
+		GemsDir:       gemsDir,
+		Gems:          make([]*Gem, 0, len(results)),
+	}
+	if r.Tool.Name == "" {
+		r.Tool.Name = "whiskers"
+	}
+	if set != nil {
+		r.Rules = Rules{Packs: set.Packs, Rules: set.Rules}
+	}
+	if diff != nil {
+		r.Diff = diff.ToJSON()
+		for _, added := range diff.Added {
+			if matches := utils.CheckForTyposquats(added.Name); len(matches) > 0 {
+				if r.Typosquats == nil {
+					r.Typosquats = make(map[string][]string)
+				}
+				r.Typosquats[added.Name] = matches
+			}
+		}
+	}
+
+	for _, result := range results {
+		r.Gems = append(r.Gems, newGem(result, gemsDir))
+	}
+	r.Verdict = r.verdict()
+	return r
+}
+
+// newGem records the result of scanning a change
+func newGem(result *scan.Result, gemsDir string) *Gem {
+	g := &Gem{
+		Name:                result.Change.Name,
+		Before:              gemJSON(result.Change.Before),
+		After:               gemJSON(result.Change.After),
+		Bump:                result.Change.Bump(),
+		BeforePath:          result.BeforePath,
+		AfterPath:           result.AfterPath,
+		Files:               result.Diff,
+		LoadTimeFiles:       result.LoadTimeFiles,
+		Findings:            result.Findings,
+		Suppressed:          result.Suppressed,
+		ExpiredSuppressions: result.ExpiredSuppressions,
+		Unanalyzed:          result.Unanalyzed,
+		RuleErrors:          result.RuleErrors,
+		Timings: Timings{
+			PrepareMS: result.Timings.Prepare.Milliseconds(),
+			ScanMS:    result.Timings.Scan.Milliseconds(),
+			AnalyzeMS: result.Timings.Analyze.Milliseconds(),
+		},
+	}
+	if g.Findings == nil {
+		g.Findings = []*semgrep.Finding{}
+	}
+	if result.Err != nil {
+		g.Error = result.Err.Error()
+	}
+	if spec, err := result.Change.After.LoadSpec(gemsDir); err == nil {
+		g.SourceCodeURI = spec.Metadata["source_code_uri"]
+		if g.SourceCodeURI == "" {
+			g.SourceCodeURI = spec.Homepage
+		}
+		if before, err := result.Change.Before.LoadSpec(gemsDir); err == nil {
+			g.MetadataChanges = gem.CompareSpecs(before, spec)
+		}
+	}
+
+	switch {
+	case len(g.Findings) > 0:
+		g.Verdict = VerdictFindings
+	case g.Incomplete():
+		g.Verdict = VerdictIncomplete
+	default:
+		g.Verdict = VerdictClean
+	}
+	return g
+}
+
+// gemJSON converts a gem to its JSON form
+func gemJSON(g *gem.Gem) gem.GemJSON {
+	return gem.GemJSON{Name: g.Name, Version: g.Version, Source: g.Source}
+}
+
+// Incomplete returns true if the gem, or some of its files or rules, could not be scanned
+func (g *Gem) Incomplete() bool {
+	return g.Error != "" || len(g.Unanalyzed) > 0 || len(g.RuleErrors) > 0
+}
+
+// Change returns the version change the gem's result is for
+func (g *Gem) Change() gem.VersionChange {
+	return gem.VersionChange{
+		Name:   g.Name,
+		Before: gem.NewGem(g.Before.Name, g.Before.Version, g.Before.Source),
+		After:  gem.NewGem(g.After.Name, g.After.Version, g.After.Source),
+	}
+}
+
+// Result converts the gem back to the scan result it was built from,
+// without its progress log
+func (g *Gem) Result() *scan.Result {
+	result := &scan.Result{
+		Change:              g.Change(),
+		BeforePath:          g.BeforePath,
+		AfterPath:           g.AfterPath,
+		Diff:                g.Files,
+		LoadTimeFiles:       g.LoadTimeFiles,
+		Findings:            g.Findings,
+		Suppressed:          g.Suppressed,
+		ExpiredSuppressions: g.ExpiredSuppressions,
+		Unanalyzed:          g.Unanalyzed,
+		RuleErrors:          g.RuleErrors,
+		Timings: scan.Timings{
+			Prepare: time.Duration(g.Timings.PrepareMS) * time.Millisecond,
+			Scan:    time.Duration(g.Timings.ScanMS) * time.Millisecond,
+			Analyze: time.Duration(g.Timings.AnalyzeMS) * time.Millisecond,
+		},
+	}
+	if g.Error != "" {
+		result.Err = errors.New(g.Error)
+	}
+	if result.Diff == nil && result.Err == nil {
+		result.Diff = &utils.FileDiff{}
+	}
+	return result
+}
+
+// Results converts every gem back to its scan result
+func (r *Report) Results() []*scan.Result {
+	results := make([]*scan.Result, 0, len(r.Gems))
+	for _, g := range r.Gems {
+		results = append(results, g.Result())
+	}
+	return results
+}
+
+// verdict is the worst verdict of any gem
+func (r *Report) verdict() Verdict {
+	verdict := VerdictClean
+	for _, g := range r.Gems {
+		switch g.Verdict {
+		case VerdictFindings:
+			return VerdictFindings
+		case VerdictIncomplete:
+			verdict = VerdictIncomplete
+		}
+	}
+	return verdict
+}
+
+// Findings returns the number of new findings across every gem
+func (r *Report) Findings() int {
+	count := 0
+	for _, g := range r.Gems {
+		count += len(g.Findings)
+	}
+	return count
+}
+
+// IncompleteGems returns the number of gems that could not be fully scanned
+func (r *Report) IncompleteGems() int {
+	count := 0
+	for _, g := range r.Gems {
+		if g.Incomplete() {
+			count++
+		}
+	}
+	return count
+}
+
+// ApplyPolicy decides on the added gems and scanned version changes with a
+// policy, and records the decisions in the report. Added gems aren't
+// downloaded, so only the checks of their source and name apply to them.
+func (r *Report) ApplyPolicy(p *policy.Policy) {
+	var changes []policy.Change
+	if r.Diff != nil {
+		for _, added := range r.Diff.Added {
+			changes = append(changes, policy.Change{
+				Name:       added.Name,
+				After:      added,
+				Typosquats: r.Typosquats[added.Name],
+			})
+		}
+	}
+	for _, g := range r.Gems {
+		before := g.Before
+		changes = append(changes, policy.Change{
+			Name:            g.Name,
+			Before:          &before,
+			After:           g.After,
+			Bump:            g.Bump,
+			MetadataChanges: g.MetadataChanges,
+			Findings:        g.Findings,
+			Incomplete:      g.Incomplete(),
+		})
+	}
+	r.Policy = p.Evaluate(changes)
+}
+
+// Save writes the report as indented JSON
+func (r *Report) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// Load reads a report saved with Save
+func Load(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	if r.SchemaVersion == 0 {
+		return nil, fmt.Errorf("%s is not a whiskers report", path)
+	}
+	if r.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("report %s has schema version %d, newer than the supported version %d", path, r.SchemaVersion, SchemaVersion)
+	}
+	return &r, nil
+}
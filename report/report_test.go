@@ -0,0 +1,68 @@
+package report
+
+import (
+	"slices"
+	"testing"
+	"time"
+	"whiskers/gem"
+	"whiskers/policy"
+	"whiskers/risk"
+	"whiskers/scan"
+)
+
+// Added gems aren't downloaded, so the gemspec checks only apply to updates
+func TestApplyPolicyAddedGems(t *testing.T) {
+	extension := []gem.SpecChange{{Field: "extensions", Added: []string{"ext/extconf.rb"}}}
+	r := &Report{
+		Diff: &gem.DiffJSON{Added: []gem.GemJSON{{Name: "fresh", Version: "1.0"}}},
+		Gems: []*Gem{{
+			Name:            "updated",
+			Before:          gem.GemJSON{Version: "1.0"},
+			After:           gem.GemJSON{Version: "1.1"},
+			MetadataChanges: extension,
+		}},
+	}
+	r.ApplyPolicy(&policy.Policy{Rules: []policy.Rule{
+		{ID: policy.CheckAddsExtension, Check: policy.CheckAddsExtension, Action: policy.Deny},
+	}})
+
+	decisions := make(map[string]policy.Decision)
+	for _, g := range r.Policy.Gems {
+		decisions[g.Name] = g.Decision
+	}
+	if decisions["fresh"] != policy.Allow || decisions["updated"] != policy.Deny {
+		t.Errorf("decisions = %v, want fresh allowed and updated denied", decisions)
+	}
+}
+
+// Only added gems can be typosquats: an update keeps a name the project
+// already depends on, even one that looks like a popular gem
+func TestScoreRiskTyposquats(t *testing.T) {
+	source := gem.DefaultSource()
+	diff := &gem.GemfileDiff{
+		Added: []*gem.Gem{gem.NewGem("rials", "1.0.0", source), gem.NewGem("puma", "6.0.0", source)},
+	}
+	update := &scan.Result{Change: gem.VersionChange{
+		Name:   "rials",
+		Before: gem.NewGem("rials", "1.0.0", source),
+		After:  gem.NewGem("rials", "1.0.1", source),
+	}}
+	r := New("gemfile-diff-scan", []*scan.Result{update}, diff, nil, Tool{}, t.TempDir(), time.Now())
+	r.ScoreRisk(risk.DefaultWeights())
+
+	if len(r.Typosquats["rials"]) == 0 {
+		t.Fatal("the added gem isn't a typosquat")
+	}
+	if got, want := r.AddedRisk["rials"].Score, risk.DefaultWeights().Typosquat; got != want {
+		t.Errorf("added rials scored %g, want %g", got, want)
+	}
+	if got := r.AddedRisk["puma"].Score; got != 0 {
+		t.Errorf("added puma scored %g, want 0", got)
+	}
+	if got := r.Gems[0].RiskScore(); got != 0 {
+		t.Errorf("updated rials scored %g, want 0", got)
+	}
+	if got := r.RiskyAddedGems(); !slices.Equal(got, []string{"rials"}) {
+		t.Errorf("RiskyAddedGems() = %v, want [rials]", got)
+	}
+}
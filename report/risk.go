@@ -0,0 +1,98 @@
+package report
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"whiskers/risk"
+)
+
+// ScoreRisk scores the risk of each gem change and added gem with the given
+// weights, and orders the gem changes from riskiest to safest
+func (r *Report) ScoreRisk(w risk.Weights) {
+	for _, g := range r.Gems {
+		in := risk.Input{
+			Bump:            g.Bump,
+			Findings:        g.Findings,
+			MetadataChanges: g.MetadataChanges,
+		}
+		if g.Files != nil {
+			in.FilesChanged = len(g.Files.Added) + len(g.Files.Removed) + len(g.Files.Changed)
+			for _, path := range g.Files.Added {
+				if g.AfterPath != "" && isBinaryFile(filepath.Join(g.AfterPath, path)) {
+					in.BinaryFiles = append(in.BinaryFiles, path)
+				}
+			}
+			sort.Strings(in.BinaryFiles)
+		}
+		g.Risk = w.Score(in)
+	}
+
+	sort.SliceStable(r.Gems, func(i, j int) bool {
+		return r.Gems[i].RiskScore() > r.Gems[j].RiskScore()
+	})
+
+	// Added gems aren't downloaded, so only their names can be scored. Only
+	// they can be typosquats: an update keeps a name the project already uses.
+	if r.Diff != nil {
+		r.AddedRisk = make(map[string]*risk.Score, len(r.Diff.Added))
+		for _, added := range r.Diff.Added {
+			r.AddedRisk[added.Name] = w.Score(risk.Input{Typosquats: r.Typosquats[added.Name]})
+		}
+	}
+}
+
+// RiskyAddedGems returns the names of the added gems with a positive risk
+// score, riskiest first
+func (r *Report) RiskyAddedGems() []string {
+	var names []string
+	for name, score := range r.AddedRisk {
+		if score.Score > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := r.AddedRisk[names[i]].Score, r.AddedRisk[names[j]].Score
+		if a != b {
+			return a > b
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// Scored returns true if the report's gems were scored with ScoreRisk
+func (r *Report) Scored() bool {
+	for _, g := range r.Gems {
+		if g.Risk != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// RiskScore returns the gem's risk score, or zero if it wasn't scored
+func (g *Gem) RiskScore() float64 {
+	if g.Risk == nil {
+		return 0
+	}
+	return g.Risk.Score
+}
+
+// isBinaryFile returns true if a file has a NUL byte near its start
+func isBinaryFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	head := make([]byte, 8000)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false
+	}
+	return bytes.IndexByte(head[:n], 0) >= 0
+}
@@ -0,0 +1,225 @@
+package report
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"whiskers/sarif"
+	"whiskers/semgrep"
+)
+
+// GemsBaseID is the uriBaseId of artifact locations in SARIF reports. It
+// stands for the directory the gems were extracted to, so every location
+// names the gem it is in, e.g. rails-7.0.8.5/lib/rails.rb.
+const GemsBaseID = "GEMS"
+
+// FingerprintKey is the partialFingerprints key of whiskers' finding fingerprints
+const FingerprintKey = "whiskers/v1"
+
+// securitySeverities are the CVSS-like scores code scanning dashboards rank results by
+var securitySeverities = map[string]string{
+	"ERROR":   "8.0",
+	"WARNING": "5.0",
+	"INFO":    "2.0",
+}
+
+// SARIF converts the new findings of a report to a SARIF log with a single
+// run. Rules are described from the report's rules, and from the findings
+// themselves for rules outside them, such as whiskers' own analyses.
+func SARIF(r *Report) *sarif.Log {
+	run := sarif.Run{
+		Tool: sarif.Tool{Driver: sarif.ToolComponent{
+			Name:    r.Tool.Name,
+			Version: r.Tool.Version,
+		}},
+		OriginalURIBaseIDs: map[string]sarif.ArtifactLocation{
+			GemsBaseID: {URI: directoryURI(r.GemsDir)},
+		},
+		Results: []sarif.Result{},
+		Properties: map[string]interface{}{
+			"scanners":  r.Tool.Scanners,
+			"rulePacks": r.Rules.Packs,
+		},
+	}
+
+	ruleIndex := make(map[string]int)
+	addRule := func(descriptor sarif.ReportingDescriptor) {
+		if _, ok := ruleIndex[descriptor.ID]; !ok {
+			ruleIndex[descriptor.ID] = len(run.Tool.Driver.Rules)
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, descriptor)
+		}
+	}
+	for _, rule := range r.Rules.Rules {
+		addRule(ruleDescriptor(rule.ID, rule.Message, rule.Severity, rule.Metadata))
+	}
+
+	invocation := sarif.Invocation{ExecutionSuccessful: true}
+	artifactIndex := make(map[string]bool)
+	for _, g := range r.Gems {
+		if g.Error != "" {
+			invocation.ExecutionSuccessful = false
+			invocation.ToolExecutionNotifications = append(invocation.ToolExecutionNotifications, sarif.Notification{
+				Level: "error",
+				Message: sarif.Message{Text: fmt.Sprintf("failed to scan %s (%s → %s): %s",
+					g.Name, g.Before.Version, g.After.Version, g.Error)},
+			})
+			continue
+		}
+
+		gemDir := filepath.Base(g.AfterPath)
+		for _, e := range g.RuleErrors {
+			invocation.ToolExecutionNotifications = append(invocation.ToolExecutionNotifications, sarif.Notification{
+				Level:   "error",
+				Message: sarif.Message{Text: e.Display()},
+			})
+		}
+		for _, e := range g.Unanalyzed {
+			invocation.ToolExecutionNotifications = append(invocation.ToolExecutionNotifications, sarif.Notification{
+				Level:     "warning",
+				Message:   sarif.Message{Text: "file could not be analyzed: " + e.Display()},
+				Locations: []sarif.Location{{PhysicalLocation: sarif.PhysicalLocation{ArtifactLocation: artifactLocation(gemDir, e.Path)}}},
+			})
+		}
+
+		for _, f := range g.Findings {
+			addRule(ruleDescriptor(f.RuleID, f.Message, f.Severity, f.Metadata))
+			location := artifactLocation(gemDir, f.Path)
+			if !artifactIndex[location.URI] {
+				artifactIndex[location.URI] = true
+				run.Artifacts = append(run.Artifacts, sarif.Artifact{
+					Location:   location,
+					Properties: gemProperties(g),
+				})
+			}
+
+			index := ruleIndex[f.RuleID]
+			result := sarif.Result{
+				RuleID:     f.RuleID,
+				RuleIndex:  &index,
+				Level:      sarif.Level(normalizeSeverity(f.Severity)),
+				Message:    sarif.Message{Text: f.Message},
+				Locations:  []sarif.Location{{PhysicalLocation: sarif.PhysicalLocation{ArtifactLocation: location, Region: region(f)}}},
+				Properties: gemProperties(g),
+			}
+			if f.Fingerprint != "" {
+				result.PartialFingerprints = map[string]string{FingerprintKey: f.Fingerprint}
+			}
+			if f.Scanner != "" {
+				result.Properties["scanner"] = f.Scanner
+			}
+			if f.LoadTime {
+				result.Properties["loadTime"] = true
+			}
+			run.Results = append(run.Results, result)
+		}
+	}
+	run.Invocations = []sarif.Invocation{invocation}
+
+	return &sarif.Log{
+		Schema:  sarif.Schema,
+		Version: sarif.Version,
+		Runs:    []sarif.Run{run},
+	}
+}
+
+// ruleDescriptor describes a rule from its message, severity and metadata
+func ruleDescriptor(id, message, severity string, metadata semgrep.Metadata) sarif.ReportingDescriptor {
+	severity = strings.ToUpper(severity)
+	descriptor := sarif.ReportingDescriptor{
+		ID:                   id,
+		DefaultConfiguration: &sarif.Configuration{Level: sarif.Level(normalizeSeverity(severity))},
+		Properties:           make(map[string]interface{}),
+	}
+	if message != "" {
+		short, _, _ := strings.Cut(message, "\n")
+		descriptor.ShortDescription = &sarif.Message{Text: short}
+		descriptor.FullDescription = &sarif.Message{Text: message}
+	}
+
+	if len(metadata.References) > 0 {
+		descriptor.HelpURI = metadata.References[0]
+		var help strings.Builder
+		for _, ref := range metadata.References {
+			fmt.Fprintf(&help, "- %s\n", ref)
+		}
+		descriptor.Help = &sarif.Message{Text: strings.Join(metadata.References, "\n"), Markdown: help.String()}
+	}
+
+	if metadata.Category != "" {
+		descriptor.Properties["tags"] = []string{metadata.Category}
+	}
+	if score, ok := securitySeverities[normalizeSeverity(severity)]; ok && metadata.Category == "security" {
+		descriptor.Properties["security-severity"] = score
+	}
+	// SARIF calls confidence precision
+	if precision := strings.ToLower(metadata.Confidence); precision != "" {
+		descriptor.Properties["precision"] = precision
+	}
+	for key, value := range map[string]string{"impact": metadata.Impact, "likelihood": metadata.Likelihood} {
+		if value != "" {
+			descriptor.Properties[key] = strings.ToLower(value)
+		}
+	}
+	return descriptor
+}
+
+// normalizeSeverity maps semgrep's LOW/MEDIUM/HIGH/CRITICAL names onto INFO/WARNING/ERROR
+func normalizeSeverity(severity string) string {
+	switch level := semgrep.SeverityLevel(severity); {
+	case level >= 3:
+		return "ERROR"
+	case level == 2:
+		return "WARNING"
+	case level == 1:
+		return "INFO"
+	}
+	return ""
+}
+
+// directoryURI returns the file URI of a directory, with a trailing slash
+// so relative URIs resolve inside it
+func directoryURI(dir string) string {
+	path := filepath.ToSlash(filepath.Clean(dir))
+	// Windows paths such as C:/gems need a leading slash, file:///C:/gems
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: strings.TrimSuffix(path, "/") + "/"}).String()
+}
+
+// artifactLocation locates a file of a gem, given relative to the gem's
+// directory. The URI is percent-encoded, since gem files may be named with
+// spaces, # or %.
+func artifactLocation(gemDir, path string) sarif.ArtifactLocation {
+	return sarif.ArtifactLocation{
+		URI:       (&url.URL{Path: gemDir + "/" + filepath.ToSlash(path)}).String(),
+		URIBaseID: GemsBaseID,
+	}
+}
+
+// region returns the span of a finding, or nil for findings about the gem as a whole
+func region(f *semgrep.Finding) *sarif.Region {
+	if f.Line <= 0 {
+		return nil
+	}
+	r := &sarif.Region{
+		StartLine:   f.Line,
+		StartColumn: f.Column,
+		EndLine:     f.EndLine,
+		EndColumn:   f.EndColumn,
+	}
+	if f.Lines != "" {
+		r.Snippet = &sarif.Message{Text: f.Lines}
+	}
+	return r
+}
+
+// gemProperties identifies the gem change a result or artifact belongs to
+func gemProperties(g *Gem) map[string]interface{} {
+	return map[string]interface{}{
+		"gem":             g.Name,
+		"version":         g.After.Version,
+		"previousVersion": g.Before.Version,
+	}
+}
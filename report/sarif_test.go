@@ -0,0 +1,102 @@
+package report
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+	"whiskers/gem"
+	"whiskers/semgrep"
+)
+
+func TestNewAbsoluteGemsDir(t *testing.T) {
+	r := New("gem-diff-scan", nil, nil, nil, Tool{}, "gems", time.Now())
+	if !filepath.IsAbs(r.GemsDir) || filepath.Base(r.GemsDir) != "gems" {
+		t.Errorf("GemsDir = %q, want an absolute path", r.GemsDir)
+	}
+}
+
+func TestDirectoryURI(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"/tmp/gems", "file:///tmp/gems/"},
+		{"/tmp/gems/", "file:///tmp/gems/"},
+		{"/tmp/my gems#1", "file:///tmp/my%20gems%231/"},
+		{"/tmp/100%", "file:///tmp/100%25/"},
+		{"/", "file:///"},
+	}
+	for _, tt := range tests {
+		if got := directoryURI(tt.dir); got != tt.want {
+			t.Errorf("directoryURI(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestSARIFArtifactURIs(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"lib/a.rb", "a-1.1/lib/a.rb"},
+		{"lib/my file.rb", "a-1.1/lib/my%20file.rb"},
+		{"lib/#hash.rb", "a-1.1/lib/%23hash.rb"},
+		{"lib/50%.rb", "a-1.1/lib/50%25.rb"},
+		{"lib/a?.rb", "a-1.1/lib/a%3F.rb"},
+	}
+	for _, tt := range tests {
+		r := &Report{
+			GemsDir: "/tmp/gems",
+			Gems: []*Gem{{
+				Name:      "a",
+				Before:    gem.GemJSON{Version: "1.0"},
+				After:     gem.GemJSON{Version: "1.1"},
+				AfterPath: "/tmp/gems/a-1.1",
+				Findings:  []*semgrep.Finding{{RuleID: "rule", Path: tt.path, Severity: "ERROR", Line: 1}},
+			}},
+		}
+		run := SARIF(r).Runs[0]
+		if got := run.Results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI; got != tt.want {
+			t.Errorf("result URI for %q = %q, want %q", tt.path, got, tt.want)
+		}
+		if got := run.Artifacts[0].Location.URI; got != tt.want {
+			t.Errorf("artifact URI for %q = %q, want %q", tt.path, got, tt.want)
+		}
+		if got := run.OriginalURIBaseIDs[GemsBaseID].URI; got != "file:///tmp/gems/" {
+			t.Errorf("base URI = %q", got)
+		}
+	}
+}
+
+func TestSARIFLevels(t *testing.T) {
+	tests := []struct {
+		severity string
+		want     string
+	}{
+		{"CRITICAL", "error"},
+		{"HIGH", "error"},
+		{"ERROR", "error"},
+		{"MEDIUM", "warning"},
+		{"WARNING", "warning"},
+		{"LOW", "note"},
+		{"info", "note"},
+		{"unknown", "warning"},
+	}
+	for _, tt := range tests {
+		r := &Report{
+			GemsDir: "/tmp/gems",
+			Gems: []*Gem{{
+				Name:      "a",
+				AfterPath: "/tmp/gems/a-1.1",
+				Findings:  []*semgrep.Finding{{RuleID: "rule", Path: "lib/a.rb", Severity: tt.severity, Line: 1}},
+			}},
+		}
+		run := SARIF(r).Runs[0]
+		if got := run.Results[0].Level; got != tt.want {
+			t.Errorf("result level for %s = %q, want %q", tt.severity, got, tt.want)
+		}
+		if got := run.Tool.Driver.Rules[0].DefaultConfiguration.Level; got != tt.want {
+			t.Errorf("rule level for %s = %q, want %q", tt.severity, got, tt.want)
+		}
+	}
+}
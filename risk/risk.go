@@ -0,0 +1,233 @@
+package risk
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"whiskers/gem"
+	"whiskers/semgrep"
+)
+
+// Weights are the points each kind of risk adds to a gem change's score
+type Weights struct {
+	// Severity are the points per new finding of each severity
+	Severity SeverityWeights `yaml:"severity"`
+	// Confidence multiplies a finding's points by its rule's confidence.
+	// Findings of rules without a confidence count fully.
+	Confidence ConfidenceWeights `yaml:"confidence"`
+	// NewAuthor is per author or email address added to the gemspec
+	NewAuthor float64 `yaml:"new_author"`
+	// NewDependency is per runtime dependency added to the gemspec
+	NewDependency float64 `yaml:"new_dependency"`
+	NewExtension  float64 `yaml:"new_extension"`
+	NewExecutable float64 `yaml:"new_executable"`
+	// DiffSize is per multiple of the bump class's expected number of
+	// changed files beyond it, see ExpectedFiles
+	DiffSize float64 `yaml:"diff_size"`
+	// BinaryFile is per added binary file
+	BinaryFile float64 `yaml:"binary_file"`
+	// Typosquat is added once if an added gem is named like a popular gem
+	Typosquat float64 `yaml:"typosquat"`
+}
+
+// SeverityWeights are the points per finding of each severity. Findings of
+// unknown severity count as INFO, and CRITICAL ones as ERROR.
+type SeverityWeights struct {
+	Error   float64 `yaml:"ERROR"`
+	Warning float64 `yaml:"WARNING"`
+	Info    float64 `yaml:"INFO"`
+}
+
+// ConfidenceWeights multiply the points of findings by their rule's confidence
+type ConfidenceWeights struct {
+	High   float64 `yaml:"HIGH"`
+	Medium float64 `yaml:"MEDIUM"`
+	Low    float64 `yaml:"LOW"`
+}
+
+// DefaultWeights returns the weights used unless the config sets others
+func DefaultWeights() Weights {
+	return Weights{
+		Severity:      SeverityWeights{Error: 10, Warning: 4, Info: 1},
+		Confidence:    ConfidenceWeights{High: 1.5, Medium: 1, Low: 0.5},
+		NewAuthor:     8,
+		NewDependency: 3,
+		NewExtension:  10,
+		NewExecutable: 5,
+		DiffSize:      5,
+		BinaryFile:    6,
+		Typosquat:     15,
+	}
+}
+
+// ExpectedFiles is how many files a version bump of each class is expected
+// to change at most
+var ExpectedFiles = map[gem.Bump]int{
+	gem.BumpNone:       1,
+	gem.BumpPrerelease: 10,
+	gem.BumpPatch:      10,
+	gem.BumpDowngrade:  10,
+	gem.BumpMinor:      50,
+	gem.BumpMajor:      200,
+}
+
+// maxDiffSizeMultiple caps how much the diff size adds to a score, so a
+// rewrite doesn't drown out everything else
+const maxDiffSizeMultiple = 5
+
+// Input is what a gem change is scored on
+type Input struct {
+	Bump            gem.Bump
+	Findings        []*semgrep.Finding
+	MetadataChanges []gem.SpecChange
+	// FilesChanged is the number of added, removed and changed files
+	FilesChanged int
+	// BinaryFiles are the added files that aren't text
+	BinaryFiles []string
+	// Typosquats are the popular gems the name of an added gem imitates
+	Typosquats []string
+}
+
+// Score is a gem change's risk with the factors that add up to it
+type Score struct {
+	Score   float64  `json:"score"`
+	Factors []Factor `json:"factors"`
+}
+
+// Factor is one kind of risk found in a gem change
+type Factor struct {
+	Name   string  `json:"name"`
+	Points float64 `json:"points"`
+	// Detail explains what the points are for
+	Detail string `json:"detail"`
+}
+
+// Validate checks no weight is negative
+func (w Weights) Validate() error {
+	for _, value := range []float64{
+		w.Severity.Error, w.Severity.Warning, w.Severity.Info,
+		w.Confidence.High, w.Confidence.Medium, w.Confidence.Low,
+		w.NewAuthor, w.NewDependency, w.NewExtension, w.NewExecutable,
+		w.DiffSize, w.BinaryFile, w.Typosquat,
+	} {
+		if value < 0 {
+			return fmt.Errorf("weights must not be negative")
+		}
+	}
+	return nil
+}
+
+// Score adds up the risks of a gem change. Factors that add nothing are left out.
+func (w Weights) Score(in Input) *Score {
+	s := &Score{Factors: []Factor{}}
+	add := func(name string, points float64, detail string) {
+		points = round(points)
+		if points <= 0 {
+			return
+		}
+		s.Factors = append(s.Factors, Factor{Name: name, Points: points, Detail: detail})
+		s.Score += points
+	}
+
+	findingPoints, counts := 0.0, make(map[string]int)
+	for _, f := range in.Findings {
+		findingPoints += w.severity(f.Severity) * w.confidence(f.Metadata.Confidence)
+		counts[strings.ToUpper(f.Severity)]++
+	}
+	add("findings", findingPoints, describeCounts(counts))
+
+	added := func(fields ...string) []string {
+		var values []string
+		for _, change := range in.MetadataChanges {
+			for _, field := range fields {
+				if change.Field == field {
+					values = append(values, change.Added...)
+				}
+			}
+		}
+		return values
+	}
+	for _, factor := range []struct {
+		name   string
+		values []string
+		weight float64
+	}{
+		{"new-authors", added("authors", "email"), w.NewAuthor},
+		{"new-dependencies", added("dependencies"), w.NewDependency},
+		{"new-extensions", added("extensions"), w.NewExtension},
+		{"new-executables", added("executables"), w.NewExecutable},
+	} {
+		if len(factor.values) > 0 {
+			add(factor.name, factor.weight*float64(len(factor.values)), strings.Join(factor.values, ", "))
+		}
+	}
+
+	if expected, ok := ExpectedFiles[in.Bump]; ok && in.FilesChanged > expected {
+		multiple := min(float64(in.FilesChanged-expected)/float64(expected), maxDiffSizeMultiple)
+		add("diff-size", w.DiffSize*multiple,
+			fmt.Sprintf("%d files changed in a %s bump, which usually changes at most %d", in.FilesChanged, in.Bump, expected))
+	}
+
+	if len(in.BinaryFiles) > 0 {
+		add("binary-files", w.BinaryFile*float64(len(in.BinaryFiles)), strings.Join(in.BinaryFiles, ", "))
+	}
+
+	if len(in.Typosquats) > 0 {
+		add("typosquat", w.Typosquat, "name looks like "+strings.Join(in.Typosquats, ", "))
+	}
+
+	s.Score = round(s.Score)
+	return s
+}
+
+// severity returns the weight of a finding's severity, matching aliases
+// such as HIGH for ERROR
+func (w Weights) severity(severity string) float64 {
+	switch level := semgrep.SeverityLevel(severity); {
+	case level >= semgrep.SeverityLevel("ERROR"):
+		return w.Severity.Error
+	case level == semgrep.SeverityLevel("WARNING"):
+		return w.Severity.Warning
+	}
+	return w.Severity.Info
+}
+
+// confidence returns the multiplier of a rule's confidence
+func (w Weights) confidence(confidence string) float64 {
+	switch strings.ToUpper(confidence) {
+	case "HIGH":
+		return w.Confidence.High
+	case "MEDIUM":
+		return w.Confidence.Medium
+	case "LOW":
+		return w.Confidence.Low
+	}
+	return 1
+}
+
+// describeCounts lists the number of findings of each severity, most severe
+// first, e.g. "2 ERROR, 1 WARNING"
+func describeCounts(counts map[string]int) string {
+	severities := make([]string, 0, len(counts))
+	for severity := range counts {
+		severities = append(severities, severity)
+	}
+	sort.Slice(severities, func(i, j int) bool {
+		a, b := semgrep.SeverityLevel(severities[i]), semgrep.SeverityLevel(severities[j])
+		if a != b {
+			return a > b
+		}
+		return severities[i] < severities[j]
+	})
+	parts := make([]string, 0, len(severities))
+	for _, severity := range severities {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[severity], severity))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// round rounds points to one decimal place
+func round(points float64) float64 {
+	return math.Round(points*10) / 10
+}
@@ -0,0 +1,114 @@
+package risk
+
+import (
+	"slices"
+	"testing"
+	"whiskers/gem"
+	"whiskers/semgrep"
+)
+
+// finding returns a finding of a severity from a rule of a confidence
+func finding(severity, confidence string) *semgrep.Finding {
+	f := &semgrep.Finding{Severity: severity}
+	f.Metadata.Confidence = confidence
+	return f
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights func(w *Weights)
+		in      Input
+		want    []Factor
+		score   float64
+	}{
+		{
+			name:  "nothing",
+			in:    Input{Bump: gem.BumpPatch, FilesChanged: 3},
+			want:  []Factor{},
+			score: 0,
+		},
+		{
+			name: "findings by severity and confidence",
+			in: Input{Bump: gem.BumpPatch, Findings: []*semgrep.Finding{
+				finding("ERROR", "HIGH"), finding("WARNING", ""), finding("INFO", "LOW"), finding("CRITICAL", "MEDIUM"), finding("unknown", "odd"),
+			}},
+			want:  []Factor{{"findings", 30.5, "1 CRITICAL, 1 ERROR, 1 WARNING, 1 INFO, 1 UNKNOWN"}},
+			score: 30.5,
+		},
+		{
+			name: "metadata changes",
+			in: Input{Bump: gem.BumpMinor, MetadataChanges: []gem.SpecChange{
+				{Field: "authors", Added: []string{"mallory"}, Removed: []string{"alice"}},
+				{Field: "email", Added: []string{"mallory@example.com"}},
+				{Field: "dependencies", Added: []string{"rest-client"}},
+				{Field: "extensions", Added: []string{"ext/extconf.rb"}},
+				{Field: "executables", Removed: []string{"tool"}},
+			}},
+			want: []Factor{
+				{"new-authors", 16, "mallory, mallory@example.com"},
+				{"new-dependencies", 3, "rest-client"},
+				{"new-extensions", 10, "ext/extconf.rb"},
+			},
+			score: 29,
+		},
+		{
+			name:  "diff size",
+			in:    Input{Bump: gem.BumpPatch, FilesChanged: 25},
+			want:  []Factor{{"diff-size", 7.5, "25 files changed in a patch bump, which usually changes at most 10"}},
+			score: 7.5,
+		},
+		{
+			name:  "diff size is capped",
+			in:    Input{Bump: gem.BumpMajor, FilesChanged: 5000},
+			want:  []Factor{{"diff-size", 25, "5000 files changed in a major bump, which usually changes at most 200"}},
+			score: 25,
+		},
+		{
+			name:  "binary files and typosquat",
+			in:    Input{Bump: gem.BumpPatch, BinaryFiles: []string{"a.so", "b.bin"}, Typosquats: []string{"rails", "rack"}},
+			want:  []Factor{{"binary-files", 12, "a.so, b.bin"}, {"typosquat", 15, "name looks like rails, rack"}},
+			score: 27,
+		},
+		{
+			name:    "zero weights leave factors out",
+			weights: func(w *Weights) { w.BinaryFile, w.Severity.Info = 0, 0 },
+			in:      Input{Bump: gem.BumpPatch, BinaryFiles: []string{"a.so"}, Findings: []*semgrep.Finding{finding("INFO", "")}},
+			want:    []Factor{},
+			score:   0,
+		},
+		{
+			name:    "points are rounded",
+			weights: func(w *Weights) { w.Severity.Info = 0.11 },
+			in:      Input{Bump: gem.BumpPatch, Findings: []*semgrep.Finding{finding("INFO", ""), finding("INFO", ""), finding("INFO", "")}},
+			want:    []Factor{{"findings", 0.3, "3 INFO"}},
+			score:   0.3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := DefaultWeights()
+			if tt.weights != nil {
+				tt.weights(&w)
+			}
+			got := w.Score(tt.in)
+			if !slices.Equal(got.Factors, tt.want) {
+				t.Errorf("Factors = %+v, want %+v", got.Factors, tt.want)
+			}
+			if got.Score != tt.score {
+				t.Errorf("Score = %g, want %g", got.Score, tt.score)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := DefaultWeights().Validate(); err != nil {
+		t.Errorf("default weights: %v", err)
+	}
+	w := DefaultWeights()
+	w.Confidence.Low = -1
+	if err := w.Validate(); err == nil {
+		t.Error("negative weight: got no error")
+	}
+}
@@ -0,0 +1,713 @@
+package ruby
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Kind is the type of a token
+type Kind int
+
+// Token kinds
+const (
+	EOF Kind = iota
+	// Newline ends a line outside of literals, and can end a statement
+	Newline
+	// Ident is a local name or method name, e.g. eval, empty?, save!
+	Ident
+	// Constant is a name starting with an uppercase letter, e.g. File
+	Constant
+	Keyword
+	// Variable is an instance, class or global variable, e.g. @x, @@y, $z
+	Variable
+	Symbol
+	Number
+	// String is a string literal of any form, including heredocs, %w lists and character literals
+	String
+	// Command is a backtick or %x literal, which Ruby runs as a shell command
+	Command
+	Regexp
+	Operator
+	Comment
+)
+
+// keywords are Ruby's reserved words
+var keywords = map[string]bool{
+	"BEGIN": true, "END": true, "alias": true, "and": true, "begin": true, "break": true,
+	"case": true, "class": true, "def": true, "defined?": true, "do": true, "else": true,
+	"elsif": true, "end": true, "ensure": true, "false": true, "for": true, "if": true,
+	"in": true, "module": true, "next": true, "nil": true, "not": true, "or": true,
+	"redo": true, "rescue": true, "retry": true, "return": true, "self": true, "super": true,
+	"then": true, "true": true, "undef": true, "unless": true, "until": true, "when": true,
+	"while": true, "yield": true, "__FILE__": true, "__LINE__": true, "__dir__": true,
+	"__method__": true, "__ENCODING__": true,
+}
+
+// operators are the multi-character operators, longest first
+var operators = []string{
+	"**=", "<=>", "===", "...", "<<=", ">>=", "&&=", "||=",
+	"==", "!=", ">=", "<=", "&&", "||", "<<", ">>", "**", "=~", "!~", "..", "::",
+	"->", "=>", "+=", "-=", "*=", "/=", "%=", "|=", "&=", "^=", "&.",
+}
+
+// Token is a lexical token of Ruby source
+type Token struct {
+	Kind Kind
+	// Text is the token's source text. For heredocs it is the opening
+	// marker, e.g. "<<~SQL"; the body is in Value.
+	Text string
+	// Value is the content of a string, command, regexp or symbol literal,
+	// with simple escapes decoded and interpolations kept as "#{...}"
+	Value string
+	// Interpolated is set for literals containing #{...}
+	Interpolated bool
+	// Line and Column are 1-based and locate the start of the token
+	Line   int
+	Column int
+	// EndLine is the last line of the token, which differs from Line for
+	// multi-line literals and heredocs
+	EndLine int
+	// SpaceBefore is set when whitespace separates the token from the previous one
+	SpaceBefore bool
+}
+
+// Is returns true if the token is the given operator or keyword
+func (t Token) Is(text string) bool {
+	return (t.Kind == Operator || t.Kind == Keyword) && t.Text == text
+}
+
+// heredoc is a heredoc whose body starts after the current line
+type heredoc struct {
+	index      int
+	terminator string
+	// squiggly (<<~) heredocs have their common indentation removed,
+	// dash (<<-) and squiggly ones may indent the terminator
+	squiggly bool
+	indented bool
+	raw      bool
+}
+
+// lexer splits Ruby source into tokens
+type lexer struct {
+	src    []byte
+	pos    int
+	line   int
+	col    int
+	tokens []Token
+	space  bool
+	// heredocs waiting for the end of the line to read their bodies
+	pending []heredoc
+}
+
+// Tokenize splits Ruby source into tokens, ending with an EOF token. It
+// never fails: unterminated literals run to the end of the source.
+func Tokenize(src []byte) []Token {
+	l := &lexer{src: src, line: 1, col: 1}
+	l.run()
+	return l.tokens
+}
+
+// run lexes the whole source
+func (l *lexer) run() {
+	atLineStart := true
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+
+		// =begin/=end comments and __END__ only count at the start of a line
+		if atLineStart {
+			if l.hasPrefix("=begin") && l.wordEnds(len("=begin")) {
+				l.blockComment()
+				continue
+			}
+			if l.hasPrefix("__END__") && (l.pos+7 == len(l.src) || l.src[l.pos+7] == '\n' || l.src[l.pos+7] == '\r') {
+				break
+			}
+		}
+		atLineStart = false
+
+		switch {
+		case c == '\n':
+			l.emit(Token{Kind: Newline, Text: "\n", Line: l.line, Column: l.col, EndLine: l.line}, 1)
+			l.readHeredocBodies()
+			atLineStart = true
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			l.advance(1)
+			l.space = true
+		case c == '\\' && l.peek(1) == '\n':
+			// Line continuation
+			l.advance(2)
+			l.space = true
+		case c == '#':
+			l.comment()
+		case c == '"' || c == '\'':
+			l.quoted(String, c, c, c == '"')
+		case c == '`':
+			l.quoted(Command, '`', '`', true)
+		case c == '%' && l.percentLiteral():
+		case c == '/' && l.regexpAllowed():
+			l.quoted(Regexp, '/', '/', true)
+		case c == '<' && l.heredocStart():
+		case c == '?' && l.charLiteral():
+		case c == ':' && l.symbol():
+		case c == '@' || c == '$':
+			l.variable()
+		case isDigit(c):
+			l.number()
+		case isIdentStart(c):
+			l.identifier()
+		default:
+			l.operator()
+		}
+	}
+	l.tokens = append(l.tokens, Token{Kind: EOF, Line: l.line, Column: l.col, EndLine: l.line, SpaceBefore: l.space})
+}
+
+// emit appends a token spanning n bytes from the current position and advances past it
+func (l *lexer) emit(t Token, n int) {
+	t.SpaceBefore = l.space
+	if t.Text == "" {
+		t.Text = string(l.src[l.pos : l.pos+n])
+	}
+	l.tokens = append(l.tokens, t)
+	l.advance(n)
+	l.space = false
+}
+
+// advance moves forward n bytes, tracking lines and columns
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.pos < len(l.src); i++ {
+		if l.src[l.pos] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+		l.pos++
+	}
+}
+
+// peek returns the byte at offset from the current position, or 0
+func (l *lexer) peek(offset int) byte {
+	if l.pos+offset < len(l.src) {
+		return l.src[l.pos+offset]
+	}
+	return 0
+}
+
+// hasPrefix returns true if the source continues with s
+func (l *lexer) hasPrefix(s string) bool {
+	return bytes.HasPrefix(l.src[l.pos:], []byte(s))
+}
+
+// wordEnds returns true if no identifier character follows the next n bytes
+func (l *lexer) wordEnds(n int) bool {
+	return !isIdentChar(l.peek(n))
+}
+
+// last returns the previous token, or an EOF token at the start
+func (l *lexer) last() Token {
+	for i := len(l.tokens) - 1; i >= 0; i-- {
+		if l.tokens[i].Kind != Comment {
+			return l.tokens[i]
+		}
+	}
+	return Token{Kind: EOF}
+}
+
+// afterValue returns true if the previous token ends an expression, in
+// which case "/", "%", "?", ":" and "<<" are operators rather than the start
+// of a literal. A name followed by a space and then the operator without
+// one, as in `puts /x/`, is a method call with a literal argument; width is
+// the length of the operator.
+func (l *lexer) afterValue(width int) bool {
+	prev := l.last()
+	value := false
+	switch prev.Kind {
+	case Ident, Constant, Variable, Number, String, Command, Regexp, Symbol:
+		value = true
+	case Keyword:
+		value = prev.Text == "end" || prev.Text == "self" || prev.Text == "true" ||
+			prev.Text == "false" || prev.Text == "nil" || prev.Text == "__FILE__"
+	case Operator:
+		value = prev.Text == ")" || prev.Text == "]" || prev.Text == "}"
+	}
+	if !value {
+		return false
+	}
+	if prev.Kind == Ident && l.space {
+		next := l.peek(width)
+		return next == ' ' || next == '\t' || next == '=' || next == '\n'
+	}
+	return true
+}
+
+// blockComment skips an =begin ... =end comment
+func (l *lexer) blockComment() {
+	start, line, col := l.pos, l.line, l.col
+	for l.pos < len(l.src) {
+		lineEnd := bytes.IndexByte(l.src[l.pos:], '\n')
+		if l.hasPrefix("=end") && l.wordEnds(len("=end")) {
+			if lineEnd < 0 {
+				lineEnd = len(l.src) - l.pos
+			}
+			l.advance(lineEnd)
+			break
+		}
+		if lineEnd < 0 {
+			l.advance(len(l.src) - l.pos)
+			break
+		}
+		l.advance(lineEnd + 1)
+	}
+	l.tokens = append(l.tokens, Token{Kind: Comment, Text: string(l.src[start:l.pos]), Line: line, Column: col, EndLine: l.line, SpaceBefore: l.space})
+}
+
+// comment lexes a # comment up to the end of the line
+func (l *lexer) comment() {
+	end := bytes.IndexByte(l.src[l.pos:], '\n')
+	if end < 0 {
+		end = len(l.src) - l.pos
+	}
+	text := strings.TrimRight(string(l.src[l.pos:l.pos+end]), "\r")
+	l.emit(Token{Kind: Comment, Text: text, Value: text, Line: l.line, Column: l.col, EndLine: l.line}, len(text))
+}
+
+// quoted lexes a literal from an opening delimiter to its closing one. Pairs
+// of brackets nest, and interpolation is only recognised if interpolate is set.
+func (l *lexer) quoted(kind Kind, open, close byte, interpolate bool) {
+	l.literal(kind, 1, open, close, interpolate)
+}
+
+// literal lexes a literal whose opening delimiter is prefixLen bytes long
+func (l *lexer) literal(kind Kind, prefixLen int, open, close byte, interpolate bool) {
+	start, line, col := l.pos, l.line, l.col
+	l.advance(prefixLen)
+
+	var value strings.Builder
+	interpolated := false
+	depth := 0
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\\' && l.pos+1 < len(l.src):
+			next := l.src[l.pos+1]
+			if interpolate && kind != Regexp {
+				value.WriteString(unescape(next))
+			} else if next == close || next == open || next == '\\' {
+				value.WriteByte(next)
+			} else {
+				value.WriteByte(c)
+				value.WriteByte(next)
+			}
+			l.advance(2)
+			continue
+		case interpolate && c == '#' && l.peek(1) == '{':
+			end := l.interpolationEnd()
+			value.Write(l.src[l.pos:end])
+			interpolated = true
+			l.advance(end - l.pos)
+			continue
+		case c == close && depth == 0:
+			l.advance(1)
+			// Regexp options, e.g. /x/im
+			if kind == Regexp {
+				for l.pos < len(l.src) && isIdentChar(l.src[l.pos]) {
+					l.advance(1)
+				}
+			}
+			l.finishLiteral(kind, start, line, col, value.String(), interpolated)
+			return
+		case open != close && c == open:
+			depth++
+		case open != close && c == close:
+			depth--
+		}
+		value.WriteByte(c)
+		l.advance(1)
+	}
+	l.finishLiteral(kind, start, line, col, value.String(), interpolated)
+}
+
+// finishLiteral appends a literal token that started at start
+func (l *lexer) finishLiteral(kind Kind, start, line, col int, value string, interpolated bool) {
+	l.tokens = append(l.tokens, Token{
+		Kind:         kind,
+		Text:         string(l.src[start:l.pos]),
+		Value:        value,
+		Interpolated: interpolated,
+		Line:         line,
+		Column:       col,
+		EndLine:      l.line,
+		SpaceBefore:  l.space,
+	})
+	l.space = false
+}
+
+// interpolationEnd returns the position just past the } closing the #{ at
+// the current position, skipping nested braces and string literals
+func (l *lexer) interpolationEnd() int {
+	depth := 0
+	var quote byte
+	for i := l.pos + 1; i < len(l.src); i++ {
+		c := l.src[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(l.src)
+}
+
+// percentLiteral lexes %q(), %Q[], %w{}, %i<>, %x||, %r//, %s() and %()
+// literals, returning false if the % is the modulo operator
+func (l *lexer) percentLiteral() bool {
+	if l.afterValue(1) {
+		return false
+	}
+	typ := l.peek(1)
+	prefixLen := 2
+	if isAlpha(typ) {
+		if !strings.ContainsRune("qQwWiIxrs", rune(typ)) {
+			return false
+		}
+		prefixLen = 3
+	} else {
+		typ = 'Q'
+	}
+	open := l.peek(prefixLen - 1)
+	if open == 0 || isAlnum(open) || open == ' ' || open == '\n' || open == '=' && prefixLen == 2 {
+		return false
+	}
+	close := closingDelimiter(open)
+
+	kind, interpolate := String, true
+	switch typ {
+	case 'q', 'w', 'i', 's':
+		interpolate = false
+	case 'x':
+		kind = Command
+	case 'r':
+		kind = Regexp
+	}
+	if typ == 's' || typ == 'i' || typ == 'I' {
+		kind = Symbol
+	}
+	l.literal(kind, prefixLen, open, close, interpolate)
+	return true
+}
+
+// regexpAllowed returns true if a / starts a regular expression
+func (l *lexer) regexpAllowed() bool {
+	return !l.afterValue(1)
+}
+
+// heredocStart lexes a heredoc opener such as <<~EOS, <<-'SQL' or <<HTML and
+// queues its body to be read at the end of the line. It returns false for
+// the << operator.
+func (l *lexer) heredocStart() bool {
+	if l.peek(1) != '<' || l.afterValue(2) {
+		return false
+	}
+	i := 2
+	h := heredoc{}
+	switch l.peek(i) {
+	case '~':
+		h.squiggly, h.indented = true, true
+		i++
+	case '-':
+		h.indented = true
+		i++
+	}
+
+	kind := String
+	quote := l.peek(i)
+	if quote == '\'' || quote == '"' || quote == '`' {
+		end := bytes.IndexByte(l.src[l.pos+i+1:], quote)
+		if end <= 0 {
+			return false
+		}
+		h.terminator = string(l.src[l.pos+i+1 : l.pos+i+1+end])
+		h.raw = quote == '\''
+		if quote == '`' {
+			kind = Command
+		}
+		i += end + 2
+	} else {
+		j := i
+		for isIdentChar(l.peek(j)) {
+			j++
+		}
+		// Bare terminators are conventionally uppercase; <<foo is a shift
+		if j == i || !(h.indented || isUpper(l.peek(i)) || l.peek(i) == '_') {
+			return false
+		}
+		h.terminator = string(l.src[l.pos+i : l.pos+j])
+		i = j
+	}
+
+	h.index = len(l.tokens)
+	l.emit(Token{Kind: kind, Line: l.line, Column: l.col, EndLine: l.line}, i)
+	l.pending = append(l.pending, h)
+	return true
+}
+
+// readHeredocBodies reads the bodies of the heredocs opened on the line just ended
+func (l *lexer) readHeredocBodies() {
+	for _, h := range l.pending {
+		var lines []string
+		for l.pos < len(l.src) {
+			end := bytes.IndexByte(l.src[l.pos:], '\n')
+			if end < 0 {
+				end = len(l.src) - l.pos
+			}
+			line := strings.TrimRight(string(l.src[l.pos:l.pos+end]), "\r")
+			terminator := line
+			if h.indented {
+				terminator = strings.TrimLeft(line, " \t")
+			}
+			if terminator == h.terminator {
+				l.advance(end)
+				if l.pos < len(l.src) {
+					l.advance(1)
+				}
+				break
+			}
+			lines = append(lines, line)
+			l.advance(end + 1)
+		}
+
+		if h.squiggly {
+			lines = dedent(lines)
+		}
+		body := strings.Join(lines, "\n")
+		if len(lines) > 0 {
+			body += "\n"
+		}
+
+		t := &l.tokens[h.index]
+		t.EndLine = l.line - 1
+		if h.raw {
+			t.Value = body
+		} else {
+			t.Value, t.Interpolated = unescapeBody(body)
+		}
+	}
+	l.pending = nil
+}
+
+// charLiteral lexes a character literal such as ?a, returning false for the ternary operator
+func (l *lexer) charLiteral() bool {
+	if l.afterValue(1) {
+		return false
+	}
+	next := l.peek(1)
+	if next == 0 || next == ' ' || next == '\n' || next == '\t' || isIdentChar(next) && isIdentChar(l.peek(2)) {
+		return false
+	}
+	n := 2
+	if next == '\\' {
+		// A backslash at the end of the source escapes nothing
+		n = min(3, len(l.src)-l.pos)
+	}
+	l.emit(Token{Kind: String, Value: string(l.src[l.pos+1 : l.pos+n]), Line: l.line, Column: l.col, EndLine: l.line}, n)
+	return true
+}
+
+// symbol lexes :name, :"quoted" and operator symbols, returning false for
+// the :: operator and the colons of ternaries and hash labels
+func (l *lexer) symbol() bool {
+	next := l.peek(1)
+	if next == ':' {
+		return false
+	}
+	if next == '"' || next == '\'' {
+		l.literal(Symbol, 2, next, next, next == '"')
+		return true
+	}
+	if l.afterValue(1) && !l.space {
+		return false
+	}
+	if !isIdentStart(next) {
+		return false
+	}
+	n := 2
+	for isIdentChar(l.peek(n)) {
+		n++
+	}
+	if c := l.peek(n); c == '?' || c == '!' || c == '=' && l.peek(n+1) != '>' && l.peek(n+1) != '=' && l.peek(n+1) != '~' {
+		n++
+	}
+	l.emit(Token{Kind: Symbol, Value: string(l.src[l.pos+1 : l.pos+n]), Line: l.line, Column: l.col, EndLine: l.line}, n)
+	return true
+}
+
+// variable lexes @ivar, @@cvar and $global variables
+func (l *lexer) variable() {
+	n := 1
+	if l.src[l.pos] == '@' && l.peek(1) == '@' {
+		n = 2
+	}
+	if l.src[l.pos] == '$' && !isIdentStart(l.peek(1)) && l.peek(1) != 0 {
+		// Special globals such as $0, $: and $!
+		n = 2
+	}
+	for isIdentChar(l.peek(n)) {
+		n++
+	}
+	l.emit(Token{Kind: Variable, Line: l.line, Column: l.col, EndLine: l.line}, n)
+}
+
+// number lexes integer and float literals, including 0x, 0b, underscores and exponents
+func (l *lexer) number() {
+	n := 1
+	for {
+		c := l.peek(n)
+		switch {
+		case isAlnum(c) || c == '_':
+			n++
+		case c == '.' && isDigit(l.peek(n+1)):
+			n += 2
+		case (c == '+' || c == '-') && (l.peek(n-1) == 'e' || l.peek(n-1) == 'E') && l.src[l.pos+1] != 'x':
+			n++
+		default:
+			l.emit(Token{Kind: Number, Line: l.line, Column: l.col, EndLine: l.line}, n)
+			return
+		}
+	}
+}
+
+// identifier lexes names, keywords and labels
+func (l *lexer) identifier() {
+	n := 1
+	for isIdentChar(l.peek(n)) {
+		n++
+	}
+	// Method names may end in ? or !, but not when followed by = as in x!=y
+	if c := l.peek(n); (c == '?' || c == '!') && l.peek(n+1) != '=' && !(c == '?' && l.peek(n+1) == ':') {
+		n++
+	}
+	word := string(l.src[l.pos : l.pos+n])
+
+	kind := Ident
+	prev := l.last()
+	afterDot := prev.Kind == Operator && (prev.Text == "." || prev.Text == "&.")
+	switch {
+	case keywords[word] && !afterDot:
+		kind = Keyword
+	case isUpper(word[0]):
+		kind = Constant
+	}
+	l.emit(Token{Kind: kind, Line: l.line, Column: l.col, EndLine: l.line}, n)
+}
+
+// operator lexes punctuation, preferring the longest operator
+func (l *lexer) operator() {
+	for _, op := range operators {
+		if l.hasPrefix(op) {
+			l.emit(Token{Kind: Operator, Line: l.line, Column: l.col, EndLine: l.line}, len(op))
+			return
+		}
+	}
+	l.emit(Token{Kind: Operator, Line: l.line, Column: l.col, EndLine: l.line}, 1)
+}
+
+// closingDelimiter returns the delimiter that closes a %-literal
+func closingDelimiter(open byte) byte {
+	switch open {
+	case '(':
+		return ')'
+	case '[':
+		return ']'
+	case '{':
+		return '}'
+	case '<':
+		return '>'
+	}
+	return open
+}
+
+// unescape decodes the escape sequence \c of a double-quoted literal
+func unescape(c byte) string {
+	switch c {
+	case 'n':
+		return "\n"
+	case 't':
+		return "\t"
+	case 'r':
+		return "\r"
+	case 's':
+		return " "
+	case '0':
+		return "\x00"
+	case 'e':
+		return "\x1b"
+	case '\n':
+		return ""
+	}
+	return string(c)
+}
+
+// unescapeBody decodes the escapes of a heredoc body and reports whether it interpolates
+func unescapeBody(body string) (string, bool) {
+	var value strings.Builder
+	interpolated := false
+	for i := 0; i < len(body); i++ {
+		switch {
+		case body[i] == '\\' && i+1 < len(body):
+			value.WriteString(unescape(body[i+1]))
+			i++
+		case body[i] == '#' && i+1 < len(body) && body[i+1] == '{':
+			interpolated = true
+			value.WriteByte(body[i])
+		default:
+			value.WriteByte(body[i])
+		}
+	}
+	return value.String(), interpolated
+}
+
+// dedent removes the indentation common to the non-blank lines of a <<~ heredoc
+func dedent(lines []string) []string {
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent <= 0 {
+		return lines
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		if len(line) >= indent {
+			out[i] = line[indent:]
+		} else {
+			out[i] = strings.TrimLeft(line, " \t")
+		}
+	}
+	return out
+}
+
+func isDigit(c byte) bool      { return c >= '0' && c <= '9' }
+func isUpper(c byte) bool      { return c >= 'A' && c <= 'Z' }
+func isAlpha(c byte) bool      { return c >= 'a' && c <= 'z' || isUpper(c) }
+func isAlnum(c byte) bool      { return isAlpha(c) || isDigit(c) }
+func isIdentStart(c byte) bool { return isAlpha(c) || c == '_' || c >= 0x80 }
+func isIdentChar(c byte) bool  { return isIdentStart(c) || isDigit(c) }
@@ -0,0 +1,123 @@
+package ruby
+
+import (
+	"slices"
+	"testing"
+)
+
+// literal is the kind and value of a token
+type literal struct {
+	kind  Kind
+	value string
+}
+
+// source returns src as a slice without spare capacity, so reading past
+// its end panics
+func source(src string) []byte {
+	b := []byte(src)
+	return b[:len(b):len(b)]
+}
+
+// literals returns the string, command, regexp and symbol tokens of src
+func literals(src string) []literal {
+	var got []literal
+	for _, t := range Tokenize(source(src)) {
+		switch t.Kind {
+		case String, Command, Regexp, Symbol:
+			got = append(got, literal{t.Kind, t.Value})
+		}
+	}
+	return got
+}
+
+func TestTokenizeHeredocs(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []literal
+	}{
+		{"plain", "x = <<EOS\n  a\nb\nEOS\n", []literal{{String, "  a\nb\n"}}},
+		{"indented terminator", "x = <<-EOS\n  a\n  EOS\n", []literal{{String, "  a\n"}}},
+		{"squiggly dedents", "x = <<~EOS\n    a\n      b\n  EOS\n", []literal{{String, "a\n  b\n"}}},
+		{"interpolated", "x = <<~EOS\n  #{cmd} \\t\nEOS\n", []literal{{String, "#{cmd} \t\n"}}},
+		{"raw", "x = <<~'EOS'\n  #{cmd} \\t\nEOS\n", []literal{{String, "#{cmd} \\t\n"}}},
+		{"command", "x = <<~`EOS`\n  id\nEOS\n", []literal{{Command, "id\n"}}},
+		{"two on a line", "f(<<A, <<B)\na\nA\nb\nB\n", []literal{{String, "a\n"}, {String, "b\n"}}},
+		{"unterminated", "x = <<~EOS\n  a\n", []literal{{String, "a\n"}}},
+		{"shift", "x << y\nx <<foo\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := literals(tt.src); !slices.Equal(got, tt.want) {
+				t.Errorf("literals(%q) = %v, want %v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizePercentLiterals(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []literal
+	}{
+		{"%q(a (b) c)", []literal{{String, "a (b) c"}}},
+		{"%Q[#{x}]", []literal{{String, "#{x}"}}},
+		{"%(a\\tb)", []literal{{String, "a\tb"}}},
+		{"%w{a b}", []literal{{String, "a b"}}},
+		{"%i<a b>", []literal{{Symbol, "a b"}}},
+		{"%x|id|", []literal{{Command, "id"}}},
+		{"%r{a/b}i", []literal{{Regexp, "a/b"}}},
+		{"%s(sym)", []literal{{Symbol, "sym"}}},
+		{"x % y", nil},
+		{"x %= 2", nil},
+		{"%w(", []literal{{String, ""}}},
+		{"%q(a", []literal{{String, "a"}}},
+	}
+	for _, tt := range tests {
+		if got := literals(tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("literals(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeTruncated(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []literal
+	}{
+		{"?", nil},
+		{"?a", []literal{{String, "a"}}},
+		{"?\\", []literal{{String, "\\"}}},
+		{"?\\n", []literal{{String, "\\n"}}},
+		{`"abc`, []literal{{String, "abc"}}},
+		{`"abc\`, []literal{{String, "abc\\"}}},
+		{`"#{x`, []literal{{String, "#{x"}}},
+		{"'abc", []literal{{String, "abc"}}},
+		{"`id", []literal{{Command, "id"}}},
+		{"x = /ab", []literal{{Regexp, "ab"}}},
+		{":\"ab", []literal{{Symbol, "ab"}}},
+		{"x = <<~", nil},
+		{"x = <<~'EOS", []literal{{String, "EOS"}}},
+	}
+	for _, tt := range tests {
+		if got := literals(tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("literals(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+// Every prefix of a file must lex and parse without panicking
+func TestParsePrefixes(t *testing.T) {
+	src := "x = ?\\n + ?a\ny = %w(a b) + %r{c}i\nsystem(<<~`SH`, \"#{z}\")\n  curl #{url} | sh\nSH\n" +
+		"def f(a) = a % 2 ? :\"s#{a}\" : 'b\\'c'\n# done\n"
+	for i := range len(src) + 1 {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("Parse(%q) panicked: %v", src[:i], r)
+				}
+			}()
+			Parse(source(src[:i]))
+		}()
+	}
+}
@@ -0,0 +1,444 @@
+package ruby
+
+import (
+	"os"
+	"strings"
+)
+
+// File is a Ruby source file reduced to what whiskers' rules look at: the
+// method calls it makes and the literals it contains, with their context.
+// It isn't a full parse, so unusual syntax may be missed, but it never fails.
+type File struct {
+	Path  string
+	Lines []string
+	// Tokens are the code tokens of the file, without comments
+	Tokens   []Token
+	Comments []Token
+	Calls    []*Call
+	Literals []*Literal
+}
+
+// Call is a method call, e.g. `Net::HTTP.get(uri)` or `system "ls"`
+type Call struct {
+	// Receiver is the source of the receiver, e.g. "Net::HTTP" or
+	// "open(url)", or empty for calls without one
+	Receiver string
+	Name     string
+	Args     []Arg
+	// Parens is set when the arguments are parenthesized
+	Parens bool
+	// Block is set when the call is given a do...end or {...} block
+	Block bool
+	// Line and Column locate the start of the call, including its receiver
+	Line    int
+	Column  int
+	EndLine int
+
+	// argStart and argEnd are the token range of the arguments
+	argStart, argEnd int
+}
+
+// Arg is the tokens of one argument of a call
+type Arg []Token
+
+// Literal is a string or command literal and the code around it
+type Literal struct {
+	Token
+	// Calls are the calls the literal is an argument of, outermost first
+	Calls []*Call
+	// Assignment is the target of the assignment the literal is the value
+	// of, e.g. "VERSION" or "spec.homepage", or empty
+	Assignment string
+}
+
+// ParseFile reads and parses a Ruby file
+func ParseFile(path string) (*File, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := Parse(content)
+	f.Path = path
+	return f, nil
+}
+
+// Parse parses Ruby source
+func Parse(src []byte) *File {
+	f := &File{Lines: strings.Split(string(src), "\n")}
+	for _, t := range Tokenize(src) {
+		if t.Kind == Comment {
+			f.Comments = append(f.Comments, t)
+		} else {
+			f.Tokens = append(f.Tokens, t)
+		}
+	}
+	f.findCalls()
+	f.findLiterals()
+	return f
+}
+
+// Snippet returns the source lines from line to endLine, trimmed
+func (f *File) Snippet(line, endLine int) string {
+	if endLine < line {
+		endLine = line
+	}
+	if line < 1 || line > len(f.Lines) {
+		return ""
+	}
+	endLine = min(endLine, len(f.Lines))
+	return strings.TrimSpace(strings.Join(f.Lines[line-1:endLine], "\n"))
+}
+
+// Is returns true if the call has the given receiver and name. An empty
+// receiver matches calls without one, and "Kernel" also matches them.
+func (c *Call) Is(receiver, name string) bool {
+	if c.Name != name {
+		return false
+	}
+	r := strings.TrimPrefix(c.Receiver, "::")
+	return r == receiver || receiver == "Kernel" && r == ""
+}
+
+// String returns the value of an argument that is a single string literal
+// without interpolation
+func (a Arg) String() (string, bool) {
+	if len(a) == 1 && a[0].Kind == String && !a[0].Interpolated {
+		return a[0].Value, true
+	}
+	return "", false
+}
+
+// Text returns the source of an argument
+func (a Arg) Text() string {
+	return joinTokens(a)
+}
+
+// findCalls finds method calls with a receiver or arguments. A bare name
+// without either can't be told apart from a local variable, so it is skipped.
+func (f *File) findCalls() {
+	toks := f.Tokens
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		var prev Token
+		if i > 0 {
+			prev = toks[i-1]
+		}
+		afterDot := prev.Is(".") || prev.Is("&.")
+		if t.Kind != Ident && t.Kind != Constant && !(t.Kind == Keyword && afterDot) {
+			continue
+		}
+
+		// Skip definitions, e.g. `def eval(x)` and `def self.system`
+		if prev.Is("def") || prev.Is("alias") || prev.Is("undef") ||
+			afterDot && i >= 3 && toks[i-3].Is("def") {
+			continue
+		}
+
+		var next Token
+		if i+1 < len(toks) {
+			next = toks[i+1]
+		}
+		parens := next.Is("(") && !next.SpaceBefore
+		// Constants are only calls when parenthesized, e.g. URI("..."), and
+		// `Foo::Bar` is a constant path
+		if t.Kind == Constant && !parens {
+			continue
+		}
+
+		call := &Call{Name: t.Text, Line: t.Line, Column: t.Column, EndLine: t.EndLine}
+		hasReceiver := afterDot || prev.Is("::") && t.Kind == Ident
+		if hasReceiver {
+			start := receiverStart(toks, i-1)
+			call.Receiver = joinTokens(toks[start : i-1])
+			if call.Receiver == "" {
+				// The receiver is more than a chain of names and calls
+				call.Receiver = "?"
+			} else {
+				call.Line, call.Column = toks[start].Line, toks[start].Column
+			}
+		}
+
+		end := i + 1
+		switch {
+		case parens:
+			closing := matchingClose(toks, i+1)
+			call.Parens = true
+			call.argStart, call.argEnd = i+2, closing
+			end = min(closing+1, len(toks))
+		case next.SpaceBefore && startsArgument(toks, i+1):
+			call.argStart = i + 1
+			call.argEnd = commandArgsEnd(toks, i+1)
+			end = call.argEnd
+		default:
+			if !hasReceiver {
+				continue
+			}
+			call.argStart, call.argEnd = i+1, i+1
+		}
+		call.Args = splitArgs(toks[call.argStart:call.argEnd])
+		if end > 0 && end <= len(toks) {
+			call.EndLine = max(call.EndLine, toks[end-1].EndLine)
+		}
+		if end < len(toks) && (toks[end].Is("do") || toks[end].Is("{")) {
+			call.Block = true
+		}
+		f.Calls = append(f.Calls, call)
+	}
+}
+
+// findLiterals records every string and command literal with the calls it
+// is an argument of and the assignment it is the value of
+func (f *File) findLiterals() {
+	assignments := statementAssignments(f.Tokens)
+
+	var stack []*Call
+	next := 0
+	for i, t := range f.Tokens {
+		// Calls are found in order of their names, so their argument ranges
+		// open in order and nest
+		for len(stack) > 0 && i >= stack[len(stack)-1].argEnd {
+			stack = stack[:len(stack)-1]
+		}
+		for next < len(f.Calls) && f.Calls[next].argStart <= i {
+			if c := f.Calls[next]; i < c.argEnd {
+				for len(stack) > 0 && c.argStart >= stack[len(stack)-1].argEnd {
+					stack = stack[:len(stack)-1]
+				}
+				stack = append(stack, c)
+			}
+			next++
+		}
+
+		if t.Kind != String && t.Kind != Command {
+			continue
+		}
+		f.Literals = append(f.Literals, &Literal{
+			Token:      t,
+			Calls:      append([]*Call{}, stack...),
+			Assignment: assignments[i],
+		})
+	}
+}
+
+// receiverStart returns the index of the first token of the receiver whose
+// last token precedes the dot at index dot, or dot if it can't be found
+func receiverStart(toks []Token, dot int) int {
+	j := dot - 1
+	for j >= 0 {
+		t := toks[j]
+		switch {
+		case t.Is(")") || t.Is("]"):
+			j = matchingOpen(toks, j)
+			// Include the name of a call, e.g. open(url) in open(url).read
+			if j > 0 && !toks[j].SpaceBefore && (toks[j-1].Kind == Ident || toks[j-1].Kind == Constant) {
+				j--
+			}
+		case !isOperand(t):
+			return dot
+		}
+
+		// Continue along a chain such as Net::HTTP or a.b.c
+		if j > 0 && (toks[j-1].Is(".") || toks[j-1].Is("&.") || toks[j-1].Is("::")) {
+			if j < 2 || !(isOperand(toks[j-2]) || toks[j-2].Is(")") || toks[j-2].Is("]")) {
+				// A leading :: as in ::File
+				return j - 1
+			}
+			j -= 2
+			continue
+		}
+		return j
+	}
+	return dot
+}
+
+// isOperand returns true for names, variables and literals
+func isOperand(t Token) bool {
+	switch t.Kind {
+	case Ident, Constant, Variable, String, Symbol, Number, Command, Regexp:
+		return true
+	}
+	return t.Is("self")
+}
+
+// startsArgument returns true if the token at i can begin the first
+// argument of a call without parentheses, as in `system "ls"`
+func startsArgument(toks []Token, i int) bool {
+	if i >= len(toks) {
+		return false
+	}
+	t := toks[i]
+	switch t.Kind {
+	case String, Command, Symbol, Number, Ident, Constant, Variable, Regexp:
+		return true
+	case Keyword:
+		switch t.Text {
+		case "nil", "true", "false", "self", "__FILE__", "__dir__", "defined?", "not":
+			return true
+		}
+		return false
+	case Operator:
+		switch t.Text {
+		case "[", "->", "!", "::":
+			return true
+		case "*", "**", "&", "-", ":":
+			// Splats, block arguments and negative numbers, but not binary operators
+			return i+1 < len(toks) && !toks[i+1].SpaceBefore
+		}
+	}
+	return false
+}
+
+// commandArgsEnd returns the index just past the arguments of a call
+// without parentheses that start at i
+func commandArgsEnd(toks []Token, i int) int {
+	depth := 0
+	for j := i; j < len(toks); j++ {
+		t := toks[j]
+		switch {
+		case t.Kind == EOF:
+			return j
+		case t.Is("(") || t.Is("["):
+			depth++
+		case t.Is("{"):
+			// A brace after a value starts a block rather than a hash
+			if depth == 0 && j > i && endsValue(toks[j-1]) {
+				return j
+			}
+			depth++
+		case t.Is(")") || t.Is("]") || t.Is("}"):
+			if depth == 0 {
+				return j
+			}
+			depth--
+		case depth > 0:
+		case t.Kind == Newline:
+			// A trailing comma or operator continues the arguments on the next line
+			if prev := toks[j-1]; prev.Kind == Operator && !endsValue(prev) {
+				continue
+			}
+			return j
+		case t.Is(";") || t.Is("do") || t.Is("if") || t.Is("unless") || t.Is("while") ||
+			t.Is("until") || t.Is("rescue") || t.Is("and") || t.Is("or") || t.Is("then") || t.Is("end"):
+			return j
+		}
+	}
+	return len(toks)
+}
+
+// splitArgs splits argument tokens at top-level commas, dropping newlines
+func splitArgs(toks []Token) []Arg {
+	var args []Arg
+	var current Arg
+	depth := 0
+	for _, t := range toks {
+		switch {
+		case t.Kind == Newline:
+			continue
+		case t.Is("(") || t.Is("[") || t.Is("{"):
+			depth++
+		case t.Is(")") || t.Is("]") || t.Is("}"):
+			depth--
+		case t.Is(",") && depth == 0:
+			args = append(args, current)
+			current = nil
+			continue
+		}
+		current = append(current, t)
+	}
+	if len(current) > 0 {
+		args = append(args, current)
+	}
+	return args
+}
+
+// statementAssignments maps the index of each token on the right-hand side
+// of an assignment to the assignment's target
+func statementAssignments(toks []Token) map[int]string {
+	assignments := make(map[int]string)
+	start, depth := 0, 0
+	target := ""
+	for i, t := range toks {
+		switch {
+		case t.Is("(") || t.Is("["):
+			depth++
+		case t.Is(")") || t.Is("]"):
+			depth--
+		case t.Kind == Newline && depth <= 0:
+			if i > 0 && toks[i-1].Kind == Operator && !endsValue(toks[i-1]) {
+				break
+			}
+			start, depth, target = i+1, 0, ""
+			continue
+		case t.Is(";") || t.Is("do") || t.Is("then") || t.Is("{"):
+			if depth <= 0 {
+				start, depth, target = i+1, 0, ""
+				continue
+			}
+		case (t.Is("=") || t.Is("||=")) && depth == 0 && target == "":
+			target = joinTokens(toks[start:i])
+			continue
+		}
+		if target != "" {
+			assignments[i] = target
+		}
+	}
+	return assignments
+}
+
+// matchingClose returns the index of the bracket closing the one at open,
+// or the last index if it is unclosed
+func matchingClose(toks []Token, open int) int {
+	depth := 0
+	for j := open; j < len(toks); j++ {
+		switch {
+		case toks[j].Is("(") || toks[j].Is("[") || toks[j].Is("{"):
+			depth++
+		case toks[j].Is(")") || toks[j].Is("]") || toks[j].Is("}"):
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(toks) - 1
+}
+
+// matchingOpen returns the index of the bracket opening the one at close
+func matchingOpen(toks []Token, close int) int {
+	depth := 0
+	for j := close; j >= 0; j-- {
+		switch {
+		case toks[j].Is(")") || toks[j].Is("]") || toks[j].Is("}"):
+			depth++
+		case toks[j].Is("(") || toks[j].Is("[") || toks[j].Is("{"):
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return 0
+}
+
+// endsValue returns true if an operator token closes an expression
+func endsValue(t Token) bool {
+	if t.Kind != Operator {
+		return t.Kind != Newline && t.Kind != Keyword || t.Is("end") || t.Is("self") || t.Is("nil") || t.Is("true") || t.Is("false")
+	}
+	return t.Is(")") || t.Is("]") || t.Is("}")
+}
+
+// joinTokens reconstructs source from tokens, keeping the spaces between them
+func joinTokens(toks []Token) string {
+	var b strings.Builder
+	for i, t := range toks {
+		if t.Kind == Newline {
+			continue
+		}
+		if i > 0 && t.SpaceBefore {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.Text)
+	}
+	return b.String()
+}
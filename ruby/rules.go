@@ -0,0 +1,299 @@
+package ruby
+
+import (
+	"regexp"
+	"strings"
+	"whiskers/semgrep"
+)
+
+// Regular expressions used by the built-in rules
+var (
+	// Matches paths of credentials and keys
+	sensitivePathRegex = regexp.MustCompile(`\.(?:env|pem|key|crt|cer|p12|netrc)\b|\.(?:ssh|aws|gnupg)/|/\.config/|id_rsa|id_ed25519|passwords?\b|secrets?\b|credentials`)
+	// Matches long runs of base64 alphabet characters
+	base64Regex = regexp.MustCompile(`[A-Za-z0-9+/]{50,}={0,2}`)
+	// Matches URLs of protocols a payload could be fetched over
+	urlRegex = regexp.MustCompile(`\b(?:https?|s?ftp|wss?)://\S+`)
+	// Matches dotted quads standing alone or as the host of a URL, but not
+	// as part of a longer version string such as 1.2.3.4.5
+	ipRegex = regexp.MustCompile(`(?:^|[/@])((?:(?:25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9]))(?:$|[:/])`)
+	// Matches assignments of version strings, e.g. VERSION = or spec.version =
+	versionAssignmentRegex = regexp.MustCompile(`(?:^|::)VERSION$|\.version$`)
+)
+
+// Rule is a built-in rule that runs on parsed Ruby without semgrep. Each
+// has the ID of the semgrep rule whose intent it covers, so suppressions
+// and annotations apply to either.
+type Rule struct {
+	ID       string
+	Message  string
+	Severity string
+	Metadata semgrep.Metadata
+	match    func(f *File) []match
+}
+
+// match is a span of a file a rule matched
+type match struct {
+	line, column, endLine int
+}
+
+// Rules are the built-in rules
+var Rules = []*Rule{
+	{
+		ID:       "ruby-eval-usage",
+		Message:  "Dangerous eval() detected - could allow arbitrary code execution",
+		Severity: "ERROR",
+		Metadata: metadata("HIGH", "HIGH", "HIGH", "https://owasp.org/www-community/attacks/Code_Injection"),
+		match: func(f *File) []match {
+			return f.matchCalls(func(c *Call) bool {
+				return c.Is("Kernel", "eval")
+			})
+		},
+	},
+	{
+		ID:       "ruby-system-command-execution",
+		Message:  "Dangerous shell command execution detected",
+		Severity: "ERROR",
+		Metadata: metadata("HIGH", "HIGH", "MEDIUM",
+			"https://ruby-doc.org/core/Kernel.html#method-i-system",
+			"https://ruby-doc.org/core/Kernel.html#method-i-exec"),
+		match: func(f *File) []match {
+			matches := f.matchCalls(func(c *Call) bool {
+				return c.Is("Kernel", "system") || c.Is("Kernel", "exec") || c.Is("Kernel", "spawn") ||
+					c.Is("IO", "popen") || c.Receiver == "Open3" && strings.HasPrefix(c.Name, "capture") ||
+					c.Receiver == "Open3" && strings.HasPrefix(c.Name, "popen")
+			})
+			return append(matches, f.matchLiterals(func(l *Literal) bool {
+				return l.Kind == Command
+			})...)
+		},
+	},
+	{
+		ID:       "ruby-sensitive-file-read",
+		Message:  "Reading potentially sensitive files",
+		Severity: "WARNING",
+		Metadata: metadata("MEDIUM", "HIGH", "MEDIUM"),
+		match: func(f *File) []match {
+			var matches []match
+			for _, l := range f.Literals {
+				if l.Kind != String || !sensitivePathRegex.MatchString(l.Value) {
+					continue
+				}
+				for _, c := range l.Calls {
+					if isFileRead(c) && len(c.Args) > 0 && contains(c.Args[0], l.Token) {
+						matches = append(matches, match{c.Line, c.Column, c.EndLine})
+						break
+					}
+				}
+			}
+			return matches
+		},
+	},
+	{
+		ID:       "ruby-system-info-access",
+		Message:  "Accessing system or environment information",
+		Severity: "WARNING",
+		Metadata: metadata("MEDIUM", "MEDIUM", "MEDIUM"),
+		match: func(f *File) []match {
+			matches := f.matchCalls(func(c *Call) bool {
+				if c.Is("File", "expand_path") && len(c.Args) == 1 {
+					value, ok := c.Args[0].String()
+					return ok && value == "~"
+				}
+				return c.Is("Socket", "gethostname") || c.Is("Process", "uid") || c.Is("Process", "gid") ||
+					c.Is("Dir", "home") || c.Is("Etc", "getlogin")
+			})
+			return append(matches, f.matchLiterals(func(l *Literal) bool {
+				command := strings.TrimSpace(l.Value)
+				return l.Kind == Command && (command == "hostname" || command == "whoami" || command == "id")
+			})...)
+		},
+	},
+	{
+		ID:       "ruby-hardcoded-base64",
+		Message:  "Detected hardcoded Base64 data - potential obfuscated payload",
+		Severity: "WARNING",
+		Metadata: metadata("MEDIUM", "HIGH", "MEDIUM"),
+		match: func(f *File) []match {
+			return f.matchLiterals(func(l *Literal) bool {
+				return l.Kind == String && base64Regex.MatchString(l.Value)
+			})
+		},
+	},
+	{
+		ID:       "ruby-base64-usage",
+		Message:  "Base64 encoding/decoding detected - potential data obfuscation",
+		Severity: "INFO",
+		Metadata: metadata("LOW", "MEDIUM", "LOW"),
+		match: func(f *File) []match {
+			return f.matchCalls(func(c *Call) bool {
+				if c.Receiver != "Base64" && c.Receiver != "::Base64" {
+					return false
+				}
+				switch c.Name {
+				case "encode64", "strict_encode64", "urlsafe_encode64", "decode64", "strict_decode64", "urlsafe_decode64":
+					return true
+				}
+				return false
+			})
+		},
+	},
+	{
+		ID:       "ruby-hardcoded-urls",
+		Message:  "Hardcoded URL detected - potential security risk for remote payload downloads",
+		Severity: "WARNING",
+		Metadata: metadata("MEDIUM", "HIGH", "MEDIUM",
+			"https://owasp.org/www-project-top-ten/2017/A9_2017-Using_Components_with_Known_Vulnerabilities"),
+		match: func(f *File) []match {
+			return f.matchLiterals(func(l *Literal) bool {
+				if !urlRegex.MatchString(l.Value) {
+					return false
+				}
+				// Gem metadata and gem sources are expected to name URLs
+				if strings.HasSuffix(l.Assignment, ".homepage") || strings.Contains(l.Assignment, ".metadata[") {
+					return false
+				}
+				return !l.inCall(func(c *Call) bool { return c.Is("", "source") })
+			})
+		},
+	},
+	{
+		ID:       "ruby-hardcoded-ip-addresses",
+		Message:  "Hardcoded IP address detected - potential security risk for remote connections",
+		Severity: "WARNING",
+		Metadata: metadata("MEDIUM", "HIGH", "MEDIUM"),
+		match: func(f *File) []match {
+			return f.matchLiterals(func(l *Literal) bool {
+				if l.Kind != String || versionAssignmentRegex.MatchString(l.Assignment) || l.inCall(isVersionCall) {
+					return false
+				}
+				for _, m := range ipRegex.FindAllStringSubmatch(l.Value, -1) {
+					if m[1] != "127.0.0.1" && m[1] != "0.0.0.0" {
+						return true
+					}
+				}
+				return false
+			})
+		},
+	},
+	{
+		ID:       "ruby-suspicious-download-patterns",
+		Message:  "Suspicious download pattern detected - potential remote code download",
+		Severity: "WARNING",
+		Metadata: metadata("MEDIUM", "HIGH", "MEDIUM",
+			"https://ruby-doc.org/stdlib-2.7.0/libdoc/net/http/rdoc/Net/HTTP.html",
+			"https://ruby-doc.org/stdlib-2.7.0/libdoc/open-uri/rdoc/OpenURI.html"),
+		match: func(f *File) []match {
+			return f.matchCalls(func(c *Call) bool {
+				if len(c.Args) == 0 {
+					return false
+				}
+				if c.Is("Net::HTTP", "get") || c.Is("Net::HTTP", "get_response") || c.Is("URI", "open") || c.Is("OpenURI", "open_uri") {
+					return true
+				}
+				// Kernel#open fetches URLs once open-uri is loaded, and runs
+				// commands given as "|cmd"; opening a named local file is fine
+				if c.Is("Kernel", "open") {
+					path, ok := c.Args[0].String()
+					return !ok || urlRegex.MatchString(path) || strings.HasPrefix(path, "|")
+				}
+				return false
+			})
+		},
+	},
+}
+
+// Scan runs the rules over a parsed file
+func Scan(f *File, rules []*Rule) []*semgrep.Finding {
+	findings := make([]*semgrep.Finding, 0)
+	for _, rule := range rules {
+		for _, m := range rule.match(f) {
+			findings = append(findings, &semgrep.Finding{
+				RuleID:   rule.ID,
+				Message:  rule.Message,
+				Lines:    f.Snippet(m.line, m.endLine),
+				Line:     m.line,
+				Column:   m.column,
+				EndLine:  m.endLine,
+				Path:     f.Path,
+				Severity: rule.Severity,
+				Metadata: rule.Metadata,
+			})
+		}
+	}
+	return findings
+}
+
+// matchCalls returns the calls matching fn
+func (f *File) matchCalls(fn func(c *Call) bool) []match {
+	var matches []match
+	for _, c := range f.Calls {
+		if fn(c) {
+			matches = append(matches, match{c.Line, c.Column, c.EndLine})
+		}
+	}
+	return matches
+}
+
+// matchLiterals returns the literals matching fn
+func (f *File) matchLiterals(fn func(l *Literal) bool) []match {
+	var matches []match
+	for _, l := range f.Literals {
+		if fn(l) {
+			matches = append(matches, match{l.Line, l.Column, l.EndLine})
+		}
+	}
+	return matches
+}
+
+// inCall returns true if the literal is an argument of a call matching fn
+func (l *Literal) inCall(fn func(c *Call) bool) bool {
+	for _, c := range l.Calls {
+		if fn(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFileRead returns true for calls that read a file named by their first argument
+func isFileRead(c *Call) bool {
+	if c.Receiver != "File" && c.Receiver != "IO" && c.Receiver != "::File" && c.Receiver != "::IO" {
+		return false
+	}
+	switch c.Name {
+	case "read", "readlines", "binread", "open", "foreach":
+		return true
+	}
+	return false
+}
+
+// isVersionCall returns true for calls whose arguments are gem versions or requirements
+func isVersionCall(c *Call) bool {
+	switch c.Name {
+	case "gem", "add_dependency", "add_runtime_dependency", "add_development_dependency":
+		return true
+	}
+	return c.Is("Gem::Version", "new") || c.Is("Gem::Requirement", "new") || c.Is("Gem::Dependency", "new")
+}
+
+// contains returns true if the token is part of the argument
+func contains(arg Arg, t Token) bool {
+	for _, a := range arg {
+		if a.Line == t.Line && a.Column == t.Column {
+			return true
+		}
+	}
+	return false
+}
+
+// metadata builds the metadata of a built-in rule
+func metadata(confidence, impact, likelihood string, references ...string) semgrep.Metadata {
+	return semgrep.Metadata{
+		Category:   "security",
+		Confidence: confidence,
+		Impact:     impact,
+		Likelihood: likelihood,
+		References: references,
+	}
+}
@@ -0,0 +1,313 @@
+package rules
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"whiskers/semgrep"
+)
+
+// fixtures holds the annotated Ruby snippets `rules test` runs the built-in rules against
+//
+//go:embed fixtures
+var fixtures embed.FS
+
+// Expectation kinds, written as comments in fixture files
+const (
+	// ExpectMatch ("# ruleid: <rule>") means the rule must match the line
+	ExpectMatch = "ruleid"
+	// ExpectNoMatch ("# ok: <rule>") means the rule must not match the line
+	ExpectNoMatch = "ok"
+	// KnownMiss ("# todoruleid: <rule>") should match but doesn't yet
+	KnownMiss = "todoruleid"
+	// KnownFalsePositive ("# todook: <rule>") shouldn't match but does
+	KnownFalsePositive = "todook"
+)
+
+// Matches an expectation comment, e.g. "# ruleid: ruby-eval-usage, ruby-base64-usage"
+var expectationRegex = regexp.MustCompile(`#\s*(todoruleid|todook|ruleid|ok):\s*([\w.\-]+(?:\s*,\s*[\w.\-]+)*)\s*$`)
+
+// Expectation is what a fixture says about a rule on one line
+type Expectation struct {
+	// Path is relative to the fixtures directory
+	Path string
+	Line int
+	Rule string
+	Kind string
+}
+
+// Fixtures is a directory of Ruby files annotated with expectations
+type Fixtures struct {
+	Dir          string
+	Files        []string
+	Expectations []Expectation
+
+	// temp is set when Dir holds the embedded fixtures and must be removed
+	temp bool
+}
+
+// RuleStats counts the outcomes of a rule's expectations. Findings on lines
+// without an expectation for the rule count as false positives.
+type RuleStats struct {
+	ID             string
+	TruePositives  int
+	FalsePositives int
+	FalseNegatives int
+	TrueNegatives  int
+}
+
+// Failure is an expectation the rules didn't meet, or an unexpected match
+type Failure struct {
+	Path    string
+	Line    int
+	Rule    string
+	Message string
+}
+
+// TestReport is the outcome of running a rule set against fixtures
+type TestReport struct {
+	Rules    []*RuleStats
+	Failures []Failure
+}
+
+// LoadFixtures reads the fixtures in dir, or the built-in fixtures if dir is
+// empty. The caller must Close the fixtures.
+func LoadFixtures(dir string) (*Fixtures, error) {
+	f := &Fixtures{Dir: dir}
+	if dir == "" {
+		var err error
+		if f.Dir, err = extractFixtures(); err != nil {
+			return nil, err
+		}
+		f.temp = true
+	}
+
+	err := filepath.WalkDir(f.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != f.Dir && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".rb" {
+			return nil
+		}
+
+		expectations, err := parseExpectations(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(f.Dir, path)
+		for i := range expectations {
+			expectations[i].Path = rel
+		}
+		f.Files = append(f.Files, path)
+		f.Expectations = append(f.Expectations, expectations...)
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read fixtures: %w", err)
+	}
+	if len(f.Files) == 0 {
+		f.Close()
+		return nil, fmt.Errorf("no Ruby fixtures found in %s", f.Dir)
+	}
+	return f, nil
+}
+
+// Close removes the extracted built-in fixtures
+func (f *Fixtures) Close() error {
+	if !f.temp {
+		return nil
+	}
+	return os.RemoveAll(f.Dir)
+}
+
+// Evaluate compares the findings of a scan of the fixtures with their
+// expectations, for the rules in the set. Expectations for other rules are
+// ignored, so fixtures can cover more rules than a set has.
+func (f *Fixtures) Evaluate(set *Set, findings []*semgrep.Finding) *TestReport {
+	report := &TestReport{}
+	stats := make(map[string]*RuleStats)
+	for _, id := range set.IDs() {
+		if _, ok := stats[id]; !ok {
+			stats[id] = &RuleStats{ID: id}
+			report.Rules = append(report.Rules, stats[id])
+		}
+	}
+
+	// Index the findings by rule, file and line
+	matched := make(map[string]bool)
+	for _, finding := range findings {
+		matched[expectationKey(finding.RuleID, f.relPath(finding.Path), finding.Line)] = true
+	}
+
+	expected := make(map[string]bool)
+	for _, e := range f.Expectations {
+		s, ok := stats[e.Rule]
+		if !ok {
+			continue
+		}
+		key := expectationKey(e.Rule, e.Path, e.Line)
+		expected[key] = true
+		hit := matched[key]
+
+		switch {
+		case e.Kind == ExpectMatch && hit, e.Kind == KnownMiss && hit:
+			s.TruePositives++
+		case e.Kind == ExpectMatch:
+			s.FalseNegatives++
+			report.fail(e, "expected a match")
+		case e.Kind == KnownMiss:
+			s.FalseNegatives++
+		case e.Kind == ExpectNoMatch && hit:
+			s.FalsePositives++
+			report.fail(e, "unexpected match")
+		case e.Kind == KnownFalsePositive && hit:
+			s.FalsePositives++
+		default:
+			s.TrueNegatives++
+		}
+	}
+
+	// Findings with no expectation at all are false positives too
+	reported := make(map[string]bool)
+	for _, finding := range findings {
+		path := f.relPath(finding.Path)
+		key := expectationKey(finding.RuleID, path, finding.Line)
+		s, ok := stats[finding.RuleID]
+		if !ok || expected[key] || reported[key] {
+			continue
+		}
+		reported[key] = true
+		s.FalsePositives++
+		report.fail(Expectation{Path: path, Line: finding.Line, Rule: finding.RuleID}, "unexpected match")
+	}
+
+	sort.Slice(report.Failures, func(i, j int) bool {
+		a, b := report.Failures[i], report.Failures[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Rule < b.Rule
+	})
+	return report
+}
+
+// Precision returns the share of the rule's matches that were expected, and
+// false if the rule matched nothing
+func (s *RuleStats) Precision() (float64, bool) {
+	if s.TruePositives+s.FalsePositives == 0 {
+		return 0, false
+	}
+	return float64(s.TruePositives) / float64(s.TruePositives+s.FalsePositives), true
+}
+
+// Recall returns the share of expected matches the rule found, and false if
+// no fixture expects the rule to match
+func (s *RuleStats) Recall() (float64, bool) {
+	if s.TruePositives+s.FalseNegatives == 0 {
+		return 0, false
+	}
+	return float64(s.TruePositives) / float64(s.TruePositives+s.FalseNegatives), true
+}
+
+// fail records a failed expectation
+func (r *TestReport) fail(e Expectation, message string) {
+	r.Failures = append(r.Failures, Failure{Path: e.Path, Line: e.Line, Rule: e.Rule, Message: message})
+}
+
+// relPath returns a finding's path relative to the fixtures directory
+func (f *Fixtures) relPath(path string) string {
+	if rel, err := filepath.Rel(f.Dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// expectationKey identifies a rule on a line of a fixture
+func expectationKey(rule, path string, line int) string {
+	return fmt.Sprintf("%s\x00%s\x00%d", rule, path, line)
+}
+
+// parseExpectations reads the expectation comments of a fixture file. A
+// comment on its own line applies to the next line of code, like a
+// whiskers:ignore annotation; a trailing comment applies to its own line.
+func parseExpectations(path string) ([]Expectation, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var expectations []Expectation
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		match := expectationRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		target := i + 1
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			target = 0
+			for j := i + 1; j < len(lines); j++ {
+				if code := strings.TrimSpace(lines[j]); code != "" && !strings.HasPrefix(code, "#") {
+					target = j + 1
+					break
+				}
+			}
+			if target == 0 {
+				return nil, fmt.Errorf("%s:%d: expectation is not followed by code", path, i+1)
+			}
+		}
+
+		for _, rule := range strings.Split(match[2], ",") {
+			expectations = append(expectations, Expectation{
+				Line: target,
+				Rule: strings.TrimSpace(rule),
+				Kind: match[1],
+			})
+		}
+	}
+	return expectations, nil
+}
+
+// extractFixtures writes the embedded fixtures to a temporary directory semgrep can read
+func extractFixtures() (string, error) {
+	dir, err := os.MkdirTemp("", "whiskers-fixtures-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create fixtures directory: %w", err)
+	}
+
+	err = fs.WalkDir(fixtures, "fixtures", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fixtures, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, strings.TrimPrefix(path, "fixtures/"))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, 0644)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to extract fixtures: %w", err)
+	}
+	return dir, nil
+}
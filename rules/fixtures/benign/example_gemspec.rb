@@ -0,0 +1,27 @@
+# A typical gemspec: version strings and metadata URLs are not suspicious.
+require_relative "lib/example/version"
+
+Gem::Specification.new do |spec|
+  spec.name = "example"
+  # ok: ruby-hardcoded-ip-addresses
+  spec.version = "4.2.11.3"
+  spec.authors = ["Example Maintainers"]
+  spec.summary = "An example gem"
+  # ok: ruby-hardcoded-urls
+  spec.homepage = "https://github.com/example/example"
+  spec.license = "MIT"
+  # ok: ruby-hardcoded-urls
+  spec.metadata["source_code_uri"] = "https://github.com/example/example"
+  # ok: ruby-hardcoded-urls
+  spec.metadata["changelog_uri"] = "https://github.com/example/example/blob/main/CHANGELOG.md"
+
+  spec.files = Dir["lib/**/*.rb", "README.md", "LICENSE.txt"]
+  spec.require_paths = ["lib"]
+
+  # ok: ruby-hardcoded-ip-addresses
+  spec.add_dependency "activesupport", ">= 4.2.11.3"
+  # ok: ruby-hardcoded-ip-addresses
+  spec.add_runtime_dependency "nokogiri", "~> 1.10.4.1"
+  # ok: ruby-hardcoded-ip-addresses
+  spec.add_development_dependency "rake", ">= 12.3.3.0"
+end
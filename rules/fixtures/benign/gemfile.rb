@@ -0,0 +1,9 @@
+# A Gemfile evaluated as Ruby, as Bundler does.
+# ok: ruby-hardcoded-urls
+source "https://rubygems.org"
+
+# ok: ruby-hardcoded-ip-addresses
+gem 'rails', '4.2.11.3'
+# ok: ruby-hardcoded-ip-addresses
+gem 'nokogiri', '>= 1.10.4.1', '< 2'
+gem 'puma'
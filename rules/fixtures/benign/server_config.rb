@@ -0,0 +1,25 @@
+# Server defaults and local file reads of the kind found in web frameworks.
+module Example
+  class Config
+    # ok: ruby-hardcoded-ip-addresses
+    DEFAULT_HOST = "127.0.0.1"
+    # ok: ruby-hardcoded-ip-addresses
+    BIND_ALL = "0.0.0.0"
+    DEFAULT_PORT = 3000
+
+    def readme
+      # ok: ruby-sensitive-file-read
+      File.read("README.md")
+    end
+
+    def load_settings(root)
+      # ok: ruby-sensitive-file-read
+      YAML.safe_load(File.read(File.join(root, "config/settings.yml")))
+    end
+
+    def basic_auth(user, password)
+      # todook: ruby-base64-usage
+      "Basic " + Base64.strict_encode64("#{user}:#{password}")
+    end
+  end
+end
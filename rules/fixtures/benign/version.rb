@@ -0,0 +1,18 @@
+# Version constants and comparisons use dotted quads that look like IP addresses.
+module Example
+  # ok: ruby-hardcoded-ip-addresses
+  VERSION = "1.2.3.4"
+
+  def self.legacy?
+    # ok: ruby-hardcoded-ip-addresses
+    Gem::Version.new(RUBY_VERSION) < Gem::Version.new("2.7.0.0")
+  end
+
+  def self.supported?(version)
+    # ok: ruby-hardcoded-ip-addresses
+    Gem::Requirement.new(">= 1.0.0.0").satisfied_by?(Gem::Version.new(version))
+  end
+end
+
+# ok: ruby-hardcoded-ip-addresses
+Example::VERSION = "1.2.3.4" unless defined?(Example::VERSION)
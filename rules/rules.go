@@ -0,0 +1,357 @@
+package rules
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"whiskers/semgrep"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BuiltinPack is the name of the rule pack embedded in the binary
+const BuiltinPack = "builtin"
+
+// builtin holds the default rule pack, so release binaries work from any directory
+//
+//go:embed builtin/*.yaml
+var builtin embed.FS
+
+// Rule is a semgrep rule as listed by `rules list`
+type Rule struct {
+	ID       string           `json:"id"`
+	Severity string           `json:"severity"`
+	Message  string           `json:"message"`
+	Metadata semgrep.Metadata `json:"metadata"`
+	// Pack is the name of the rule pack the rule belongs to
+	Pack string `json:"pack"`
+	// Source is "builtin" or the rule file the rule was loaded from
+	Source string `json:"source"`
+}
+
+// PackInfo records exactly which version of a rule pack a scan used
+type PackInfo struct {
+	Name string `yaml:"name" json:"name"`
+	// Source is "builtin", a rules directory or a git checkout
+	Source string `yaml:"source" json:"source"`
+	Ref    string `yaml:"ref,omitempty" json:"ref,omitempty"`
+	Commit string `yaml:"commit,omitempty" json:"commit,omitempty"`
+	// Digest is the SHA-256 of the pack's rule files, before overrides are applied
+	Digest string `yaml:"digest" json:"digest"`
+}
+
+// Set is a collection of rule packs materialized into a directory semgrep can read
+type Set struct {
+	Dir   string
+	Rules []Rule
+	Packs []PackInfo
+}
+
+// Load materializes the rule packs of config into a temporary directory,
+// applying its enable and disable lists and severity overrides. If lock is
+// given, git packs are checked out at their locked commit and every pack
+// listed in the config must match its locked digest. The caller must Close
+// the set to remove the directory.
+func Load(config *Config, lock *Lock) (*Set, error) {
+	if len(config.Packs) == 0 {
+		return nil, fmt.Errorf("no rule packs configured")
+	}
+
+	dir, err := os.MkdirTemp("", "whiskers-rules-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rules directory: %w", err)
+	}
+	set := &Set{Dir: dir}
+
+	for _, pack := range config.Packs {
+		if err := set.addPack(config, pack, lock); err != nil {
+			set.Close()
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(set.Rules, func(i, j int) bool {
+		return set.Rules[i].ID < set.Rules[j].ID
+	})
+	return set, nil
+}
+
+// addPack reads a rule pack, checks it against the lock and writes its rules into the set
+func (s *Set) addPack(config *Config, pack Pack, lock *Lock) error {
+	info := PackInfo{Name: pack.Name, Ref: pack.Ref}
+	var locked *PackInfo
+	if lock != nil && !pack.adhoc {
+		if locked = lock.pack(pack.Name); locked == nil {
+			return fmt.Errorf("rule pack %s is not in the lock file, run `whiskers rules lock`", pack.Name)
+		}
+	}
+
+	var files map[string][]byte
+	var err error
+	switch {
+	case pack.isBuiltin():
+		info.Source = BuiltinPack
+		var sub fs.FS
+		if sub, err = fs.Sub(builtin, "builtin"); err == nil {
+			files, err = readRuleFiles(sub, ".")
+		}
+	case pack.Git != "":
+		info.Source = pack.Git
+		ref := pack.Ref
+		if locked != nil && locked.Commit != "" {
+			ref = locked.Commit
+		}
+		files, info.Commit, err = readGitPack(pack.Git, ref, pack.Path)
+	default:
+		info.Source = pack.Path
+		files, err = readPath(pack.Path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load rule pack %s: %w", pack.Name, err)
+	}
+
+	info.Digest = digest(files)
+	if locked != nil && locked.Digest != info.Digest {
+		return fmt.Errorf("rule pack %s has changed since it was locked (%s, locked %s), run `whiskers rules lock`",
+			pack.Name, shortDigest(info.Digest), shortDigest(locked.Digest))
+	}
+	s.Packs = append(s.Packs, info)
+
+	for _, path := range sortedKeys(files) {
+		source := BuiltinPack
+		switch {
+		case pack.Git != "":
+			source = filepath.Join(pack.Git, pack.Path, path)
+		case isRuleFile(pack.Path):
+			source = pack.Path
+		case !pack.isBuiltin():
+			source = filepath.Join(pack.Path, path)
+		}
+		if err := s.addFile(config, pack.Name, source, path, files[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFile applies the config's overrides to a rule file and writes it into
+// a subdirectory of the set named after its pack
+func (s *Set) addFile(config *Config, packName, source, path string, content []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("failed to parse rule file %s: %w", source, err)
+	}
+	rulesNode := mappingValue(documentRoot(&doc), "rules")
+	if rulesNode == nil || rulesNode.Kind != yaml.SequenceNode {
+		// Not a rule file, e.g. a CI config in a rules checkout
+		return nil
+	}
+
+	kept := make([]*yaml.Node, 0, len(rulesNode.Content))
+	for _, ruleNode := range rulesNode.Content {
+		id := scalarValue(mappingValue(ruleNode, "id"))
+		if !config.enabled(id) {
+			continue
+		}
+
+		severityNode := mappingValue(ruleNode, "severity")
+		if severity, ok := config.Severity[id]; ok && severityNode != nil {
+			severityNode.Value = strings.ToUpper(severity)
+		}
+
+		kept = append(kept, ruleNode)
+		s.Rules = append(s.Rules, Rule{
+			ID:       id,
+			Severity: strings.ToUpper(scalarValue(severityNode)),
+			Message:  strings.TrimSpace(scalarValue(mappingValue(ruleNode, "message"))),
+			Metadata: decodeMetadata(mappingValue(ruleNode, "metadata")),
+			Pack:     packName,
+			Source:   source,
+		})
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	rulesNode.Content = kept
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode rule file %s: %w", source, err)
+	}
+	target := filepath.Join(s.Dir, packName, path)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create rules directory: %w", err)
+	}
+	if err := os.WriteFile(target, out, 0644); err != nil {
+		return fmt.Errorf("failed to write rule file: %w", err)
+	}
+	return nil
+}
+
+// IDs returns the IDs of every rule in the set
+func (s *Set) IDs() []string {
+	ids := make([]string, 0, len(s.Rules))
+	for _, r := range s.Rules {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+// Lock returns a lock recording the packs of the set
+func (s *Set) Lock() *Lock {
+	return &Lock{Packs: append([]PackInfo{}, s.Packs...)}
+}
+
+// Describe returns a one-line summary of the packs in the set, e.g.
+// "builtin (sha256:1a2b3c4d5e6f), team (sha256:..., commit 0abc123)"
+func (s *Set) Describe() string {
+	parts := make([]string, 0, len(s.Packs))
+	for _, p := range s.Packs {
+		part := fmt.Sprintf("%s (%s", p.Name, shortDigest(p.Digest))
+		if p.Commit != "" {
+			part += ", commit " + p.Commit[:min(len(p.Commit), 12)]
+		}
+		parts = append(parts, part+")")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Close removes the materialized rules directory
+func (s *Set) Close() error {
+	return os.RemoveAll(s.Dir)
+}
+
+// readPath reads the rule files of a directory, or a single rule file
+func readPath(path string) (map[string][]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{filepath.Base(path): content}, nil
+	}
+	return readRuleFiles(os.DirFS(path), ".")
+}
+
+// readRuleFiles reads every YAML file under root in fsys, keyed by path
+func readRuleFiles(fsys fs.FS, root string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Skip VCS metadata and other hidden directories
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !isRuleFile(path) {
+			return nil
+		}
+
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		files[path] = content
+		return nil
+	})
+	return files, err
+}
+
+// digest hashes the paths and contents of a pack's files in a stable order
+func digest(files map[string][]byte) string {
+	hash := sha256.New()
+	for _, path := range sortedKeys(files) {
+		hash.Write([]byte(filepath.ToSlash(path)))
+		hash.Write([]byte{0})
+		hash.Write(files[path])
+		hash.Write([]byte{0})
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// shortDigest abbreviates a digest for display
+func shortDigest(digest string) string {
+	if len(digest) > len("sha256:")+12 {
+		return digest[:len("sha256:")+12]
+	}
+	return digest
+}
+
+// sortedKeys returns the keys of files in order
+func sortedKeys(files map[string][]byte) []string {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// documentRoot returns the top-level node of a YAML document
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		return doc.Content[0]
+	}
+	return doc
+}
+
+// mappingValue returns the value of key in a YAML mapping, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// scalarValue returns the value of a scalar YAML node, or an empty string
+func scalarValue(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
+
+// decodeMetadata reads a rule's metadata block the way semgrep reports it
+// in findings, ignoring metadata it can't decode
+func decodeMetadata(node *yaml.Node) semgrep.Metadata {
+	var metadata semgrep.Metadata
+	if node == nil {
+		return metadata
+	}
+	var raw interface{}
+	if err := node.Decode(&raw); err != nil {
+		return metadata
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return metadata
+	}
+	json.Unmarshal(data, &metadata)
+	return metadata
+}
+
+// isRuleFile returns true for YAML files
+func isRuleFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
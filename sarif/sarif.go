@@ -0,0 +1,176 @@
+package sarif
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Version and Schema identify the SARIF format whiskers reads and writes
+const (
+	Version = "2.1.0"
+	Schema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// Log is the top-level object of a SARIF file
+type Log struct {
+	Schema  string `json:"$schema,omitempty"`
+	Version string `json:"version"`
+	Runs    []Run  `json:"runs"`
+}
+
+// Run is the output of a single analysis tool
+type Run struct {
+	Tool        Tool         `json:"tool"`
+	Invocations []Invocation `json:"invocations,omitempty"`
+	// OriginalURIBaseIDs resolves the uriBaseId of artifact locations
+	OriginalURIBaseIDs map[string]ArtifactLocation `json:"originalUriBaseIds,omitempty"`
+	Artifacts          []Artifact                  `json:"artifacts,omitempty"`
+	Results            []Result                    `json:"results"`
+	Properties         map[string]interface{}      `json:"properties,omitempty"`
+}
+
+// Tool describes the analyzer that produced a run
+type Tool struct {
+	Driver ToolComponent `json:"driver"`
+}
+
+// ToolComponent is the analyzer itself and the rules it ran
+type ToolComponent struct {
+	Name           string                `json:"name"`
+	Version        string                `json:"version,omitempty"`
+	InformationURI string                `json:"informationUri,omitempty"`
+	Rules          []ReportingDescriptor `json:"rules,omitempty"`
+}
+
+// ReportingDescriptor describes a rule
+type ReportingDescriptor struct {
+	ID                   string                 `json:"id"`
+	Name                 string                 `json:"name,omitempty"`
+	ShortDescription     *Message               `json:"shortDescription,omitempty"`
+	FullDescription      *Message               `json:"fullDescription,omitempty"`
+	Help                 *Message               `json:"help,omitempty"`
+	HelpURI              string                 `json:"helpUri,omitempty"`
+	DefaultConfiguration *Configuration         `json:"defaultConfiguration,omitempty"`
+	Properties           map[string]interface{} `json:"properties,omitempty"`
+}
+
+// Configuration holds a rule's default level
+type Configuration struct {
+	Level string `json:"level,omitempty"`
+}
+
+// Invocation records how a run went, including errors that left files unscanned
+type Invocation struct {
+	ExecutionSuccessful        bool           `json:"executionSuccessful"`
+	ToolExecutionNotifications []Notification `json:"toolExecutionNotifications,omitempty"`
+}
+
+// Notification is a message from the tool about the run rather than the code
+type Notification struct {
+	Level     string     `json:"level,omitempty"`
+	Message   Message    `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+}
+
+// Artifact is a file the run analyzed
+type Artifact struct {
+	Location   ArtifactLocation       `json:"location"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// Result is a single finding
+type Result struct {
+	RuleID              string                 `json:"ruleId"`
+	RuleIndex           *int                   `json:"ruleIndex,omitempty"`
+	Level               string                 `json:"level,omitempty"`
+	Message             Message                `json:"message"`
+	Locations           []Location             `json:"locations,omitempty"`
+	PartialFingerprints map[string]string      `json:"partialFingerprints,omitempty"`
+	Properties          map[string]interface{} `json:"properties,omitempty"`
+}
+
+// Message is plain text, optionally with markdown
+type Message struct {
+	Text     string `json:"text"`
+	Markdown string `json:"markdown,omitempty"`
+}
+
+// Location points at a region of a file
+type Location struct {
+	PhysicalLocation PhysicalLocation `json:"physicalLocation"`
+}
+
+// PhysicalLocation is a file and a region within it
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           *Region          `json:"region,omitempty"`
+}
+
+// ArtifactLocation is a file URI, relative to the base URI named by URIBaseID if set
+type ArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+// Region is a span of lines and columns, both 1-based
+type Region struct {
+	StartLine   int      `json:"startLine,omitempty"`
+	StartColumn int      `json:"startColumn,omitempty"`
+	EndLine     int      `json:"endLine,omitempty"`
+	EndColumn   int      `json:"endColumn,omitempty"`
+	Snippet     *Message `json:"snippet,omitempty"`
+}
+
+// Parse decodes a SARIF log
+func Parse(data []byte) (*Log, error) {
+	var log Log
+	if err := json.Unmarshal(data, &log); err != nil {
+		return nil, fmt.Errorf("failed to parse SARIF: %w", err)
+	}
+	if log.Version != "" && log.Version != Version {
+		return nil, fmt.Errorf("unsupported SARIF version %s", log.Version)
+	}
+	return &log, nil
+}
+
+// Severity converts a SARIF level to a semgrep severity. Results without a
+// level default to "warning", as the specification says.
+func Severity(level string) string {
+	switch level {
+	case "error":
+		return "ERROR"
+	case "note", "none":
+		return "INFO"
+	default:
+		return "WARNING"
+	}
+}
+
+// Level converts a semgrep severity to a SARIF level
+func Level(severity string) string {
+	switch severity {
+	case "ERROR":
+		return "error"
+	case "INFO":
+		return "note"
+	default:
+		return "warning"
+	}
+}
+
+// RuleLevel returns the default level of the rule a result refers to, if the run describes it
+func (r *Run) RuleLevel(result Result) string {
+	rules := r.Tool.Driver.Rules
+	if result.RuleIndex != nil && *result.RuleIndex >= 0 && *result.RuleIndex < len(rules) {
+		if config := rules[*result.RuleIndex].DefaultConfiguration; config != nil {
+			return config.Level
+		}
+		return ""
+	}
+	for _, rule := range rules {
+		if rule.ID == result.RuleID && rule.DefaultConfiguration != nil {
+			return rule.DefaultConfiguration.Level
+		}
+	}
+	return ""
+}
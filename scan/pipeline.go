@@ -0,0 +1,213 @@
+package scan
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"whiskers/gem"
+	"whiskers/semgrep"
+)
+
+// Stage is a step of the scan pipeline reported through Options.Progress
+type Stage string
+
+// Pipeline stages, in the order a change moves through them
+const (
+	StageQueued      Stage = "queued"
+	StageDownloading Stage = "downloading"
+	StageExtracting  Stage = "extracting"
+	StageScanning    Stage = "scanning"
+	StageDone        Stage = "done"
+	StageFailed      Stage = "failed"
+)
+
+// Concurrency bounds the number of concurrent downloads, extractions and
+// scanner invocations. Zero values fall back to the defaults.
+type Concurrency struct {
+	Downloads   int
+	Extractions int
+	Scans       int
+}
+
+// DefaultConcurrency is used for any stage without an explicit limit
+var DefaultConcurrency = Concurrency{
+	Downloads:   4,
+	Extractions: 4,
+	Scans:       2,
+}
+
+// withDefaults fills in unset limits from DefaultConcurrency
+func (c Concurrency) withDefaults() Concurrency {
+	if c.Downloads <= 0 {
+		c.Downloads = DefaultConcurrency.Downloads
+	}
+	if c.Extractions <= 0 {
+		c.Extractions = DefaultConcurrency.Extractions
+	}
+	if c.Scans <= 0 {
+		c.Scans = DefaultConcurrency.Scans
+	}
+	return c
+}
+
+// ScanChanges scans every change concurrently, bounded by the configured
+// worker counts. Results are returned in the same order as changes, each
+// carrying its own buffered log and any error that stopped it, so one
+// failing gem doesn't affect the rest.
+func (s *Scanner) ScanChanges(changes []gem.VersionChange) []*Result {
+	if s.opts.Batch {
+		return s.scanBatch(changes)
+	}
+
+	results := make([]*Result, len(changes))
+
+	var wg sync.WaitGroup
+	for i, change := range changes {
+		s.opts.Progress(change, StageQueued)
+
+		wg.Add(1)
+		go func(i int, change gem.VersionChange) {
+			defer wg.Done()
+
+			// Buffer progress messages so each gem's output stays together
+			var log strings.Builder
+			logf := func(format string, args ...interface{}) {
+				fmt.Fprintf(&log, format, args...)
+			}
+
+			result, err := s.scanChange(change, logf)
+			if err != nil {
+				result = &Result{Change: change, Err: err}
+				s.opts.Progress(change, StageFailed)
+			} else {
+				s.opts.Progress(change, StageDone)
+			}
+			result.Log = log.String()
+			results[i] = result
+		}(i, change)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// scanBatch prepares every change concurrently, scans the files of all of
+// them with as few scanner invocations as the argv limit allows, maps the
+// findings back to each gem by path prefix and then finishes each change.
+// If a batched invocation fails, each change is scanned on its own so only
+// the gems the scanner can't handle fail.
+func (s *Scanner) scanBatch(changes []gem.VersionChange) []*Result {
+	results := make([]*Result, len(changes))
+	logs := make([]*strings.Builder, len(changes))
+
+	// Download, extract and diff every change
+	var wg sync.WaitGroup
+	for i, change := range changes {
+		s.opts.Progress(change, StageQueued)
+		logs[i] = &strings.Builder{}
+
+		wg.Add(1)
+		go func(i int, change gem.VersionChange) {
+			defer wg.Done()
+			logf := func(format string, args ...interface{}) {
+				fmt.Fprintf(logs[i], format, args...)
+			}
+
+			result, err := s.prepare(change, logf)
+			if err != nil {
+				result = &Result{Change: change, Err: err}
+			}
+			results[i] = result
+		}(i, change)
+	}
+	wg.Wait()
+
+	// Collect the files of every change that needs scanning
+	var beforeFiles, afterFiles, roots []string
+	for _, result := range results {
+		if result.Err != nil || !result.Diff.HasChanges() {
+			continue
+		}
+		filesToScan1, filesToScan2 := result.filesToScan()
+		beforeFiles = append(beforeFiles, filesToScan1...)
+		afterFiles = append(afterFiles, filesToScan2...)
+		roots = append(roots, result.BeforePath, result.AfterPath)
+		s.opts.Progress(result.Change, StageScanning)
+	}
+
+	// Scan all before files and all after files together
+	var beforeResults, afterResults map[string]*semgrep.ScanResult
+	beforeAll, batchErr := s.run(s.opts.BaseDir, beforeFiles)
+	var afterAll *semgrep.ScanResult
+	if batchErr == nil {
+		afterAll, batchErr = s.run(s.opts.BaseDir, afterFiles)
+	}
+	if batchErr == nil {
+		beforeResults = semgrep.GroupByRoot(beforeAll, roots)
+		afterResults = semgrep.GroupByRoot(afterAll, roots)
+	}
+
+	// Finish each change with its share of the findings
+	for i, result := range results {
+		change := changes[i]
+		if result.Err == nil && result.Diff.HasChanges() {
+			logf := func(format string, args ...interface{}) {
+				fmt.Fprintf(logs[i], format, args...)
+			}
+
+			var scanned *Result
+			var err error
+			if batchErr != nil {
+				logf("Batched scan failed, scanning this gem on its own: %v\n", batchErr)
+				scanned, err = s.scanPrepared(result, logf)
+			} else {
+				logf("Scanning files in versions %s and %s (batched)...\n", change.Before.Version, change.After.Version)
+				scanned, err = s.analyze(result, beforeResults[result.BeforePath], afterResults[result.AfterPath])
+			}
+			// The diff is kept so the report still shows what changed
+			if err != nil {
+				result.Err = err
+			} else {
+				result = scanned
+			}
+		}
+
+		if result.Err != nil {
+			s.opts.Progress(change, StageFailed)
+		} else {
+			s.opts.Progress(change, StageDone)
+		}
+		result.Log = logs[i].String()
+		results[i] = result
+	}
+
+	return results
+}
+
+// fetch downloads and extracts a gem, holding the download and extraction
+// worker slots only for the stage that needs them
+func (s *Scanner) fetch(change gem.VersionChange, g *gem.Gem) error {
+	s.downloads <- struct{}{}
+	gemFile, err := g.Download()
+	<-s.downloads
+	if err != nil {
+		return err
+	}
+	defer os.Remove(gemFile)
+
+	s.opts.Progress(change, StageExtracting)
+	s.extractions <- struct{}{}
+	defer func() { <-s.extractions }()
+	return g.Extract(gemFile, s.opts.BaseDir)
+}
+
+// run runs the scanners over files under root while holding a scan worker slot
+func (s *Scanner) run(root string, files []string) (*semgrep.ScanResult, error) {
+	if len(files) == 0 {
+		return &semgrep.ScanResult{Findings: []*semgrep.Finding{}}, nil
+	}
+	s.scans <- struct{}{}
+	defer func() { <-s.scans }()
+	return s.backend.Scan(root, files)
+}
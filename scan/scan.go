@@ -0,0 +1,414 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"whiskers/baseline"
+	"whiskers/engine"
+	"whiskers/gem"
+	"whiskers/heuristics"
+	"whiskers/rules"
+	"whiskers/semgrep"
+	"whiskers/surface"
+	"whiskers/utils"
+)
+
+// DefaultIgnoreFiles are files that change between releases without affecting behavior
+var DefaultIgnoreFiles = []string{
+	"Gemfile.lock",
+	".gitignore",
+	"gem.deps.rb",
+}
+
+// Options configures how gem changes are downloaded and scanned
+type Options struct {
+	BaseDir string
+	// Rules are the semgrep rules to scan with
+	Rules *rules.Set
+	// Scanners are the names of the analysis backends to run, see engine.Available.
+	// The default is engine.DefaultScanners.
+	Scanners []string
+	// SARIFCommand is the external analyzer run by the sarif scanner
+	SARIFCommand string
+	// YARARules is the rules directory of the yara scanner
+	YARARules   string
+	IgnoreFiles []string
+	// Logf receives progress messages, e.g. fmt.Printf
+	Logf func(format string, args ...interface{})
+	// Progress is called as each change moves through the pipeline stages
+	Progress    func(change gem.VersionChange, stage Stage)
+	Concurrency Concurrency
+	// Batch scans the files of all changes in as few scanner invocations as possible
+	Batch bool
+	// MinSeverity drops findings less severe than this, e.g. "WARNING"
+	MinSeverity string
+	// Suppressions hides findings that have already been reviewed and accepted
+	Suppressions *baseline.File
+	// TrustedSources are the gem source URLs, e.g. an internal gem server,
+	// whose gems may silence findings with whiskers:ignore annotations
+	TrustedSources []string
+}
+
+// Result holds the outcome of scanning a single gem version change
+type Result struct {
+	Change     gem.VersionChange
+	BeforePath string
+	AfterPath  string
+	Diff       *utils.FileDiff
+	// LoadTimeFiles are the changed and added files that run when the gem is required
+	LoadTimeFiles []string
+	// Findings are the issues new in the after version, with paths relative to AfterPath
+	Findings []*semgrep.Finding
+	// Unanalyzed are the files in the after version, relative to AfterPath,
+	// that the scanners failed to parse, timed out on or skipped
+	Unanalyzed []semgrep.ScanError
+	// RuleErrors are problems the scanners reported with their rules
+	RuleErrors []semgrep.ScanError
+	// Suppressed are new findings hidden by the suppression file
+	Suppressed []*semgrep.Finding
+	// ExpiredSuppressions matched a finding but have expired, so it is reported again
+	ExpiredSuppressions []baseline.Suppression
+	// Err is set when ScanChanges could not scan the change
+	Err error
+	// Log holds the progress messages ScanChanges buffered for the change
+	Log     string
+	Timings Timings
+}
+
+// Timings records how long each stage of scanning a change took
+type Timings struct {
+	// Prepare covers downloading, extracting and diffing both versions
+	Prepare time.Duration
+	// Scan is the time the scanners took on both versions. It is zero for
+	// batched scans, which scan every change at once.
+	Scan    time.Duration
+	Analyze time.Duration
+}
+
+// Scanner downloads, diffs and scans gem version changes
+type Scanner struct {
+	opts    Options
+	backend engine.Scanner
+
+	// Semaphores bounding each pipeline stage
+	downloads   chan struct{}
+	extractions chan struct{}
+	scans       chan struct{}
+}
+
+// NewScanner creates a new Scanner instance with the selected analysis backends
+func NewScanner(opts Options) (*Scanner, error) {
+	if opts.BaseDir == "" {
+		opts.BaseDir = "/tmp/gems"
+	}
+	if opts.IgnoreFiles == nil {
+		opts.IgnoreFiles = DefaultIgnoreFiles
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...interface{}) {}
+	}
+	if opts.Progress == nil {
+		opts.Progress = func(gem.VersionChange, Stage) {}
+	}
+	opts.Concurrency = opts.Concurrency.withDefaults()
+
+	backend, err := engine.New(engine.Options{
+		Scanners:     opts.Scanners,
+		Rules:        opts.Rules,
+		SARIFCommand: opts.SARIFCommand,
+		YARARules:    opts.YARARules,
+		Logf:         opts.Logf,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scanner{
+		opts:        opts,
+		backend:     backend,
+		downloads:   make(chan struct{}, opts.Concurrency.Downloads),
+		extractions: make(chan struct{}, opts.Concurrency.Extractions),
+		scans:       make(chan struct{}, opts.Concurrency.Scans),
+	}, nil
+}
+
+// Close releases resources held by the scanner, such as a semgrep language server
+func (s *Scanner) Close() error {
+	return s.backend.Close()
+}
+
+// Backend returns the name of the analysis backends, e.g. "semgrep+heuristics"
+func (s *Scanner) Backend() string {
+	return s.backend.Name()
+}
+
+// ScanChange downloads both versions of a gem, diffs them and returns the
+// issues introduced by the after version
+func (s *Scanner) ScanChange(change gem.VersionChange) (*Result, error) {
+	return s.scanChange(change, s.opts.Logf)
+}
+
+// scanChange does the work of ScanChange, sending progress messages to logf
+func (s *Scanner) scanChange(change gem.VersionChange, logf func(format string, args ...interface{})) (*Result, error) {
+	result, err := s.prepare(change, logf)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanPrepared(result, logf)
+}
+
+// scanPrepared runs the scanners on both versions of a prepared change, each
+// in its own invocation, and analyzes the results
+func (s *Scanner) scanPrepared(result *Result, logf func(format string, args ...interface{})) (*Result, error) {
+	change := result.Change
+	if !result.Diff.HasChanges() {
+		return result, nil
+	}
+
+	filesToScan1, filesToScan2 := result.filesToScan()
+
+	// Run the scanners on both versions
+	s.opts.Progress(change, StageScanning)
+	start := time.Now()
+	logf("Scanning files in version %s...\n", change.Before.Version)
+	scan1, err := s.run(result.BeforePath, filesToScan1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan version %s: %w", change.Before.Version, err)
+	}
+
+	logf("Scanning files in version %s...\n", change.After.Version)
+	scan2, err := s.run(result.AfterPath, filesToScan2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan version %s: %w", change.After.Version, err)
+	}
+	result.Timings.Scan = time.Since(start)
+
+	return s.analyze(result, scan1, scan2)
+}
+
+// prepare downloads and extracts both versions of a gem and diffs them
+func (s *Scanner) prepare(change gem.VersionChange, logf func(format string, args ...interface{})) (*Result, error) {
+	start := time.Now()
+
+	// Download and extract both versions
+	s.opts.Progress(change, StageDownloading)
+	logf("Downloading version %s...\n", change.Before.Version)
+	if err := s.fetch(change, change.Before); err != nil {
+		return nil, fmt.Errorf("failed to download version %s: %w", change.Before.Version, err)
+	}
+
+	logf("Downloading version %s...\n", change.After.Version)
+	if err := s.fetch(change, change.After); err != nil {
+		return nil, fmt.Errorf("failed to download version %s: %w", change.After.Version, err)
+	}
+
+	// Get paths to the extracted gems
+	result := &Result{
+		Change:     change,
+		BeforePath: change.Before.ExtractPath(s.opts.BaseDir),
+		AfterPath:  change.After.ExtractPath(s.opts.BaseDir),
+	}
+
+	// Compare the directories
+	diff, err := utils.ComparePaths(result.BeforePath, result.AfterPath, s.opts.IgnoreFiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare versions: %w", err)
+	}
+	result.Diff = diff
+	result.Timings.Prepare = time.Since(start)
+
+	return result, nil
+}
+
+// filesToScan returns the changed files in the before version, and the
+// changed and added files in the after version
+func (r *Result) filesToScan() ([]string, []string) {
+	var filesToScan1 []string
+	var filesToScan2 []string
+
+	// Add changed files
+	for _, file := range r.Diff.Changed {
+		filesToScan1 = append(filesToScan1, filepath.Join(r.BeforePath, file))
+		filesToScan2 = append(filesToScan2, filepath.Join(r.AfterPath, file))
+	}
+
+	// Add new files (only in version 2)
+	for _, file := range r.Diff.Added {
+		filesToScan2 = append(filesToScan2, filepath.Join(r.AfterPath, file))
+	}
+
+	return filesToScan1, filesToScan2
+}
+
+// analyze runs whiskers' own gem-level analyses over a prepared change,
+// combines them with the scanner results of both versions and keeps only the
+// new issues
+func (s *Scanner) analyze(result *Result, scan1, scan2 *semgrep.ScanResult) (*Result, error) {
+	start := time.Now()
+	change := result.Change
+	diff := result.Diff
+	filesToScan1, filesToScan2 := result.filesToScan()
+	findings1 := scan1.Findings
+	findings2 := scan2.Findings
+
+	// Code the scanners couldn't analyze may be hiding something, so record it
+	for _, e := range scan2.Unanalyzed() {
+		if relPath, err := filepath.Rel(result.AfterPath, e.Path); err == nil {
+			e.Path = relPath
+		}
+		result.Unanalyzed = append(result.Unanalyzed, e)
+	}
+	seenRuleErrors := make(map[string]bool)
+	for _, e := range append(append([]semgrep.ScanError{}, scan1.RuleErrors...), scan2.RuleErrors...) {
+		if !seenRuleErrors[e.Display()] {
+			seenRuleErrors[e.Display()] = true
+			result.RuleErrors = append(result.RuleErrors, e)
+		}
+	}
+
+	// Comments that switch off scanning are only expected in trusted code
+	trusted := s.isTrusted(change.After)
+	if !trusted {
+		comments1, err := heuristics.ScanSuppressionComments(filesToScan1)
+		if err != nil {
+			return nil, err
+		}
+		findings1 = append(findings1, comments1...)
+
+		comments2, err := heuristics.ScanSuppressionComments(filesToScan2)
+		if err != nil {
+			return nil, err
+		}
+		findings2 = append(findings2, comments2...)
+	}
+
+	// Apply stricter rules to code that runs at gem install time
+	beforeSpec := s.loadSpec(result, change.Before)
+	afterSpec := s.loadSpec(result, change.After)
+	beforeSurface, err := s.installSurface(change.Before, result.BeforePath, beforeSpec)
+	if err != nil {
+		return nil, err
+	}
+	afterSurface, err := s.installSurface(change.After, result.AfterPath, afterSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	install1, err := beforeSurface.Scan(filesToScan1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze version %s: %w", change.Before.Version, err)
+	}
+	findings1 = append(findings1, install1...)
+
+	install2, err := afterSurface.Scan(filesToScan2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze version %s: %w", change.After.Version, err)
+	}
+	findings2 = append(findings2, install2...)
+	findings2 = append(findings2, surface.CompareInstallSurfaces(change, beforeSurface, afterSurface)...)
+
+	// Work out which changed files run as soon as the gem is required
+	loadSurface, err := surface.NewLoadSurface(result.AfterPath, change.Name, afterSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve load-time files in version %s: %w", change.After.Version, err)
+	}
+	for _, file := range append(append([]string{}, diff.Changed...), diff.Added...) {
+		if loadSurface.Contains(file) {
+			result.LoadTimeFiles = append(result.LoadTimeFiles, file)
+		}
+	}
+	sort.Strings(result.LoadTimeFiles)
+	loadSurface.Mark(findings2)
+
+	// Fingerprint findings relative to each version so unchanged issues match
+	// even when the code around them moved
+	semgrep.AssignFingerprints(findings1, result.BeforePath)
+	semgrep.AssignFingerprints(findings2, result.AfterPath)
+
+	// Count findings in version 1 for easy comparison
+	oldFindings := make(map[string]int)
+	for _, f := range findings1 {
+		oldFindings[f.Fingerprint]++
+	}
+
+	// Find new findings, including extra copies of an existing one
+	for _, f := range findings2 {
+		if oldFindings[f.Fingerprint] > 0 {
+			oldFindings[f.Fingerprint]--
+			continue
+		}
+
+		// Make the path relative to the gem root
+		if err := f.Rebase(result.AfterPath); err != nil {
+			return nil, fmt.Errorf("failed to rebase path: %w", err)
+		}
+		result.Findings = append(result.Findings, f)
+	}
+
+	// Hide findings that have already been reviewed
+	if trusted {
+		var ignored []*semgrep.Finding
+		result.Findings, ignored = heuristics.ApplyIgnoreAnnotations(result.Findings, result.AfterPath)
+		result.Suppressed = append(result.Suppressed, ignored...)
+	}
+	if s.opts.Suppressions != nil {
+		outcome := s.opts.Suppressions.Apply(change, result.Findings, time.Now())
+		result.Findings = outcome.Findings
+		result.Suppressed = append(result.Suppressed, outcome.Suppressed...)
+		result.ExpiredSuppressions = outcome.Expired
+	}
+
+	if s.opts.MinSeverity != "" {
+		result.Findings = semgrep.FilterBySeverity(result.Findings, s.opts.MinSeverity)
+	}
+
+	// Findings in code that runs on require matter more than those in rarely-called code
+	semgrep.SortBySeverity(result.Findings)
+	surface.Rank(result.Findings)
+	result.Timings.Analyze = time.Since(start)
+
+	return result, nil
+}
+
+// isTrusted returns true if the gem comes from one of the trusted sources
+func (s *Scanner) isTrusted(g *gem.Gem) bool {
+	for _, source := range s.opts.TrustedSources {
+		if strings.TrimSuffix(source, "/") == strings.TrimSuffix(g.Source.URL, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSpec reads the saved gemspec metadata of a version. Metadata that can't
+// be read leaves the extensions and require paths unknown, so it is recorded
+// as unanalyzed rather than ignored. Gems extracted before metadata was saved
+// are treated as having none.
+func (s *Scanner) loadSpec(result *Result, g *gem.Gem) *gem.Spec {
+	spec, err := g.LoadSpec(s.opts.BaseDir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			result.Unanalyzed = append(result.Unanalyzed, semgrep.ScanError{
+				Type:    "Metadata error",
+				Path:    "metadata.gz",
+				Message: fmt.Sprintf("version %s: %v", g.Version, err),
+			})
+		}
+		return nil
+	}
+	return spec
+}
+
+// installSurface identifies the install-time files of an extracted gem
+func (s *Scanner) installSurface(g *gem.Gem, root string, spec *gem.Spec) (*surface.InstallSurface, error) {
+	installSurface, err := surface.NewInstallSurface(root, spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify install-time files in version %s: %w", g.Version, err)
+	}
+	return installSurface, nil
+}
@@ -2,32 +2,94 @@ package semgrep
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
 // Finding represents a single semgrep finding
 type Finding struct {
-	RuleID  string `json:"check_id"`
-	Message string `json:"message"`
-	Lines   string `json:"lines"`
-	Line    int    `json:"line"`
-	Path    string `json:"path"`
+	RuleID    string             `json:"check_id"`
+	Message   string             `json:"message"`
+	Lines     string             `json:"lines"`
+	Line      int                `json:"line"`
+	Column    int                `json:"column,omitempty"`
+	EndLine   int                `json:"end_line,omitempty"`
+	EndColumn int                `json:"end_column,omitempty"`
+	Path      string             `json:"path"`
+	Severity  string             `json:"severity"`
+	Metadata  Metadata           `json:"metadata"`
+	Metavars  map[string]Metavar `json:"metavars,omitempty"`
+	// Fingerprint identifies the finding across versions, see AssignFingerprints
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Fix         string `json:"fix,omitempty"`
+	LoadTime    bool   `json:"load_time,omitempty"`
+	// Scanner is the backend that reported the finding, e.g. "semgrep"
+	Scanner string `json:"scanner,omitempty"`
+	// SemgrepFingerprint is the fingerprint semgrep itself reported, if any
+	SemgrepFingerprint string `json:"semgrep_fingerprint,omitempty"`
 }
 
 // NewFinding creates a Finding from a semgrep result
-func NewFinding(result map[string]interface{}) *Finding {
-	extra := result["extra"].(map[string]interface{})
-	start := result["start"].(map[string]interface{})
-
+func NewFinding(result Result) *Finding {
 	return &Finding{
-		RuleID:  result["check_id"].(string),
-		Message: extra["message"].(string),
-		Lines:   extra["lines"].(string),
-		Line:    int(start["line"].(float64)),
-		Path:    result["path"].(string),
+		RuleID:    result.CheckID,
+		Message:   result.Extra.Message,
+		Lines:     result.Extra.Lines,
+		Line:      result.Start.Line,
+		Column:    result.Start.Col,
+		EndLine:   result.End.Line,
+		EndColumn: result.End.Col,
+		Path:      result.Path,
+		Severity:  result.Extra.Severity,
+		Metadata:  result.Extra.Metadata,
+		Metavars:  result.Extra.Metavars,
+		Fix:       result.Extra.Fix,
+
+		SemgrepFingerprint: result.Extra.Fingerprint,
 	}
 }
 
+// Severity levels, from least to most severe. Semgrep's newer
+// LOW/MEDIUM/HIGH/CRITICAL names map onto the classic INFO/WARNING/ERROR.
+var severityLevels = map[string]int{
+	"INFO":     1,
+	"LOW":      1,
+	"WARNING":  2,
+	"MEDIUM":   2,
+	"ERROR":    3,
+	"HIGH":     3,
+	"CRITICAL": 4,
+}
+
+// SeverityLevel returns the numeric level of a severity name, or 0 if it is unknown
+func SeverityLevel(severity string) int {
+	return severityLevels[strings.ToUpper(severity)]
+}
+
+// AtLeast returns true if the finding is at least as severe as minSeverity
+func (f *Finding) AtLeast(minSeverity string) bool {
+	return SeverityLevel(f.Severity) >= SeverityLevel(minSeverity)
+}
+
+// FilterBySeverity returns the findings at least as severe as minSeverity
+func FilterBySeverity(findings []*Finding, minSeverity string) []*Finding {
+	filtered := make([]*Finding, 0, len(findings))
+	for _, f := range findings {
+		if f.AtLeast(minSeverity) {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
+
+// SortBySeverity orders findings from most to least severe, keeping the
+// existing order within a severity
+func SortBySeverity(findings []*Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return SeverityLevel(findings[i].Severity) > SeverityLevel(findings[j].Severity)
+	})
+}
+
 // Equals checks if two findings are equivalent (same rule and lines)
 func (f *Finding) Equals(other *Finding) bool {
 	return f.RuleID == other.RuleID && f.Lines == other.Lines
@@ -35,9 +97,19 @@ func (f *Finding) Equals(other *Finding) bool {
 
 // Display returns a formatted string representation of the finding
 func (f *Finding) Display() string {
-	return fmt.Sprintf("  [%s] line %d: %s\n    %s",
+	location := fmt.Sprintf("line %d", f.Line)
+	if f.Metadata.Confidence != "" {
+		location += fmt.Sprintf(" (%s confidence)", strings.ToLower(f.Metadata.Confidence))
+	}
+	if f.Severity != "" {
+		location = f.Severity + " " + location
+	}
+	if f.LoadTime {
+		location += " (runs on require)"
+	}
+	return fmt.Sprintf("  [%s] %s: %s\n    %s",
 		f.RuleID,
-		f.Line,
+		location,
 		f.Message,
 		f.Lines)
 }
@@ -55,4 +127,4 @@ func (f *Finding) Rebase(baseDir string) error {
 // RelativePath returns the path relative to the given base directory
 func (f *Finding) RelativePath(baseDir string) string {
 	return strings.TrimPrefix(f.Path, baseDir+"/")
-} 
+}
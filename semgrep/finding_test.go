@@ -0,0 +1,77 @@
+package semgrep
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestNewFinding(t *testing.T) {
+	var output SemgrepOutput
+	err := json.Unmarshal([]byte(`{"results": [{
+		"check_id": "rules.ruby-eval",
+		"path": "/gems/foo-1.0.0/lib/foo.rb",
+		"start": {"line": 3, "col": 5},
+		"end": {"line": 3, "col": 20},
+		"extra": {
+			"message": "eval of dynamic code",
+			"lines": "    eval(code)",
+			"severity": "ERROR",
+			"metadata": {"confidence": "HIGH"},
+			"fingerprint": "0123abcd",
+			"fix": "safe(code)"
+		}
+	}]}`), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFinding(output.Results[0])
+	want := Finding{
+		RuleID:             "rules.ruby-eval",
+		Message:            "eval of dynamic code",
+		Lines:              "    eval(code)",
+		Line:               3,
+		Column:             5,
+		EndLine:            3,
+		EndColumn:          20,
+		Path:               "/gems/foo-1.0.0/lib/foo.rb",
+		Severity:           "ERROR",
+		Metadata:           Metadata{Confidence: "HIGH"},
+		Fix:                "safe(code)",
+		SemgrepFingerprint: "0123abcd",
+	}
+	got, _ := json.Marshal(f)
+	wantJSON, _ := json.Marshal(want)
+	if string(got) != string(wantJSON) {
+		t.Errorf("NewFinding() = %s, want %s", got, wantJSON)
+	}
+}
+
+func TestFilterBySeverity(t *testing.T) {
+	findings := []*Finding{
+		{RuleID: "info", Severity: "INFO"},
+		{RuleID: "medium", Severity: "MEDIUM"},
+		{RuleID: "error", Severity: "ERROR"},
+		{RuleID: "critical", Severity: "CRITICAL"},
+		{RuleID: "unknown", Severity: "bogus"},
+	}
+	tests := []struct {
+		minSeverity string
+		want        []string
+	}{
+		{"INFO", []string{"info", "medium", "error", "critical"}},
+		{"warning", []string{"medium", "error", "critical"}},
+		{"HIGH", []string{"error", "critical"}},
+		{"CRITICAL", []string{"critical"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, f := range FilterBySeverity(findings, tt.minSeverity) {
+			got = append(got, f.RuleID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("FilterBySeverity(%s) = %v, want %v", tt.minSeverity, got, tt.want)
+		}
+	}
+}
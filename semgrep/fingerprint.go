@@ -0,0 +1,106 @@
+package semgrep
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Matches Ruby lines that open a method, class or module, e.g. "  def self.run(args)"
+var scopeRegex = regexp.MustCompile(`^(\s*)(?:private\s+|protected\s+|public\s+)?(def|class|module)\s+([\w:.?!=<>\[\]+\-*/%&|^~]+)`)
+
+// AssignFingerprints sets the fingerprint of each finding from its path
+// relative to root, its rule, its normalized source lines and the methods,
+// classes and modules enclosing it. Fingerprints don't depend on line
+// numbers or whitespace, so the same issue in two versions of a gem has the
+// same fingerprint even if the code around it moved.
+func AssignFingerprints(findings []*Finding, root string) {
+	files := make(map[string][]string)
+	for _, f := range findings {
+		lines, ok := files[f.Path]
+		if !ok {
+			if content, err := os.ReadFile(f.Path); err == nil {
+				lines = strings.Split(string(content), "\n")
+			}
+			files[f.Path] = lines
+		}
+
+		relPath := f.Path
+		if rel, err := filepath.Rel(root, f.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			relPath = filepath.ToSlash(rel)
+		}
+
+		f.Fingerprint = Fingerprint(relPath, f.RuleID, matchedCode(lines, f), enclosingScope(lines, f.Line))
+	}
+}
+
+// matchedCode returns the source lines a finding spans. Newer semgrep
+// versions report "requires login" instead of the lines, so the finding's
+// own Lines are only used if the file can't be read.
+func matchedCode(lines []string, f *Finding) string {
+	if f.Line < 1 || f.Line > len(lines) {
+		return f.Lines
+	}
+	end := min(max(f.EndLine, f.Line), len(lines))
+	return strings.Join(lines[f.Line-1:end], "\n")
+}
+
+// Fingerprint hashes the parts that identify a finding independently of where it sits in a file
+func Fingerprint(relPath, ruleID, code, scope string) string {
+	hash := sha256.New()
+	for _, part := range []string{relPath, ruleID, NormalizeCode(code), scope} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+// NormalizeCode drops whitespace from code except where it separates two
+// words, so reindenting or reformatting a line doesn't change it
+func NormalizeCode(code string) string {
+	var normalized strings.Builder
+	for _, field := range strings.Fields(code) {
+		if normalized.Len() > 0 && isWordByte(normalized.String()[normalized.Len()-1]) && isWordByte(field[0]) {
+			normalized.WriteByte(' ')
+		}
+		normalized.WriteString(field)
+	}
+	return normalized.String()
+}
+
+// isWordByte returns true for characters that can be part of a Ruby identifier
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// enclosingScope returns the methods, classes and modules enclosing the
+// given 1-based line, outermost first, e.g. "module Foo/class Bar/def baz".
+// Nesting is worked out from indentation.
+func enclosingScope(lines []string, line int) string {
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	indent := indentation(lines[line-1])
+	var scopes []string
+	for i := line - 2; i >= 0 && indent > 0; i-- {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+		match := scopeRegex.FindStringSubmatch(lines[i])
+		if match == nil || len(match[1]) >= indent {
+			continue
+		}
+		scopes = append([]string{match[2] + " " + match[3]}, scopes...)
+		indent = len(match[1])
+	}
+	return strings.Join(scopes, "/")
+}
+
+// indentation returns the number of leading whitespace characters of a line
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
@@ -0,0 +1,150 @@
+package semgrep
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"eval(code)", "eval(code)"},
+		{"    eval( code )\n", "eval(code)"},
+		{"system \"ls\" , '-la'", `system"ls",'-la'`},
+		{"return  foo   if bar", "return foo if bar"},
+		{"x = 1\n\ty =\t2", "x=1 y=2"},
+		{"déjà vu", "déjà vu"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeCode(tt.code); got != tt.want {
+			t.Errorf("NormalizeCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestEnclosingScope(t *testing.T) {
+	lines := strings.Split(`module Foo
+  class Bar < Base
+    def self.run(cmd)
+      system(cmd)
+
+      if cmd
+        eval(cmd)
+      end
+    end
+
+    def other
+      x = 1
+    end
+  end
+
+  def helper; end
+end
+top_level`, "\n")
+
+	tests := []struct {
+		line int
+		want string
+	}{
+		{4, "module Foo/class Bar/def self.run"},
+		{7, "module Foo/class Bar/def self.run"},
+		{12, "module Foo/class Bar/def other"},
+		{3, "module Foo/class Bar"},
+		{16, "module Foo"},
+		{1, ""},
+		{18, ""},
+		{0, ""},
+		{99, ""},
+	}
+	for _, tt := range tests {
+		if got := enclosingScope(lines, tt.line); got != tt.want {
+			t.Errorf("enclosingScope(line %d) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	base := Fingerprint("lib/foo.rb", "ruby-eval", "eval(code)", "class Foo")
+	tests := []struct {
+		name                  string
+		relPath, ruleID, code string
+		scope                 string
+		same                  bool
+	}{
+		{"reindented", "lib/foo.rb", "ruby-eval", "   eval( code )", "class Foo", true},
+		{"other file", "lib/bar.rb", "ruby-eval", "eval(code)", "class Foo", false},
+		{"other rule", "lib/foo.rb", "ruby-exec", "eval(code)", "class Foo", false},
+		{"other code", "lib/foo.rb", "ruby-eval", "eval(input)", "class Foo", false},
+		{"other scope", "lib/foo.rb", "ruby-eval", "eval(code)", "class Bar", false},
+		// Parts are separated so they can't run into each other
+		{"shifted parts", "lib/foo.rbruby-eval", "", "eval(code)", "class Foo", false},
+	}
+	for _, tt := range tests {
+		got := Fingerprint(tt.relPath, tt.ruleID, tt.code, tt.scope)
+		if (got == base) != tt.same {
+			t.Errorf("%s: fingerprint %s, base %s, want same: %v", tt.name, got, base, tt.same)
+		}
+	}
+}
+
+func TestAssignFingerprints(t *testing.T) {
+	before, after := t.TempDir(), t.TempDir()
+	write := func(root, content string) string {
+		t.Helper()
+		path := filepath.Join(root, "lib", "foo.rb")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	beforePath := write(before, "class Foo\n  def run\n    eval(a)\n    eval(b)\n  end\nend\n")
+	// The same calls, moved down and reindented
+	afterPath := write(after, "# comment\nclass Foo\n  def run\n\n      eval( a )\n      eval( b )\n  end\nend\n")
+
+	// Newer semgrep versions don't report the matched lines
+	finding := func(path string, line int) *Finding {
+		return &Finding{Path: path, RuleID: "ruby-eval", Line: line, EndLine: line, Lines: "requires login"}
+	}
+	findingsBefore := []*Finding{finding(beforePath, 3), finding(beforePath, 4)}
+	findingsAfter := []*Finding{finding(afterPath, 5), finding(afterPath, 6)}
+	AssignFingerprints(findingsBefore, before)
+	AssignFingerprints(findingsAfter, after)
+
+	if findingsBefore[0].Fingerprint == findingsBefore[1].Fingerprint {
+		t.Error("findings on different lines of the same scope share a fingerprint")
+	}
+	for i := range findingsBefore {
+		if findingsBefore[i].Fingerprint != findingsAfter[i].Fingerprint {
+			t.Errorf("finding %d: fingerprint changed from %s to %s after moving the code", i, findingsBefore[i].Fingerprint, findingsAfter[i].Fingerprint)
+		}
+	}
+}
+
+func TestMatchedCode(t *testing.T) {
+	lines := []string{"a", "b", "c"}
+	tests := []struct {
+		line, endLine int
+		want          string
+	}{
+		{2, 2, "b"},
+		{1, 3, "a\nb\nc"},
+		{2, 0, "b"},
+		{3, 9, "c"},
+		{0, 0, "reported"},
+		{4, 4, "reported"},
+	}
+	for _, tt := range tests {
+		f := &Finding{Line: tt.line, EndLine: tt.endLine, Lines: "reported"}
+		if got := matchedCode(lines, f); got != tt.want {
+			t.Errorf("matchedCode(line %d-%d) = %q, want %q", tt.line, tt.endLine, got, tt.want)
+		}
+	}
+}
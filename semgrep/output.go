@@ -0,0 +1,140 @@
+package semgrep
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SemgrepOutput represents the JSON structure of semgrep's output
+type SemgrepOutput struct {
+	Results []Result `json:"results"`
+	Errors  []Error  `json:"errors"`
+	Paths   Paths    `json:"paths"`
+}
+
+// Error is a single entry of the errors array in semgrep's JSON output
+type Error struct {
+	Code    int       `json:"code"`
+	Level   string    `json:"level"`
+	Type    ErrorType `json:"type"`
+	RuleID  string    `json:"rule_id"`
+	Message string    `json:"message"`
+	Path    string    `json:"path"`
+}
+
+// ErrorType is the kind of a semgrep error, e.g. "Syntax error" or "Timeout"
+type ErrorType string
+
+// UnmarshalJSON accepts both the plain string form of an error type and the
+// ["PartialParsing", [locations...]] form newer semgrep versions emit
+func (t *ErrorType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = ErrorType(name)
+		return nil
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("invalid semgrep error type: %s", data)
+	}
+	if len(parts) > 0 {
+		if err := json.Unmarshal(parts[0], &name); err != nil {
+			return fmt.Errorf("invalid semgrep error type: %s", data)
+		}
+	}
+	*t = ErrorType(name)
+	return nil
+}
+
+// Paths lists the targets semgrep scanned and skipped
+type Paths struct {
+	Scanned []string      `json:"scanned"`
+	Skipped []SkippedPath `json:"skipped"`
+}
+
+// SkippedPath is a target semgrep did not scan, and why
+type SkippedPath struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Result is a single entry of the results array in semgrep's JSON output
+type Result struct {
+	CheckID string   `json:"check_id"`
+	Path    string   `json:"path"`
+	Start   Position `json:"start"`
+	End     Position `json:"end"`
+	Extra   Extra    `json:"extra"`
+}
+
+// Extra holds the rule-specific details of a semgrep result
+type Extra struct {
+	Message     string             `json:"message"`
+	Lines       string             `json:"lines"`
+	Severity    string             `json:"severity"`
+	Metadata    Metadata           `json:"metadata"`
+	Metavars    map[string]Metavar `json:"metavars"`
+	Fingerprint string             `json:"fingerprint"`
+	Fix         string             `json:"fix"`
+}
+
+// Position is a location in a file. Lines and columns start at 1.
+type Position struct {
+	Line   int `json:"line"`
+	Col    int `json:"col"`
+	Offset int `json:"offset"`
+}
+
+// Metavar is the code a rule metavariable such as $URL was bound to
+type Metavar struct {
+	Start           Position `json:"start"`
+	End             Position `json:"end"`
+	AbstractContent string   `json:"abstract_content"`
+}
+
+// Metadata is the metadata block declared by a rule in its YAML
+type Metadata struct {
+	Category   string   `json:"category,omitempty"`
+	Confidence string   `json:"confidence,omitempty"`
+	Impact     string   `json:"impact,omitempty"`
+	Likelihood string   `json:"likelihood,omitempty"`
+	References []string `json:"references,omitempty"`
+}
+
+// UnmarshalJSON decodes rule metadata leniently, since rule authors are free
+// to put anything in it: non-string values are stringified and a single
+// reference may be given instead of a list
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	str := func(key string) string {
+		switch v := raw[key].(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+
+	m.Category = str("category")
+	m.Confidence = str("confidence")
+	m.Impact = str("impact")
+	m.Likelihood = str("likelihood")
+
+	m.References = nil
+	switch refs := raw["references"].(type) {
+	case string:
+		m.References = []string{refs}
+	case []interface{}:
+		for _, ref := range refs {
+			m.References = append(m.References, fmt.Sprint(ref))
+		}
+	}
+	return nil
+}
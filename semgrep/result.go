@@ -0,0 +1,169 @@
+package semgrep
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ScanResult is everything a semgrep run reported: the findings, and the
+// files and rules it could not fully analyze. A file semgrep failed to parse
+// is an unscanned file, and a malicious gem can trigger that deliberately, so
+// the failures matter as much as the findings.
+type ScanResult struct {
+	Findings []*Finding
+	// ParseErrors are files semgrep could not parse, or only partially parsed
+	ParseErrors []ScanError
+	// Timeouts are files semgrep gave up on after running out of time or memory
+	Timeouts []ScanError
+	// Skipped are targets semgrep did not scan at all
+	Skipped []SkippedPath
+	// RuleErrors are problems with the rules themselves, or with the run as a whole
+	RuleErrors []ScanError
+}
+
+// ScanError is an error semgrep reported for a file or rule
+type ScanError struct {
+	Type    string `json:"type"`
+	Level   string `json:"level,omitempty"`
+	RuleID  string `json:"rule_id,omitempty"`
+	Message string `json:"message"`
+	Path    string `json:"path,omitempty"`
+}
+
+// Skip reasons that don't leave relevant code unscanned
+var irrelevantSkipReasons = map[string]bool{
+	"wrong_language":  true,
+	"irrelevant_rule": true,
+	"binary":          true,
+}
+
+// NewScanResult creates a ScanResult from semgrep's JSON output
+func NewScanResult(output SemgrepOutput) *ScanResult {
+	result := &ScanResult{
+		Findings: make([]*Finding, 0, len(output.Results)),
+		Skipped:  output.Paths.Skipped,
+	}
+	for _, r := range output.Results {
+		result.Findings = append(result.Findings, NewFinding(r))
+	}
+	for _, e := range output.Errors {
+		result.addError(e)
+	}
+	return result
+}
+
+// addError files a semgrep error under the right category
+func (r *ScanResult) addError(e Error) {
+	scanErr := ScanError{
+		Type:    string(e.Type),
+		Level:   e.Level,
+		RuleID:  e.RuleID,
+		Message: strings.TrimSpace(e.Message),
+		Path:    e.Path,
+	}
+
+	errType := strings.ToLower(scanErr.Type)
+	switch {
+	case strings.Contains(errType, "timeout") || strings.Contains(errType, "out of memory") || strings.Contains(errType, "stack overflow"):
+		r.Timeouts = append(r.Timeouts, scanErr)
+	case e.Path != "" && !strings.Contains(errType, "rule") && !strings.Contains(errType, "pattern") && !strings.Contains(errType, "yaml"):
+		r.ParseErrors = append(r.ParseErrors, scanErr)
+	default:
+		r.RuleErrors = append(r.RuleErrors, scanErr)
+	}
+}
+
+// Merge appends the findings and errors of other to r
+func (r *ScanResult) Merge(other *ScanResult) {
+	r.Findings = append(r.Findings, other.Findings...)
+	r.ParseErrors = append(r.ParseErrors, other.ParseErrors...)
+	r.Timeouts = append(r.Timeouts, other.Timeouts...)
+	r.Skipped = append(r.Skipped, other.Skipped...)
+	r.RuleErrors = append(r.RuleErrors, other.RuleErrors...)
+}
+
+// Unanalyzed returns one error per file that semgrep could not fully
+// analyze, sorted by path. Files skipped because no rule applies to them
+// are not included.
+func (r *ScanResult) Unanalyzed() []ScanError {
+	seen := make(map[string]bool)
+	var unanalyzed []ScanError
+	add := func(e ScanError) {
+		if e.Path == "" || seen[e.Path] {
+			return
+		}
+		seen[e.Path] = true
+		unanalyzed = append(unanalyzed, e)
+	}
+
+	for _, e := range r.ParseErrors {
+		add(e)
+	}
+	for _, e := range r.Timeouts {
+		add(e)
+	}
+	for _, skipped := range r.Skipped {
+		if irrelevantSkipReasons[skipped.Reason] {
+			continue
+		}
+		add(ScanError{Type: "Skipped", Message: skipped.Reason, Path: skipped.Path})
+	}
+
+	sort.Slice(unanalyzed, func(i, j int) bool {
+		return unanalyzed[i].Path < unanalyzed[j].Path
+	})
+	return unanalyzed
+}
+
+// GroupByRoot splits a result by the directory each finding and error was
+// reported under, e.g. the extracted gem directories of a batched scan.
+// Errors that aren't tied to a file, such as rule errors, apply to every root.
+func GroupByRoot(result *ScanResult, roots []string) map[string]*ScanResult {
+	grouped := make(map[string]*ScanResult, len(roots))
+	for _, root := range roots {
+		grouped[root] = &ScanResult{
+			Findings:   make([]*Finding, 0),
+			RuleErrors: result.RuleErrors,
+		}
+	}
+
+	rootOf := func(path string) *ScanResult {
+		for _, root := range roots {
+			if strings.HasPrefix(path, filepath.Clean(root)+string(filepath.Separator)) {
+				return grouped[root]
+			}
+		}
+		return nil
+	}
+
+	for _, f := range result.Findings {
+		if g := rootOf(f.Path); g != nil {
+			g.Findings = append(g.Findings, f)
+		}
+	}
+	for _, e := range result.ParseErrors {
+		if g := rootOf(e.Path); g != nil {
+			g.ParseErrors = append(g.ParseErrors, e)
+		}
+	}
+	for _, e := range result.Timeouts {
+		if g := rootOf(e.Path); g != nil {
+			g.Timeouts = append(g.Timeouts, e)
+		}
+	}
+	for _, skipped := range result.Skipped {
+		if g := rootOf(skipped.Path); g != nil {
+			g.Skipped = append(g.Skipped, skipped)
+		}
+	}
+	return grouped
+}
+
+// Display returns a formatted string representation of the error
+func (e ScanError) Display() string {
+	if e.Message == "" {
+		return e.Type
+	}
+	return e.Type + ": " + strings.Join(strings.Fields(e.Message), " ")
+}
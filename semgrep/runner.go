@@ -4,39 +4,108 @@ import (
 	"encoding/json"
 	"fmt"
 	"os/exec"
+	"strings"
 )
 
+// DefaultMaxArgBytes keeps batched invocations well under the kernel's argv limit
+const DefaultMaxArgBytes = 128 * 1024
+
 // Runner handles executing semgrep and parsing its results
 type Runner struct {
 	rulesPath string
-}
-
-// SemgrepOutput represents the JSON structure of semgrep's output
-type SemgrepOutput struct {
-	Results []map[string]interface{} `json:"results"`
+	// MaxArgBytes bounds the total size of the file arguments passed to a single semgrep invocation
+	MaxArgBytes int
+	// RuleIDs are the IDs of the rules in rulesPath. Semgrep prefixes rule IDs
+	// with the directories of their rule file, which are stripped again so
+	// IDs don't depend on where the rules were materialized.
+	RuleIDs []string
 }
 
 // NewRunner creates a new Runner instance
 func NewRunner(rulesPath string) *Runner {
-	if rulesPath == "" {
-		rulesPath = "./semgrep-rules"
-	}
 	return &Runner{
-		rulesPath: rulesPath,
+		rulesPath:   rulesPath,
+		MaxArgBytes: DefaultMaxArgBytes,
 	}
 }
 
-// Scan runs semgrep on the given files and returns the findings
-func (r *Runner) Scan(files []string) ([]*Finding, error) {
+// Scan runs semgrep on the given files and returns the findings along with
+// any files and rules it could not analyze
+func (r *Runner) Scan(files []string) (*ScanResult, error) {
 	if len(files) == 0 {
-		return []*Finding{}, nil
+		return &ScanResult{Findings: []*Finding{}}, nil
 	}
+	result, err := r.scanCLI(files)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range result.Findings {
+		f.RuleID = r.normalizeRuleID(f.RuleID)
+	}
+	return result, nil
+}
 
+// ScanBatch scans many files, typically from many gems, in as few semgrep
+// invocations as the argv limit allows. It always runs the CLI: `semgrep lsp`
+// can't be made to ignore nosemgrep comments or to say when a file's results
+// are final, so it isn't used until that can be verified.
+func (r *Runner) ScanBatch(files []string) (*ScanResult, error) {
+	result := &ScanResult{Findings: []*Finding{}}
+	for _, chunk := range r.chunk(files) {
+		chunkResult, err := r.Scan(chunk)
+		if err != nil {
+			return nil, err
+		}
+		result.Merge(chunkResult)
+	}
+	return result, nil
+}
+
+// normalizeRuleID strips the path prefix semgrep adds to a known rule ID
+func (r *Runner) normalizeRuleID(checkID string) string {
+	for _, id := range r.RuleIDs {
+		if checkID == id || strings.HasSuffix(checkID, "."+id) {
+			return id
+		}
+	}
+	return checkID
+}
+
+// chunk splits files into groups whose combined argument size stays within MaxArgBytes
+func (r *Runner) chunk(files []string) [][]string {
+	maxBytes := r.MaxArgBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxArgBytes
+	}
+
+	var chunks [][]string
+	var current []string
+	size := 0
+	for _, file := range files {
+		if len(current) > 0 && size+len(file)+1 > maxBytes {
+			chunks = append(chunks, current)
+			current = nil
+			size = 0
+		}
+		current = append(current, file)
+		size += len(file) + 1
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// scanCLI runs a single semgrep process over the given files
+func (r *Runner) scanCLI(files []string) (*ScanResult, error) {
 	// Build the command
+	// nosemgrep comments in the scanned code are ignored, since malicious
+	// code could use them to hide itself
 	args := []string{
 		"--config", r.rulesPath,
 		"--json",
 		"--quiet",
+		"--disable-nosem",
 	}
 	args = append(args, files...)
 
@@ -44,25 +113,30 @@ func (r *Runner) Scan(files []string) ([]*Finding, error) {
 	cmd := exec.Command("semgrep", args...)
 	output, err := cmd.Output()
 
-	// Handle command execution errors
-	if err != nil {
+	// Semgrep exits non-zero when some files fail to parse but still reports
+	// the findings for the rest, so only give up if there is no usable output
+	var semgrepOutput SemgrepOutput
+	if parseErr := json.Unmarshal(output, &semgrepOutput); parseErr != nil || len(output) == 0 {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			return nil, fmt.Errorf("semgrep failed: %s", string(exitErr.Stderr))
 		}
-		return nil, fmt.Errorf("failed to run semgrep: %w", err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run semgrep: %w", err)
+		}
+		return nil, fmt.Errorf("failed to parse semgrep output: %w", parseErr)
 	}
 
-	// Parse the JSON output
-	var semgrepOutput SemgrepOutput
-	if err := json.Unmarshal(output, &semgrepOutput); err != nil {
-		return nil, fmt.Errorf("failed to parse semgrep output: %w", err)
-	}
+	result := NewScanResult(semgrepOutput)
 
-	// Convert results to findings
-	findings := make([]*Finding, 0, len(semgrepOutput.Results))
-	for _, result := range semgrepOutput.Results {
-		findings = append(findings, NewFinding(result))
+	// A failed run that reports nothing about any file didn't scan anything
+	if err != nil && len(result.Findings) == 0 && len(semgrepOutput.Paths.Scanned) == 0 &&
+		len(result.ParseErrors) == 0 && len(result.Timeouts) == 0 {
+		messages := make([]string, 0, len(result.RuleErrors))
+		for _, e := range result.RuleErrors {
+			messages = append(messages, e.Display())
+		}
+		return nil, fmt.Errorf("semgrep failed: %s", strings.Join(messages, "; "))
 	}
 
-	return findings, nil
+	return result, nil
 }
@@ -0,0 +1,313 @@
+package surface
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"whiskers/gem"
+	"whiskers/semgrep"
+)
+
+// Rule IDs reported by the install-time analysis
+const (
+	RuleInstallNetwork        = "whiskers-install-network-access"
+	RuleInstallShell          = "whiskers-install-shell-out"
+	RuleInstallFileWrite      = "whiskers-install-file-write"
+	RuleInstallNewFile        = "whiskers-install-new-file"
+	RuleInstallFirstExtension = "whiskers-install-first-extension"
+	RuleInstallOutsideGem     = "whiskers-install-extension-outside-gem"
+)
+
+// Regular expressions for install-time rules and file discovery
+var (
+	// Matches any kind of network access
+	installNetworkRegex = regexp.MustCompile(`Net::HTTP|Net::FTP|open-uri|OpenURI|URI\.open|TCPSocket|UDPSocket|Socket\.new|Resolv|HTTParty|Faraday|RestClient|Excon|Typhoeus|\b(?:curl|wget)\b|\b(?:https?|ftp)://`)
+	// Matches any kind of shell-out
+	installShellRegex = regexp.MustCompile(`(?:^|[^.\w])(?:system|exec|spawn)\b\s*[\(\s'"]|Kernel\.(?:system|exec|spawn)|IO\.popen|Open3\.|PTY\.spawn|%x[\[\{\(|]|` + "`[^`]+`")
+	// Matches file writes and filesystem changes
+	installWriteRegex = regexp.MustCompile(`File\.(?:write|open\([^)]*['"][wa]\+?b?['"]|symlink|chmod|chown|delete|unlink|rename)|IO\.write|FileUtils\.(?:cp|cp_r|mv|rm|rm_r|rm_rf|rm_f|mkdir|mkdir_p|ln|ln_s|ln_sf|install|chmod|chown|touch)|Dir\.mkdir`)
+	// Matches paths that point outside the extension build directory
+	outsideBuildDirRegex = regexp.MustCompile(`['"]/|['"]~|Dir\.home|ENV\[['"]HOME|\.\./|expand_path|Gem\.(?:dir|user_dir|bindir)|RbConfig`)
+	// Matches files loaded by install-time code, e.g. require_relative "helper"
+	installLoadRegex = regexp.MustCompile(`\b(?:require_relative|load|import|require)\s*\(?\s*['"]([^'"]+)['"]`)
+)
+
+// InstallSurface is the set of files in a gem that run at gem install time
+type InstallSurface struct {
+	Root       string
+	Extensions []string
+	Files      []string // paths relative to Root
+	// Outside are the extensions the metadata declares outside Root, which
+	// are left out of the surface rather than read from the host
+	Outside []string
+}
+
+// NewInstallSurface identifies every file under root executed at gem
+// install time: the gemspec extensions, extconf.rb and mkrf_conf.rb build
+// scripts, Rakefiles and the files they load
+func NewInstallSurface(root string, spec *gem.Spec) (*InstallSurface, error) {
+	surface := &InstallSurface{Root: root}
+	files := make(map[string]bool)
+
+	// Extensions declared by the gemspec are what RubyGems actually runs
+	if spec != nil {
+		for _, ext := range spec.Extensions {
+			relPath, ok := insideGem(root, ext)
+			if !ok || relPath == "." {
+				surface.Outside = append(surface.Outside, ext)
+				continue
+			}
+			surface.Extensions = append(surface.Extensions, relPath)
+		}
+	}
+
+	// Build scripts are included even when the metadata doesn't list them
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if isInstallScript(relPath) {
+			files[relPath] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", root, err)
+	}
+
+	for _, ext := range surface.Extensions {
+		files[ext] = true
+
+		switch filepath.Base(ext) {
+		case "Rakefile", "mkrf_conf.rb":
+			// Rake loads every task file in rakelib automatically
+			rakelib, _ := filepath.Glob(filepath.Join(root, filepath.Dir(ext), "rakelib", "*.rake"))
+			for _, task := range rakelib {
+				if relPath, err := filepath.Rel(root, task); err == nil {
+					files[relPath] = true
+				}
+			}
+		case "Cargo.toml":
+			// Cargo runs build scripts before compiling the extension
+			files[filepath.Join(filepath.Dir(ext), "build.rs")] = true
+		}
+	}
+
+	// Follow the files install-time code loads
+	queue := make([]string, 0, len(files))
+	for file := range files {
+		queue = append(queue, file)
+	}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		for _, loaded := range loadedFiles(root, file) {
+			if !files[loaded] {
+				files[loaded] = true
+				queue = append(queue, loaded)
+			}
+		}
+	}
+
+	// Only keep files that exist in this version
+	for file := range files {
+		if _, err := os.Stat(filepath.Join(root, file)); err == nil {
+			surface.Files = append(surface.Files, file)
+		}
+	}
+	sort.Strings(surface.Files)
+
+	return surface, nil
+}
+
+// insideGem cleans a path taken from the gem's metadata relative to root,
+// returning false for absolute paths and paths that climb out of root
+func insideGem(root, path string) (string, bool) {
+	if filepath.IsAbs(path) {
+		return "", false
+	}
+	relPath, err := filepath.Rel(root, filepath.Join(root, path))
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return relPath, true
+}
+
+// isInstallScript returns true for build scripts RubyGems may run at install time
+func isInstallScript(relPath string) bool {
+	base := filepath.Base(relPath)
+	if base == "mkrf_conf.rb" && filepath.Dir(relPath) == "." {
+		return true
+	}
+	if !strings.HasPrefix(relPath, "ext"+string(filepath.Separator)) {
+		return false
+	}
+	switch base {
+	case "extconf.rb", "mkrf_conf.rb", "Rakefile", "CMakeLists.txt", "configure", "Cargo.toml", "build.rs":
+		return true
+	}
+	return strings.HasSuffix(base, ".rake")
+}
+
+// loadedFiles returns the files in root loaded by file via require_relative, load or import
+func loadedFiles(root, file string) []string {
+	content, err := os.ReadFile(filepath.Join(root, file))
+	if err != nil {
+		return nil
+	}
+
+	var loaded []string
+	for _, match := range installLoadRegex.FindAllStringSubmatch(string(content), -1) {
+		target := match[1]
+		if filepath.Ext(target) == "" {
+			target += ".rb"
+		}
+
+		// Resolve relative to the loading file, then to the gem root
+		for _, candidate := range []string{
+			filepath.Join(filepath.Dir(file), target),
+			filepath.Clean(target),
+		} {
+			if strings.HasPrefix(candidate, "..") || filepath.IsAbs(candidate) {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(root, candidate)); err == nil {
+				loaded = append(loaded, candidate)
+				break
+			}
+		}
+	}
+	return loaded
+}
+
+// Contains returns true if the path, relative to Root, runs at install time
+func (s *InstallSurface) Contains(relPath string) bool {
+	i := sort.SearchStrings(s.Files, relPath)
+	return i < len(s.Files) && s.Files[i] == relPath
+}
+
+// Scan applies the stricter install-time rules to the given files that are
+// part of the install surface. Any network access, shell-out, or file write
+// outside the build directory is reported with ERROR severity.
+func (s *InstallSurface) Scan(files []string) ([]*semgrep.Finding, error) {
+	findings := make([]*semgrep.Finding, 0)
+	for _, file := range files {
+		relPath, err := filepath.Rel(s.Root, file)
+		if err != nil || !s.Contains(relPath) {
+			continue
+		}
+
+		fileFindings, err := scanInstallFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze %s: %w", file, err)
+		}
+		findings = append(findings, fileFindings...)
+	}
+	return findings, nil
+}
+
+// scanInstallFile applies the install-time rules to a single file
+func scanInstallFile(path string) ([]*semgrep.Finding, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var findings []*semgrep.Finding
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		code := strings.TrimSpace(line)
+		if strings.HasPrefix(code, "#") {
+			continue
+		}
+
+		if installNetworkRegex.MatchString(code) {
+			findings = append(findings, newFinding(path, lineNo, line, RuleInstallNetwork,
+				"Network access in code that runs at gem install time"))
+		}
+		if installShellRegex.MatchString(code) {
+			findings = append(findings, newFinding(path, lineNo, line, RuleInstallShell,
+				"Shell command execution in code that runs at gem install time"))
+		}
+		if installWriteRegex.MatchString(code) && outsideBuildDirRegex.MatchString(code) {
+			findings = append(findings, newFinding(path, lineNo, line, RuleInstallFileWrite,
+				"File write outside the build directory in code that runs at gem install time"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return findings, nil
+}
+
+// CompareInstallSurfaces reports changes to the install-time surface
+// between two versions of a gem. Findings point into the after version.
+func CompareInstallSurfaces(change gem.VersionChange, before, after *InstallSurface) []*semgrep.Finding {
+	var findings []*semgrep.Finding
+
+	// A gem that starts building native extensions gains install-time execution
+	if len(before.Extensions) == 0 && len(after.Extensions) > 0 {
+		bump := change.Bump()
+		finding := newFinding(filepath.Join(after.Root, after.Extensions[0]), 1, strings.Join(after.Extensions, ", "),
+			RuleInstallFirstExtension,
+			fmt.Sprintf("Gem adds its first native extension in a %s release (%s → %s)",
+				bump, change.Before.Version, change.After.Version))
+		if bump != gem.BumpPatch && bump != gem.BumpPrerelease {
+			finding.Severity = "WARNING"
+		}
+		findings = append(findings, finding)
+	}
+
+	// Extensions outside the gem can't be analyzed, and a gem has no reason to declare them
+	for _, ext := range after.Outside {
+		if slices.Contains(before.Outside, ext) {
+			continue
+		}
+		findings = append(findings, newFinding(filepath.Join(after.Root, "metadata.gz"), 1, ext, RuleInstallOutsideGem,
+			fmt.Sprintf("Gem declares an extension outside the gem (%s), which was not analyzed", ext)))
+	}
+
+	// Any file that newly runs at install time deserves a look
+	for _, file := range after.Files {
+		if before.Contains(file) {
+			continue
+		}
+		findings = append(findings, newFinding(filepath.Join(after.Root, file), 1, file, RuleInstallNewFile,
+			"New file runs at gem install time"))
+	}
+
+	return findings
+}
+
+// newFinding creates a high severity Finding for a line of a file
+func newFinding(path string, line int, lines, ruleID, message string) *semgrep.Finding {
+	return &semgrep.Finding{
+		RuleID:   ruleID,
+		Message:  message,
+		Lines:    strings.TrimSpace(lines),
+		Line:     line,
+		Path:     path,
+		Severity: "ERROR",
+		Metadata: semgrep.Metadata{
+			Category:   "security",
+			Confidence: "HIGH",
+		},
+	}
+}
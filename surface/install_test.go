@@ -0,0 +1,156 @@
+package surface
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"whiskers/gem"
+)
+
+// writeGem creates the files of an extracted gem under a temporary root
+func writeGem(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	writeFiles(t, root, files)
+	return root
+}
+
+// writeFiles creates files under root
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fromSlash converts slash-separated paths to the OS separator
+func fromSlash(paths []string) []string {
+	var converted []string
+	for _, path := range paths {
+		converted = append(converted, filepath.FromSlash(path))
+	}
+	return converted
+}
+
+func TestNewInstallSurface(t *testing.T) {
+	root := writeGem(t, map[string]string{
+		"ext/foo/extconf.rb":    "require_relative 'helper'\n",
+		"ext/foo/helper.rb":     "",
+		"ext/bar/Cargo.toml":    "",
+		"ext/bar/build.rs":      "",
+		"Rakefile":              "",
+		"rakelib/compile.rake":  "",
+		"lib/foo.rb":            "",
+		"mkrf_conf.rb":          "",
+		"ext/foo/unrelated.txt": "",
+	})
+
+	tests := []struct {
+		name       string
+		extensions []string
+		want       []string
+		outside    []string
+	}{
+		{
+			name: "no metadata",
+			want: []string{"ext/bar/Cargo.toml", "ext/bar/build.rs", "ext/foo/extconf.rb", "ext/foo/helper.rb", "mkrf_conf.rb"},
+		},
+		{
+			name:       "declared extensions",
+			extensions: []string{"Rakefile", "./ext/bar/Cargo.toml"},
+			want:       []string{"Rakefile", "ext/bar/Cargo.toml", "ext/bar/build.rs", "ext/foo/extconf.rb", "ext/foo/helper.rb", "mkrf_conf.rb", "rakelib/compile.rake"},
+		},
+		{
+			name:       "extensions outside the gem",
+			extensions: []string{"../../../etc/passwd", "/etc/passwd", "ext/../../Rakefile", ".", "ext/foo/extconf.rb"},
+			want:       []string{"ext/bar/Cargo.toml", "ext/bar/build.rs", "ext/foo/extconf.rb", "ext/foo/helper.rb", "mkrf_conf.rb"},
+			outside:    []string{"../../../etc/passwd", "/etc/passwd", "ext/../../Rakefile", "."},
+		},
+		{
+			name:       "derived files outside the gem",
+			extensions: []string{"../Cargo.toml", "../Rakefile"},
+			want:       []string{"ext/bar/Cargo.toml", "ext/bar/build.rs", "ext/foo/extconf.rb", "ext/foo/helper.rb", "mkrf_conf.rb"},
+			outside:    []string{"../Cargo.toml", "../Rakefile"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var spec *gem.Spec
+			if tt.extensions != nil {
+				spec = &gem.Spec{Extensions: tt.extensions}
+			}
+			s, err := NewInstallSurface(root, spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(s.Files, fromSlash(tt.want)) {
+				t.Errorf("Files = %v, want %v", s.Files, tt.want)
+			}
+			if !slices.Equal(s.Outside, tt.outside) {
+				t.Errorf("Outside = %v, want %v", s.Outside, tt.outside)
+			}
+			for _, ext := range s.Extensions {
+				if !slices.Contains(s.Files, ext) {
+					t.Errorf("extension %s is not in Files", ext)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareInstallSurfaces(t *testing.T) {
+	change := gem.VersionChange{
+		Name:   "foo",
+		Before: gem.NewGem("foo", "1.0.0", gem.DefaultSource()),
+		After:  gem.NewGem("foo", "1.0.1", gem.DefaultSource()),
+	}
+	tests := []struct {
+		name          string
+		before, after InstallSurface
+		want          []string
+	}{
+		{
+			name:   "unchanged",
+			before: InstallSurface{Files: []string{"ext/extconf.rb"}, Extensions: []string{"ext/extconf.rb"}},
+			after:  InstallSurface{Files: []string{"ext/extconf.rb"}, Extensions: []string{"ext/extconf.rb"}},
+		},
+		{
+			name:  "first extension",
+			after: InstallSurface{Files: []string{"ext/extconf.rb"}, Extensions: []string{"ext/extconf.rb"}},
+			want:  []string{RuleInstallFirstExtension, RuleInstallNewFile},
+		},
+		{
+			name:  "extension outside the gem",
+			after: InstallSurface{Outside: []string{"../../etc/passwd"}},
+			want:  []string{RuleInstallOutsideGem},
+		},
+		{
+			name:   "extension already outside the gem",
+			before: InstallSurface{Outside: []string{"../../etc/passwd"}},
+			after:  InstallSurface{Outside: []string{"../../etc/passwd"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.before.Root, tt.after.Root = "/before", "/after"
+			var got []string
+			for _, f := range CompareInstallSurfaces(change, &tt.before, &tt.after) {
+				got = append(got, f.RuleID)
+				// Findings must stay inside the gem so they can be made relative to it
+				if err := f.Rebase(tt.after.Root); err != nil {
+					t.Errorf("finding %s: %v", f.RuleID, err)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("rules = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
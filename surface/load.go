@@ -0,0 +1,236 @@
+package surface
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"whiskers/gem"
+	"whiskers/semgrep"
+)
+
+// Regular expressions for resolving the require graph
+var (
+	// Matches require "foo/bar", with or without parentheses
+	requireRegex = regexp.MustCompile(`^\s*(?:Kernel\.)?require\s*\(?\s*['"]([^'"]+)['"]`)
+	// Matches require_relative "bar"
+	requireRelativeRegex = regexp.MustCompile(`^\s*require_relative\s*\(?\s*['"]([^'"]+)['"]`)
+	// Matches autoload :Bar, "foo/bar" and Foo.autoload(:Bar, "foo/bar")
+	autoloadRegex = regexp.MustCompile(`\bautoload\s*\(?\s*:\w+\s*,\s*['"]([^'"]+)['"]`)
+	// Matches load "foo/bar.rb"
+	loadRegex = regexp.MustCompile(`^\s*(?:Kernel\.)?load\s*\(?\s*['"]([^'"]+)['"]`)
+	// Matches requires of every file matching a glob, e.g. Dir[...].each { |f| require f }
+	globRequireRegex = regexp.MustCompile(`(?:Dir\[|Dir\.glob).*\b(?:require|require_relative|load)\b`)
+	// Matches double and single quoted string literals
+	stringLiteralRegex = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"|'((?:[^'\\]|\\.)*)'`)
+	// Matches the start of a method definition
+	defRegex = regexp.MustCompile(`^(\s*)def\s`)
+	// Matches method definitions that end on the same line, e.g. def foo = 1 or def foo; end
+	oneLineDefRegex = regexp.MustCompile(`^\s*def\s+[\w.]+[?!]?(?:\s*\([^)]*\)\s*|\s+)=\s*[^=~>(]|;\s*end\s*$`)
+)
+
+// LoadSurface is the set of files in a gem that run as soon as the gem is
+// required: the main lib/<name>.rb and everything it loads at top level
+type LoadSurface struct {
+	Root        string
+	Entrypoints []string
+	Files       []string // paths relative to Root
+}
+
+// NewLoadSurface statically resolves the require graph of the gem under
+// root, starting from its entrypoints in the spec's require_paths
+func NewLoadSurface(root, name string, spec *gem.Spec) (*LoadSurface, error) {
+	surface := &LoadSurface{Root: root}
+
+	requirePaths := []string{"lib"}
+	if spec != nil && len(spec.RequirePaths) > 0 {
+		requirePaths = spec.RequirePaths
+	}
+
+	// Bundler requires the gem by name, e.g. "foo-bar" as foo-bar.rb or foo/bar.rb
+	for _, requirePath := range requirePaths {
+		for _, candidate := range []string{name, strings.ReplaceAll(name, "-", "/"), strings.ReplaceAll(name, "-", "_")} {
+			entrypoint, ok := insideGem(root, filepath.Join(requirePath, candidate+".rb"))
+			if !ok {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(root, entrypoint)); err == nil && !slices.Contains(surface.Entrypoints, entrypoint) {
+				surface.Entrypoints = append(surface.Entrypoints, entrypoint)
+			}
+		}
+	}
+
+	// Walk the require graph breadth first
+	reachable := make(map[string]bool)
+	queue := append([]string{}, surface.Entrypoints...)
+	for _, entrypoint := range queue {
+		reachable[entrypoint] = true
+	}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+
+		required, err := topLevelRequires(root, file, requirePaths)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve requires in %s: %w", file, err)
+		}
+		for _, next := range required {
+			if !reachable[next] {
+				reachable[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	for file := range reachable {
+		surface.Files = append(surface.Files, file)
+	}
+	sort.Strings(surface.Files)
+
+	return surface, nil
+}
+
+// topLevelRequires returns the files in the gem loaded by file outside of
+// method bodies, which are the requires that run when file is loaded
+func topLevelRequires(root, file string, requirePaths []string) ([]string, error) {
+	f, err := os.Open(filepath.Join(root, file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var required []string
+	defIndent := -1
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Skip method bodies, which only run when the method is called
+		if defIndent >= 0 {
+			if trimmed == "end" && len(line)-len(strings.TrimLeft(line, " \t")) == defIndent {
+				defIndent = -1
+			}
+			continue
+		}
+		if match := defRegex.FindStringSubmatch(line); match != nil {
+			if !oneLineDefRegex.MatchString(line) {
+				defIndent = len(match[1])
+			}
+			continue
+		}
+
+		if match := requireRelativeRegex.FindStringSubmatch(line); match != nil {
+			required = appendResolved(required, root, []string{filepath.Dir(file)}, match[1])
+			continue
+		}
+		if match := requireRegex.FindStringSubmatch(line); match != nil {
+			required = appendResolved(required, root, requirePaths, match[1])
+			continue
+		}
+		if match := loadRegex.FindStringSubmatch(line); match != nil {
+			required = appendResolved(required, root, append([]string{"."}, requirePaths...), match[1])
+			continue
+		}
+		for _, match := range autoloadRegex.FindAllStringSubmatch(line, -1) {
+			required = appendResolved(required, root, requirePaths, match[1])
+		}
+
+		// Globbed requires can't be resolved exactly, so everything under the globbed directory counts
+		if globRequireRegex.MatchString(line) {
+			required = append(required, rubyFilesUnder(root, globDir(root, file, line))...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return required, nil
+}
+
+// appendResolved resolves a required feature against the search paths and
+// appends it if it exists inside the gem. Requires of other gems are ignored.
+func appendResolved(required []string, root string, searchPaths []string, feature string) []string {
+	if filepath.Ext(feature) == "" {
+		feature += ".rb"
+	}
+	for _, searchPath := range searchPaths {
+		candidate := filepath.Join(searchPath, feature)
+		if strings.HasPrefix(candidate, "..") || filepath.IsAbs(candidate) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(root, candidate)); err == nil {
+			return append(required, candidate)
+		}
+	}
+	return required
+}
+
+// globDir guesses the directory a globbed require reads from, using the
+// string literals before the first wildcard, e.g. File.join(__dir__, "plugins", "*.rb")
+func globDir(root, file, line string) string {
+	var parts []string
+	for _, match := range stringLiteralRegex.FindAllStringSubmatch(line, -1) {
+		literal := match[1] + match[2]
+		if strings.Contains(literal, "*") {
+			if i := strings.Index(literal, "*"); i > 0 {
+				parts = append(parts, filepath.Dir(literal[:i]+"x"))
+			}
+			break
+		}
+		parts = append(parts, literal)
+	}
+
+	dir := filepath.Join(append([]string{filepath.Dir(file)}, parts...)...)
+	if info, err := os.Stat(filepath.Join(root, dir)); err == nil && info.IsDir() && !strings.HasPrefix(dir, "..") {
+		return dir
+	}
+	return filepath.Dir(file)
+}
+
+// rubyFilesUnder returns every .rb file under dir, relative to root
+func rubyFilesUnder(root, dir string) []string {
+	var files []string
+	filepath.Walk(filepath.Join(root, dir), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".rb" {
+			return nil
+		}
+		if relPath, err := filepath.Rel(root, path); err == nil {
+			files = append(files, relPath)
+		}
+		return nil
+	})
+	return files
+}
+
+// Contains returns true if the path, relative to Root, runs at load time
+func (s *LoadSurface) Contains(relPath string) bool {
+	i := sort.SearchStrings(s.Files, relPath)
+	return i < len(s.Files) && s.Files[i] == relPath
+}
+
+// Mark flags findings whose file runs at load time. Finding paths must be
+// absolute paths under Root.
+func (s *LoadSurface) Mark(findings []*semgrep.Finding) {
+	for _, f := range findings {
+		if relPath, err := filepath.Rel(s.Root, f.Path); err == nil && s.Contains(relPath) {
+			f.LoadTime = true
+		}
+	}
+}
+
+// Rank orders findings so that those in load-time code come first,
+// keeping the existing order otherwise
+func Rank(findings []*semgrep.Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].LoadTime && !findings[j].LoadTime
+	})
+}
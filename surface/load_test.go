@@ -0,0 +1,76 @@
+package surface
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"whiskers/gem"
+)
+
+func TestNewLoadSurface(t *testing.T) {
+	// A file outside the gem that an escaping require path would reach
+	parent := writeGem(t, map[string]string{
+		"outside/foo.rb":    "require_relative 'secret'\n",
+		"outside/secret.rb": "",
+	})
+	root := filepath.Join(parent, "foo-1.0.0")
+	writeFiles(t, root, map[string]string{
+		"lib/foo.rb": `require "foo/version"
+require_relative "foo/core"
+autoload :Plugin, "foo/plugin"
+require "json"
+
+def self.setup
+  require "foo/lazy"
+end
+`,
+		"lib/foo/version.rb": "",
+		"lib/foo/core.rb":    "load 'lib/foo/extra.rb'\n",
+		"lib/foo/extra.rb":   "",
+		"lib/foo/plugin.rb":  "",
+		"lib/foo/lazy.rb":    "",
+		"lib/foo/unused.rb":  "",
+		"src/foo.rb":         "",
+	})
+
+	tests := []struct {
+		name         string
+		requirePaths []string
+		entrypoints  []string
+		files        []string
+	}{
+		{
+			name:        "default require path",
+			entrypoints: []string{"lib/foo.rb"},
+			files:       []string{"lib/foo.rb", "lib/foo/core.rb", "lib/foo/extra.rb", "lib/foo/plugin.rb", "lib/foo/version.rb"},
+		},
+		{
+			name:         "several require paths",
+			requirePaths: []string{"src", "lib"},
+			entrypoints:  []string{"src/foo.rb", "lib/foo.rb"},
+			files:        []string{"lib/foo.rb", "lib/foo/core.rb", "lib/foo/extra.rb", "lib/foo/plugin.rb", "lib/foo/version.rb", "src/foo.rb"},
+		},
+		{
+			name:         "require paths outside the gem",
+			requirePaths: []string{"../outside", filepath.Join(parent, "outside")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var spec *gem.Spec
+			if tt.requirePaths != nil {
+				spec = &gem.Spec{RequirePaths: tt.requirePaths}
+			}
+			s, err := NewLoadSurface(root, "foo", spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(s.Entrypoints, fromSlash(tt.entrypoints)) {
+				t.Errorf("Entrypoints = %v, want %v", s.Entrypoints, tt.entrypoints)
+			}
+			if !slices.Equal(s.Files, fromSlash(tt.files)) {
+				t.Errorf("Files = %v, want %v", s.Files, tt.files)
+			}
+		})
+	}
+}
@@ -5,14 +5,16 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
 // FileDiff represents the differences between two directories
 type FileDiff struct {
-	Added   []string
-	Removed []string
-	Changed []string
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
 }
 
 // ComparePaths compares two directory paths and returns lists of added, removed, and changed files
@@ -77,7 +79,7 @@ func getFileMap(root string, ignoreFiles []string) (map[string]string, error) {
 		}
 
 		// Skip ignored files
-		if shouldIgnore(info.Name(), ignoreFiles) {
+		if shouldIgnore(relPath, ignoreFiles) {
 			return nil
 		}
 
@@ -98,10 +100,18 @@ func getFileMap(root string, ignoreFiles []string) (map[string]string, error) {
 	return files, nil
 }
 
-// shouldIgnore returns true if the filename matches any of the ignore patterns
-func shouldIgnore(filename string, ignoreFiles []string) bool {
+// shouldIgnore returns true if the file matches any of the ignore patterns.
+// A pattern is a glob matched against the file's name, or against its whole
+// relative path if the pattern contains a slash, e.g. "*.md" or "test/*".
+func shouldIgnore(relPath string, ignoreFiles []string) bool {
+	relPath = filepath.ToSlash(relPath)
+	name := path.Base(relPath)
 	for _, ignore := range ignoreFiles {
-		if filename == ignore {
+		target := name
+		if strings.Contains(ignore, "/") {
+			target = relPath
+		}
+		if matched, err := path.Match(ignore, target); err == nil && matched {
 			return true
 		}
 	}
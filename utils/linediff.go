@@ -0,0 +1,99 @@
+package utils
+
+// LineOp is the kind of a line in a line diff
+type LineOp int
+
+// Line diff operations
+const (
+	LineEqual LineOp = iota
+	LineDeleted
+	LineInserted
+)
+
+// maxDiffCells bounds the size of the table DiffLines fills in. Larger
+// differences are reported as every old line deleted and every new line
+// inserted, which is correct but not minimal.
+const maxDiffCells = 4_000_000
+
+// DiffLine is a line of a line diff. Old and New are 1-based line numbers in
+// each version, or 0 for lines that only exist in the other one.
+type DiffLine struct {
+	Op   LineOp
+	Old  int
+	New  int
+	Text string
+}
+
+// DiffLines returns a minimal line diff that turns before into after
+func DiffLines(before, after []string) []DiffLine {
+	// Lines shared at the start and end don't need the quadratic search
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+
+	diff := make([]DiffLine, 0, len(before)+len(after))
+	for i := 0; i < prefix; i++ {
+		diff = append(diff, DiffLine{Op: LineEqual, Old: i + 1, New: i + 1, Text: before[i]})
+	}
+
+	a := before[prefix : len(before)-suffix]
+	b := after[prefix : len(after)-suffix]
+	if len(a)*len(b) > maxDiffCells {
+		for i, line := range a {
+			diff = append(diff, DiffLine{Op: LineDeleted, Old: prefix + i + 1, Text: line})
+		}
+		for j, line := range b {
+			diff = append(diff, DiffLine{Op: LineInserted, New: prefix + j + 1, Text: line})
+		}
+	} else {
+		diff = append(diff, lcsDiff(a, b, prefix)...)
+	}
+
+	for k := suffix; k > 0; k-- {
+		i, j := len(before)-k, len(after)-k
+		diff = append(diff, DiffLine{Op: LineEqual, Old: i + 1, New: j + 1, Text: before[i]})
+	}
+	return diff
+}
+
+// lcsDiff diffs two runs of lines, both starting after offset shared lines,
+// from a table of the lengths of their longest common subsequences
+func lcsDiff(a, b []string, offset int) []DiffLine {
+	// lengths[i][j] is the LCS length of a[i:] and b[j:]
+	width := len(b) + 1
+	lengths := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i*width+j] = lengths[(i+1)*width+j+1] + 1
+			} else {
+				lengths[i*width+j] = max(lengths[(i+1)*width+j], lengths[i*width+j+1])
+			}
+		}
+	}
+
+	var diff []DiffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff = append(diff, DiffLine{Op: LineEqual, Old: offset + i + 1, New: offset + j + 1, Text: a[i]})
+			i++
+			j++
+		// Deletions go first, so a replaced block reads old then new
+		case i < len(a) && (j == len(b) || lengths[(i+1)*width+j] >= lengths[i*width+j+1]):
+			diff = append(diff, DiffLine{Op: LineDeleted, Old: offset + i + 1, Text: a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: LineInserted, New: offset + j + 1, Text: b[j]})
+			j++
+		}
+	}
+	return diff
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// formatDiff writes a diff as space-separated lines prefixed with " ", "-" or "+"
+func formatDiff(diff []DiffLine) string {
+	parts := make([]string, 0, len(diff))
+	for _, d := range diff {
+		parts = append(parts, [...]string{" ", "-", "+"}[d.Op]+d.Text)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   string
+	}{
+		{"empty", "", "", ""},
+		{"equal", "a b c", "a b c", " a  b  c"},
+		{"all added", "", "a b", "+a +b"},
+		{"all deleted", "a b", "", "-a -b"},
+		{"insert in middle", "a c", "a b c", " a +b  c"},
+		{"delete at start", "a b c", "b c", "-a  b  c"},
+		{"insert at end", "a b", "a b c", " a  b +c"},
+		{"replace reads old then new", "a b c", "a x c", " a -b +x  c"},
+		{"repeated lines", "a a b", "a b b", " a -a +b  b"},
+		{"moved line", "a b c d", "b c d a", "-a  b  c  d +a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatDiff(DiffLines(strings.Fields(tt.before), strings.Fields(tt.after)))
+			if got != tt.want {
+				t.Errorf("DiffLines(%q, %q) = %q, want %q", tt.before, tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesNumbers(t *testing.T) {
+	want := []DiffLine{
+		{LineEqual, 1, 1, "a"},
+		{LineDeleted, 2, 0, "b"},
+		{LineInserted, 0, 2, "x"},
+		{LineInserted, 0, 3, "y"},
+		{LineEqual, 3, 4, "c"},
+	}
+	if got := DiffLines([]string{"a", "b", "c"}, []string{"a", "x", "y", "c"}); !slices.Equal(got, want) {
+		t.Errorf("DiffLines = %+v, want %+v", got, want)
+	}
+}
+
+// Diffs too large for the table delete every old line and insert every new one
+func TestDiffLinesLarge(t *testing.T) {
+	var before, after []string
+	for i := range 2100 {
+		before = append(before, fmt.Sprintf("old %d", i))
+		after = append(after, fmt.Sprintf("new %d", i))
+	}
+	before = append([]string{"same"}, before...)
+	after = append([]string{"same"}, after...)
+
+	diff := DiffLines(before, after)
+	if len(diff) != 1+2*2100 {
+		t.Fatalf("got %d lines, want %d", len(diff), 1+2*2100)
+	}
+	if diff[0].Op != LineEqual || diff[1].Op != LineDeleted || diff[1].Old != 2 ||
+		diff[2100].Op != LineDeleted || diff[2101].Op != LineInserted || diff[2101].New != 2 {
+		t.Errorf("unexpected diff: %+v %+v %+v %+v", diff[0], diff[1], diff[2100], diff[2101])
+	}
+}
+
+// Applying a diff must give back both versions
+func TestDiffLinesReconstructs(t *testing.T) {
+	tests := [][2]string{
+		{"a b c a b b a", "c b a b a c"},
+		{"x y z", "z y x"},
+		{"a a a", "a"},
+		{"1 2 3 4 5", "1 3 5 7"},
+	}
+	for _, tt := range tests {
+		before, after := strings.Fields(tt[0]), strings.Fields(tt[1])
+		var old, new []string
+		for _, d := range DiffLines(before, after) {
+			if d.Op != LineInserted {
+				old = append(old, d.Text)
+				if d.Old != len(old) {
+					t.Errorf("%q: line %q has Old %d, want %d", tt, d.Text, d.Old, len(old))
+				}
+			}
+			if d.Op != LineDeleted {
+				new = append(new, d.Text)
+				if d.New != len(new) {
+					t.Errorf("%q: line %q has New %d, want %d", tt, d.Text, d.New, len(new))
+				}
+			}
+		}
+		if !slices.Equal(old, before) || !slices.Equal(new, after) {
+			t.Errorf("%q: diff rebuilds %v and %v", tt, old, new)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package utils
+
+import "fmt"
+
+// Plural formats a count with a noun, e.g. "1 gem" or "3 gems"
+func Plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}